@@ -0,0 +1,132 @@
+package ndt7
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// StartBidirectional is like StartDownload but runs the download and the
+// upload at the same time, using the same server, so that each direction
+// is measured while the other one is loading the link. The channel carries
+// the measurements of both directions, whose Test field tells them apart.
+// Use BidirectionalResults to obtain the results of each direction.
+//
+// Failing to start either direction is fatal, in which case we close the
+// connection we may have already established. Each direction uses a single
+// connection and no latency probing: starting the test fails with
+// ErrInvalidTestOptions if c.DownloadStreams or c.UploadStreams are
+// greater than one, or if c.TestOptions.ProbeInterval is positive.
+func (c *Client) StartBidirectional(ctx context.Context) (<-chan spec.Measurement, error) {
+	dl := c.newResults(c.bidirectional, spec.TestDownload)
+	ul := c.newResults(c.bidirectional, spec.TestUpload)
+	// The records of both directions belong to the bidirectional test.
+	c.mu.Lock()
+	dl.test, ul.test = spec.TestBidirectional, spec.TestBidirectional
+	ul.started = dl.started
+	c.mu.Unlock()
+	if c.Replayer != nil {
+		return c.replay(spec.TestBidirectional, map[spec.TestKind]*LatestMeasurements{
+			spec.TestDownload: dl,
+			spec.TestUpload:   ul,
+		})
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if err := c.validateBidirectional(); err != nil {
+		return nil, err
+	}
+	c.refreshTargets(params.DownloadURLPath)
+	dlconn, u, err := c.dial(ctx, params.DownloadURLPath, dl, c.TestOptions)
+	if err != nil {
+		return nil, err
+	}
+	su, err := c.siblingURL(u, params.UploadURLPath)
+	if err != nil {
+		dlconn.Close()
+		return nil, err
+	}
+	ulconn, err := c.tryConnect(ctx, su, ul, c.TestOptions)
+	if err != nil {
+		dlconn.Close()
+		return nil, err
+	}
+	dlch := make(chan spec.Measurement)
+	go c.collectData(ctx, c.download, dlconn, dl, dlch, c.TestOptions, 2)
+	ulch := make(chan spec.Measurement)
+	go c.collectData(ctx, c.upload, ulconn, ul, ulch, c.TestOptions, 2)
+	return merge(dlch, ulch), nil
+}
+
+// BidirectionalResults is like Results but returns the results of the
+// download and of the upload run by the latest StartBidirectional.
+func (c *Client) BidirectionalResults() map[spec.TestKind]*LatestMeasurements {
+	return c.snapshot(c.bidirectional)
+}
+
+// validateBidirectional returns an error wrapping ErrInvalidTestOptions if
+// c uses the multi-stream or latency probing options, which the
+// bidirectional test does not support.
+func (c *Client) validateBidirectional() error {
+	if c.DownloadStreams > 1 || c.UploadStreams > 1 {
+		return fmt.Errorf("%w: the bidirectional test does not support multiple streams",
+			ErrInvalidTestOptions)
+	}
+	if c.TestOptions.ProbeInterval > 0 {
+		return fmt.Errorf("%w: the bidirectional test does not support latency probing",
+			ErrInvalidTestOptions)
+	}
+	return nil
+}
+
+// siblingURL returns the URL for the URL path p on the same server of u.
+// When u comes from the Locate API, we use the URL of the same target,
+// which contains the access token for p, and fail with ErrNoTargets if
+// the target has no such URL.
+func (c *Client) siblingURL(u, p string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, target := range c.targets {
+		for _, v := range target.URLs {
+			if v != u {
+				continue
+			}
+			if s, found := target.URLs[c.Scheme+"://"+p]; found {
+				return s, nil
+			}
+			return "", fmt.Errorf("%w: the target of %s has no %s URL", ErrNoTargets, u, p)
+		}
+	}
+	URL, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	URL.Path = p
+	return URL.String(), nil
+}
+
+// merge returns a channel emitting the measurements of all the given
+// channels, which is closed when all the given channels are closed.
+func merge(chs ...<-chan spec.Measurement) <-chan spec.Measurement {
+	out := make(chan spec.Measurement)
+	wg := &sync.WaitGroup{}
+	for _, ch := range chs {
+		wg.Add(1)
+		go func(ch <-chan spec.Measurement) {
+			defer wg.Done()
+			for m := range ch {
+				out <- m
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
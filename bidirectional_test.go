@@ -0,0 +1,247 @@
+package ndt7
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/locate/api/locate"
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/locatetest"
+	"github.com/m-lab/ndt-server/ndt7/ndt7test"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/internal/websocketx"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// newMeasuringClient returns a mocked client whose download and upload
+// emit a single server measurement for the respective test.
+func newMeasuringClient() *Client {
+	client := newMockedClient()
+	measure := func(test spec.TestKind) testFn {
+		return func(
+			ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+			opts params.TestOptions,
+		) error {
+			defer close(ch)
+			ch <- spec.Measurement{
+				ConnectionInfo: &spec.ConnectionInfo{UUID: string(test)},
+				Origin:         spec.OriginServer,
+				Test:           test,
+			}
+			return nil
+		}
+	}
+	client.download = measure(spec.TestDownload)
+	client.upload = measure(spec.TestUpload)
+	return client
+}
+
+func TestBidirectionalCase(t *testing.T) {
+	client := newMeasuringClient()
+	var mu sync.Mutex
+	var urls []string
+	connect := client.connect
+	client.connect = func(
+		dialer websocket.Dialer, ctx context.Context, urlStr string,
+		requestHeader http.Header) (*websocket.Conn, *http.Response, error,
+	) {
+		mu.Lock()
+		urls = append(urls, urlStr)
+		mu.Unlock()
+		return connect(dialer, ctx, urlStr, requestHeader)
+	}
+	client.Scheme = "ws"
+	client.Server = "127.0.0.1:8080"
+	// The single download connection gets the whole budget.
+	client.TestOptions.MaxBytes = 250 * 1000 * 1000
+	ch, err := client.StartBidirectional(context.Background())
+	testingx.Must(t, err, "failed to start bidirectional test")
+	tests := map[spec.TestKind]int{}
+	for m := range ch {
+		tests[m.Test]++
+	}
+	if tests[spec.TestDownload] != 1 || tests[spec.TestUpload] != 1 {
+		t.Fatalf("unexpected measurements: %+v", tests)
+	}
+	if len(urls) != 2 {
+		t.Fatalf("expected two connections, got %d", len(urls))
+	}
+	for i, p := range []string{params.DownloadURLPath, params.UploadURLPath} {
+		u, err := url.Parse(urls[i])
+		testingx.Must(t, err, "failed to parse URL")
+		if u.Host != "127.0.0.1:8080" || u.Path != p {
+			t.Fatalf("unexpected URL: %s", urls[i])
+		}
+	}
+	if got := urls[0]; !strings.Contains(got, params.EarlyExitParameterName+"=250") {
+		t.Fatalf("expected the download to exit early after 250 MB: %s", got)
+	}
+	results := client.BidirectionalResults()
+	for _, test := range []spec.TestKind{spec.TestDownload, spec.TestUpload} {
+		lm, ok := results[test]
+		if !ok || lm.ConnectionInfo == nil || lm.ConnectionInfo.UUID != string(test) {
+			t.Fatalf("missing bidirectional %s results", test)
+		}
+	}
+	if len(client.Results()) != 0 {
+		t.Fatal("the bidirectional test should not touch Results")
+	}
+}
+
+func TestBidirectionalUploadConnectError(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	mockedErr := errors.New("mocked error")
+	connect := client.connect
+	client.connect = func(
+		dialer websocket.Dialer, ctx context.Context, urlStr string,
+		requestHeader http.Header) (*websocket.Conn, *http.Response, error,
+	) {
+		u, _ := url.Parse(urlStr)
+		if u.Path == params.UploadURLPath {
+			return nil, nil, mockedErr
+		}
+		return connect(dialer, ctx, urlStr, requestHeader)
+	}
+	_, err := client.StartBidirectional(context.Background())
+	if !errors.Is(err, mockedErr) {
+		t.Fatalf("not the error we expected: %v", err)
+	}
+	if s.Connections() != 1 {
+		t.Fatal("expected the download to connect")
+	}
+}
+
+func TestSiblingURL(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	client.targets = []v2.Target{{
+		URLs: map[string]string{
+			"wss:///ndt/v7/download": "wss://a.example/ndt/v7/download?token=d",
+			"wss:///ndt/v7/upload":   "wss://a.example/ndt/v7/upload?token=u",
+		},
+	}}
+	got, err := client.siblingURL("wss://a.example/ndt/v7/download?token=d", params.UploadURLPath)
+	if err != nil || got != "wss://a.example/ndt/v7/upload?token=u" {
+		t.Fatalf("unexpected Locate URL: %s, %v", got, err)
+	}
+	got, err = client.siblingURL("ws://b.example/ndt/v7/download", params.UploadURLPath)
+	if err != nil || got != "ws://b.example/ndt/v7/upload" {
+		t.Fatalf("unexpected custom URL: %s, %v", got, err)
+	}
+	if _, err := client.siblingURL("ws://b.example:x/", params.UploadURLPath); err == nil {
+		t.Fatal("expected an error for a malformed URL")
+	}
+}
+
+func TestSiblingURLMissing(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	client.targets = []v2.Target{{
+		URLs: map[string]string{
+			"wss:///ndt/v7/download": "wss://a.example/ndt/v7/download?token=d",
+		},
+	}}
+	_, err := client.siblingURL("wss://a.example/ndt/v7/download?token=d", params.UploadURLPath)
+	if !errors.Is(err, ErrNoTargets) {
+		t.Fatalf("expected ErrNoTargets, got %v", err)
+	}
+}
+
+func TestBidirectionalUnsupportedOptions(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(c *Client)
+	}{{
+		name:   "download streams",
+		modify: func(c *Client) { c.DownloadStreams = 2 },
+	}, {
+		name:   "upload streams",
+		modify: func(c *Client) { c.UploadStreams = 2 },
+	}, {
+		name:   "probe interval",
+		modify: func(c *Client) { c.TestOptions.ProbeInterval = time.Second },
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newMeasuringClient()
+			tc.modify(client)
+			_, err := client.StartBidirectional(context.Background())
+			if !errors.Is(err, ErrInvalidTestOptions) {
+				t.Fatalf("expected ErrInvalidTestOptions, got %v", err)
+			}
+		})
+	}
+}
+
+func TestConcurrentDownloadAndUpload(t *testing.T) {
+	client := newMeasuringClient()
+	wg := &sync.WaitGroup{}
+	for _, start := range []func(context.Context) (<-chan spec.Measurement, error){
+		client.StartDownload, client.StartUpload,
+	} {
+		wg.Add(1)
+		go func(start func(context.Context) (<-chan spec.Measurement, error)) {
+			defer wg.Done()
+			ch, err := start(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for range ch {
+				client.Results()
+			}
+		}(start)
+	}
+	wg.Wait()
+	results := client.Results()
+	if len(results) != 2 {
+		t.Fatalf("expected two results, got %d", len(results))
+	}
+}
+
+func TestIntegrationBidirectional(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping test in short mode")
+	}
+	h, fs := ndt7test.NewNDT7Server(t)
+	defer os.RemoveAll(h.DataDir)
+	defer fs.Close()
+	l := locatetest.NewLocateServerV2(newLocator(t, fs.URL))
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	u, err := url.Parse(l.URL + "/v2/nearest")
+	testingx.Must(t, err, "failed to parse locatetest url")
+	loc := locate.NewClient(MakeUserAgent(clientName, clientVersion))
+	loc.BaseURL = u
+	client.Locate = loc
+
+	ch, err := client.StartBidirectional(context.Background())
+	testingx.Must(t, err, "bidirectional test failed to start")
+	for range ch {
+		// We already test that measurements follow our expectations
+		// in internal/download and internal/upload.
+	}
+	results := client.BidirectionalResults()
+	dl, ul := results[spec.TestDownload], results[spec.TestUpload]
+	if dl.Client.AppInfo == nil || dl.Client.AppInfo.NumBytes <= 0 {
+		t.Fatal("no download measurements")
+	}
+	if ul.Server.TCPInfo == nil || ul.Server.TCPInfo.BytesReceived <= 0 {
+		t.Fatal("no upload measurements")
+	}
+	if dl.ConnectionInfo.UUID == ul.ConnectionInfo.UUID {
+		t.Fatal("expected distinct UUIDs")
+	}
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/m-lab/go/flagx"
 	"github.com/m-lab/go/testingx"
 	"github.com/m-lab/locate/api/locate"
 	"github.com/m-lab/ndt-server/ndt7/ndt7test"
+	"github.com/m-lab/ndt7-client-go"
 	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/internal/trace"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
 )
 
 func TestNormalUsage(t *testing.T) {
@@ -219,3 +229,276 @@ func TestClientFactory_WithCustomURL(t *testing.T) {
 		t.Errorf("got path %q, want %q", loc.BaseURL.Path, "/my/path")
 	}
 }
+
+func TestClientFactory_ServerSelection(t *testing.T) {
+	orig := flagServerSelection.Value
+	defer func() {
+		flagServerSelection.Value = orig
+	}()
+
+	if c := clientFactory(); c.ServerSelection != ndt7.SelectLocateOrder {
+		t.Errorf("got %q, want the Locate order", c.ServerSelection)
+	}
+	flagServerSelection.Value = "rtt"
+	if c := clientFactory(); c.ServerSelection != ndt7.SelectLowestRTT {
+		t.Errorf("got %q, want %q", c.ServerSelection, ndt7.SelectLowestRTT)
+	}
+}
+
+func TestClientFactory_AddressFamily(t *testing.T) {
+	origIPv4, origIPv6 := *flagIPv4, *flagIPv6
+	defer func() {
+		*flagIPv4, *flagIPv6 = origIPv4, origIPv6
+	}()
+
+	tests := []struct {
+		ipv4, ipv6 bool
+		want       ndt7.AddressFamily
+	}{
+		{false, false, ndt7.AddressFamilyAny},
+		{true, false, ndt7.AddressFamilyIPv4},
+		{false, true, ndt7.AddressFamilyIPv6},
+		{true, true, ndt7.AddressFamilyAny},
+	}
+	for _, tt := range tests {
+		*flagIPv4, *flagIPv6 = tt.ipv4, tt.ipv6
+		if c := clientFactory(); c.AddressFamily != tt.want {
+			t.Errorf("-4=%v -6=%v: got %q, want %q", tt.ipv4, tt.ipv6, c.AddressFamily, tt.want)
+		}
+	}
+}
+
+func TestClientFactory_Binding(t *testing.T) {
+	origInterface, origSource := *flagInterface, *flagSource
+	defer func() {
+		*flagInterface, *flagSource = origInterface, origSource
+	}()
+
+	*flagInterface = "wan1"
+	*flagSource = "192.0.2.1"
+	c := clientFactory()
+	if c.Interface != "wan1" || c.SourceAddress != "192.0.2.1" {
+		t.Errorf("got %q and %q", c.Interface, c.SourceAddress)
+	}
+}
+
+func TestClientFactory_Proxy(t *testing.T) {
+	orig := flagProxy.URL
+	defer func() {
+		flagProxy.URL = orig
+	}()
+
+	if c := clientFactory(); c.Proxy != nil {
+		t.Errorf("expected no proxy by default, got %v", c.Proxy)
+	}
+	if err := flagProxy.Set("socks5://127.0.0.1:1080"); err != nil {
+		t.Fatal(err)
+	}
+	if c := clientFactory(); c.Proxy == nil || c.Proxy.String() != "socks5://127.0.0.1:1080" {
+		t.Errorf("got %v", c.Proxy)
+	}
+}
+
+func TestClientFactory_TLS(t *testing.T) {
+	origCA, origCert, origKey, origPins := *flagCAFile, *flagCertFile, *flagKeyFile, flagPinSPKI
+	defer func() {
+		*flagCAFile, *flagCertFile, *flagKeyFile, flagPinSPKI = origCA, origCert, origKey, origPins
+	}()
+
+	*flagCAFile = "ca.pem"
+	*flagCertFile = "client.pem"
+	*flagKeyFile = "client-key.pem"
+	flagPinSPKI = flagx.StringArray{}
+	if err := flagPinSPKI.Set("pin1,pin2"); err != nil {
+		t.Fatal(err)
+	}
+	c := clientFactory()
+	if c.TLS.CAFile != "ca.pem" || c.TLS.CertFile != "client.pem" ||
+		c.TLS.KeyFile != "client-key.pem" || len(c.TLS.PinnedSPKI) != 2 {
+		t.Errorf("got %+v", c.TLS)
+	}
+}
+
+func TestRecordReplayUsage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping test in short mode")
+	}
+	// Create local ndt7test server.
+	h, fs := ndt7test.NewNDT7Server(t)
+	defer os.RemoveAll(h.DataDir)
+	defer fs.Close()
+	u, err := url.Parse(fs.URL)
+	testingx.Must(t, err, "failed to parse ndt7test server url")
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+
+	exitval := 0
+	savedFunc := osExit
+	osExit = func(code int) {
+		exitval = code
+	}
+	savedArgs := osArgs
+	osArgs = []string{"ndt7-client"}
+	defer func() {
+		osExit = savedFunc
+		osArgs = savedArgs
+		*flagRecord, *flagReplay = "", ""
+		*flagDownloadDuration = params.DownloadTimeout
+		*flagDownload, *flagUpload = true, true
+	}()
+
+	// Record a short download.
+	flagScheme.Value = "ws"
+	*flagServer = u.Host
+	*flagDownload, *flagUpload = true, false
+	*flagDownloadDuration = 2 * time.Second
+	*flagRecord = path
+	main()
+	*flagServer = ""
+	*flagRecord = ""
+	if exitval != 0 {
+		t.Fatal("expected zero return code when recording")
+	}
+	records, err := trace.ReadFile(path)
+	testingx.Must(t, err, "failed to read the trace")
+	if len(records) == 0 || records[0].Test != spec.TestDownload || records[0].Server == "" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	// Replay it without any server.
+	*flagReplay = path
+	main()
+	if exitval != 0 {
+		t.Fatal("expected zero return code when replaying")
+	}
+	// There is no upload to replay.
+	*flagUpload = true
+	main()
+	if exitval != 1 {
+		t.Fatalf("expected the upload to fail, got %d", exitval)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	handshake := &ndt7.Error{Kind: ndt7.ErrorKindHandshake, StatusCode: 403, Err: errors.New("bad handshake")}
+	tests := []struct {
+		errs []error
+		want int
+	}{
+		{nil, 0},
+		{[]error{errors.New("mocked error")}, 1},
+		{[]error{fmt.Errorf("Failed to run test download: %w", handshake)}, 7},
+		{[]error{
+			errors.New("mocked error"),
+			&ndt7.Error{Kind: ndt7.ErrorKindTimeout, Err: errors.New("i/o timeout")},
+			handshake,
+		}, 9},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.errs); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.errs, got, tt.want)
+		}
+	}
+}
+
+func TestConnectErrorExitCode(t *testing.T) {
+	// Find a port where nobody is listening.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testingx.Must(t, err, "failed to listen")
+	addr := ln.Addr().String()
+	ln.Close()
+
+	exitval := 0
+	savedFunc := osExit
+	osExit = func(code int) {
+		exitval = code
+	}
+	savedArgs := osArgs
+	osArgs = []string{"ndt7-client"}
+	savedScheme := flagScheme.Value
+	defer func() {
+		osExit = savedFunc
+		osArgs = savedArgs
+		flagScheme.Value = savedScheme
+		*flagServer = ""
+		*flagDownload, *flagUpload = true, true
+	}()
+	flagScheme.Value = "ws"
+	*flagServer = addr
+	*flagDownload, *flagUpload = true, false
+	main()
+	if exitval != 5 {
+		t.Fatalf("expected the connect exit code, got %d", exitval)
+	}
+}
+
+func TestTimeoutExitCode(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{Duration: 5 * time.Second})
+	defer s.Close()
+
+	exitval := 0
+	savedFunc := osExit
+	osExit = func(code int) {
+		exitval = code
+	}
+	savedArgs := osArgs
+	osArgs = []string{"ndt7-client"}
+	savedScheme := flagScheme.Value
+	savedTimeout := *flagTimeout
+	defer func() {
+		osExit = savedFunc
+		osArgs = savedArgs
+		flagScheme.Value = savedScheme
+		*flagTimeout = savedTimeout
+		*flagServer = ""
+		*flagDownload, *flagUpload = true, true
+	}()
+	flagScheme.Value = s.Scheme()
+	*flagServer = s.Host()
+	*flagTimeout = time.Second
+	*flagDownload, *flagUpload = true, false
+	main()
+	if exitval != 9 {
+		t.Fatalf("expected the timeout exit code, got %d", exitval)
+	}
+}
+
+func TestLocatorUsage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping test in short mode")
+	}
+	h, fs := ndt7test.NewNDT7Server(t)
+	defer os.RemoveAll(h.DataDir)
+	defer fs.Close()
+	u, err := url.Parse(fs.URL)
+	testingx.Must(t, err, "failed to parse ndt7test server url")
+
+	exitval := 0
+	savedFunc := osExit
+	osExit = func(code int) {
+		exitval = code
+	}
+	savedArgs := osArgs
+	osArgs = []string{"ndt7-client", "-locator", "static:ws://" + u.Host, "-locator-policy", "random"}
+	savedScheme := flagScheme.Value
+	defer func() {
+		osExit = savedFunc
+		osArgs = savedArgs
+		flagScheme.Value = savedScheme
+		*flagLocator = ""
+		flagLocatorPolicy.Value = "ordered"
+		customLocator = nil
+		*flagDownload, *flagUpload = true, true
+	}()
+	flagScheme.Value = "ws"
+	*flagDownload, *flagUpload = true, false
+	main()
+	if exitval != 0 {
+		t.Fatalf("expected zero return code, got %d", exitval)
+	}
+	if customLocator == nil {
+		t.Fatal("expected the static locator")
+	}
+	if c := clientFactory(); c.Locate != customLocator {
+		t.Fatalf("expected the client to use the static locator, got %T", c.Locate)
+	}
+}
@@ -0,0 +1,157 @@
+package ndt7
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrorKind is the class of an Error.
+type ErrorKind string
+
+const (
+	// ErrorKindLocate indicates that querying the Locate API failed.
+	ErrorKindLocate = ErrorKind("locate")
+
+	// ErrorKindDNS indicates that resolving the server name failed.
+	ErrorKindDNS = ErrorKind("dns")
+
+	// ErrorKindConnect indicates that connecting to the server failed
+	// for any other reason, e.g., the connection was refused.
+	ErrorKindConnect = ErrorKind("connect")
+
+	// ErrorKindTLS indicates that verifying the server certificate failed,
+	// including when it does not match TLSOptions.PinnedSPKI.
+	ErrorKindTLS = ErrorKind("tls")
+
+	// ErrorKindHandshake indicates that the server rejected the WebSocket
+	// handshake. See Error.StatusCode and Error.Body.
+	ErrorKindHandshake = ErrorKind("handshake")
+
+	// ErrorKindServerClose indicates that the server closed the connection
+	// before the end of the test. See Error.CloseCode.
+	ErrorKindServerClose = ErrorKind("server_close")
+
+	// ErrorKindTimeout indicates that an I/O operation timed out or that
+	// the deadline of the context expired.
+	ErrorKindTimeout = ErrorKind("timeout")
+
+	// ErrorKindCanceled indicates that the context was canceled.
+	ErrorKindCanceled = ErrorKind("canceled")
+)
+
+// maxErrorBodySize is the maximum number of bytes of the body of the
+// response rejecting the WebSocket handshake that we keep.
+const maxErrorBodySize = 512
+
+// Error is an error that occurred while connecting to the server or
+// during a test, classified by Kind. The Client returns it, possibly
+// wrapped, when it cannot start a test, and stores it into the Error
+// field of LatestMeasurements when a test fails. Use errors.As to
+// obtain it. Errors we cannot classify, e.g., invalid options, are
+// returned as they are.
+type Error struct {
+	// Kind is the class of the error.
+	Kind ErrorKind
+
+	// StatusCode is the HTTP status code with which the server rejected
+	// the WebSocket handshake, if Kind is ErrorKindHandshake.
+	StatusCode int
+
+	// Body is the beginning of the body of the response with which the
+	// server rejected the WebSocket handshake, if Kind is
+	// ErrorKindHandshake.
+	Body string
+
+	// CloseCode is the WebSocket close code sent by the server, if Kind
+	// is ErrorKindServerClose and the server sent a close message.
+	CloseCode int
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the message of the underlying error, followed by the
+// status code of the response rejecting the handshake, if any.
+func (e *Error) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%v: HTTP status %d", e.Err, e.StatusCode)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// classify returns err as an *Error, unless err is nil or it is already
+// an *Error. The kind is the class of the errors that do not belong to a
+// more specific class. When kind is empty, we return such errors as they
+// are.
+func classify(err error, kind ErrorKind) error {
+	if err == nil || errors.As(err, new(*Error)) {
+		return err
+	}
+	var (
+		closeErr *websocket.CloseError
+		netErr   net.Error
+	)
+	e := &Error{Kind: kind, Err: err}
+	switch {
+	case errors.Is(err, context.Canceled):
+		e.Kind = ErrorKindCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		e.Kind = ErrorKindTimeout
+	case errors.As(err, new(*net.DNSError)):
+		e.Kind = ErrorKindDNS
+	case isVerificationError(err):
+		e.Kind = ErrorKindTLS
+	case errors.As(err, &closeErr):
+		e.Kind = ErrorKindServerClose
+		e.CloseCode = closeErr.Code
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		e.Kind = ErrorKindServerClose
+	case errors.As(err, &netErr) && netErr.Timeout():
+		e.Kind = ErrorKindTimeout
+	case kind == "":
+		return err
+	}
+	return e
+}
+
+// isVerificationError returns whether err indicates that verifying the
+// certificate of the server, or its pin, failed.
+func isVerificationError(err error) bool {
+	return errors.Is(err, ErrCertificatePin) ||
+		errors.As(err, new(*tls.CertificateVerificationError)) ||
+		errors.As(err, new(x509.UnknownAuthorityError)) ||
+		errors.As(err, new(x509.HostnameError)) ||
+		errors.As(err, new(x509.CertificateInvalidError))
+}
+
+// classifyConnect is like classify for the errors returned by connecting
+// to the server. When the server rejected the WebSocket handshake, the
+// error contains the status code and the body of resp.
+func classifyConnect(err error, resp *http.Response) error {
+	if !errors.Is(err, websocket.ErrBadHandshake) || resp == nil {
+		return classify(err, ErrorKindConnect)
+	}
+	e := &Error{
+		Kind:       ErrorKindHandshake,
+		StatusCode: resp.StatusCode,
+		Err:        err,
+	}
+	if resp.Body != nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		e.Body = string(body)
+	}
+	return e
+}
@@ -0,0 +1,141 @@
+package ndt7
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	v2 "github.com/m-lab/locate/api/v2"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind ErrorKind
+		want ErrorKind
+	}{
+		{context.Canceled, ErrorKindConnect, ErrorKindCanceled},
+		{context.DeadlineExceeded, "", ErrorKindTimeout},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, ErrorKindConnect, ErrorKindDNS},
+		{fmt.Errorf("tls: %w", x509.UnknownAuthorityError{}), ErrorKindConnect, ErrorKindTLS},
+		{&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}, ErrorKindConnect, ErrorKindTLS},
+		{&websocket.CloseError{Code: websocket.CloseAbnormalClosure}, "", ErrorKindServerClose},
+		{io.ErrUnexpectedEOF, "", ErrorKindServerClose},
+		{timeoutError{}, "", ErrorKindTimeout},
+		{syscall.ECONNREFUSED, ErrorKindConnect, ErrorKindConnect},
+	}
+	for _, tt := range tests {
+		var got *Error
+		if !errors.As(classify(tt.err, tt.kind), &got) || got.Kind != tt.want {
+			t.Errorf("classify(%v, %q) = %v, want %s", tt.err, tt.kind, got, tt.want)
+			continue
+		}
+		if !errors.Is(got, tt.err) || got.Error() != tt.err.Error() {
+			t.Errorf("classify(%v, %q) does not wrap the error", tt.err, tt.kind)
+		}
+	}
+}
+
+func TestClassifyUnknown(t *testing.T) {
+	if classify(nil, ErrorKindConnect) != nil {
+		t.Fatal("expected a nil error")
+	}
+	mockedErr := errors.New("mocked error")
+	if classify(mockedErr, "") != mockedErr {
+		t.Fatal("expected the error we cannot classify as it is")
+	}
+	classified := &Error{Kind: ErrorKindLocate, Err: mockedErr}
+	if classify(classified, ErrorKindConnect) != classified {
+		t.Fatal("expected the classified error as it is")
+	}
+	closeErr := &websocket.CloseError{Code: websocket.CloseInternalServerErr}
+	var got *Error
+	if !errors.As(classify(closeErr, ""), &got) || got.CloseCode != websocket.CloseInternalServerErr {
+		t.Fatalf("expected the close code, got %+v", got)
+	}
+}
+
+func TestClassifyConnect(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusForbidden,
+		Body:       io.NopCloser(strings.NewReader(strings.Repeat("x", 2*maxErrorBodySize))),
+	}
+	var got *Error
+	err := classifyConnect(websocket.ErrBadHandshake, resp)
+	if !errors.As(err, &got) || got.Kind != ErrorKindHandshake ||
+		got.StatusCode != http.StatusForbidden || len(got.Body) != maxErrorBodySize {
+		t.Fatalf("unexpected error: %+v", got)
+	}
+	if !errors.Is(err, websocket.ErrBadHandshake) ||
+		err.Error() != "websocket: bad handshake: HTTP status 403" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Without a response, we cannot tell why the handshake failed.
+	if !errors.As(classifyConnect(websocket.ErrBadHandshake, nil), &got) || got.Kind != ErrorKindConnect {
+		t.Fatalf("unexpected error: %+v", got)
+	}
+}
+
+func TestHandshakeError(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{RejectStatus: http.StatusForbidden})
+	defer s.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	_, err := client.StartDownload(context.Background())
+	var got *Error
+	if !errors.As(err, &got) || got.Kind != ErrorKindHandshake ||
+		got.StatusCode != http.StatusForbidden || !strings.Contains(got.Body, "Forbidden") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServerCloseError(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{
+		Duration:   5 * time.Second,
+		CloseAfter: 500 * time.Millisecond,
+	})
+	defer s.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	ch, err := client.StartDownload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	drain(ch)
+	var got *Error
+	err = client.Results()[spec.TestDownload].Error
+	if !errors.As(err, &got) || got.Kind != ErrorKindServerClose {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestNoTargetsError(t *testing.T) {
+	// Find a port where nobody is listening.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	client.Locate = &targetsLocator{targets: []v2.Target{newTarget(ln.Addr().String())}}
+	_, err = client.StartDownload(context.Background())
+	var got *Error
+	if !errors.Is(err, ErrNoTargets) || !errors.As(err, &got) || got.Kind != ErrorKindConnect {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -28,3 +28,18 @@ func Example() {
 		log.Printf("%+v", ev)
 	}
 }
+
+// This shows how to run a download test and wait for its result.
+func ExampleClient_RunDownload() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	client := ndt7.NewClient("ndt7-client-go-example", "0.1.0")
+	r, err := client.RunDownload(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if r.Err != nil {
+		log.Printf("download ended early (%s): %v", r.Termination, r.Err)
+	}
+	log.Printf("downloaded %d bytes in %v", r.Bytes, r.Duration)
+}
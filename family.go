@@ -0,0 +1,187 @@
+package ndt7
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/locate/api/locate"
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/ndt7-client-go/internal/bindx"
+	"github.com/m-lab/ndt7-client-go/internal/probe"
+)
+
+// AddressFamily is the IP address family used to connect to the server.
+type AddressFamily string
+
+const (
+	// AddressFamilyAny lets the Dialer choose the address family.
+	AddressFamilyAny = AddressFamily("")
+
+	// AddressFamilyIPv4 forces using IPv4.
+	AddressFamilyIPv4 = AddressFamily("tcp4")
+
+	// AddressFamilyIPv6 forces using IPv6.
+	AddressFamilyIPv6 = AddressFamily("tcp6")
+)
+
+// failingLocator is a Locator that always fails with err, e.g., because
+// we cannot configure the HTTP client.
+type failingLocator struct {
+	err error
+}
+
+func (l *failingLocator) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	return nil, l.err
+}
+
+// customDial returns whether the Client options require customizing how
+// we establish TCP connections.
+func (c *Client) customDial() bool {
+	return c.AddressFamily != AddressFamilyAny || c.SourceAddress != "" ||
+		c.Interface != ""
+}
+
+// validateDial returns an error if c.SourceAddress or c.Interface are not
+// valid. It does nothing if c.Dialer.NetDialContext is set.
+func (c *Client) validateDial() error {
+	if c.Dialer.NetDialContext != nil {
+		return nil
+	}
+	_, err := bindx.NewDialer(c.SourceAddress, c.Interface)
+	return err
+}
+
+// netDial returns the function used to establish TCP connections, which
+// is the one used by c.Dialer, if set, or a function binding to
+// c.SourceAddress and c.Interface, if set. In both cases, it connects
+// using only the addresses of c.AddressFamily, if set.
+func (c *Client) netDial() probe.DialFunc {
+	dial := c.Dialer.NetDialContext
+	if dial == nil {
+		d, err := bindx.NewDialer(c.SourceAddress, c.Interface)
+		if err != nil {
+			return func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, err
+			}
+		}
+		dial = d.DialContext
+	}
+	if c.AddressFamily == AddressFamilyAny {
+		return dial
+	}
+	family := string(c.AddressFamily)
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if network == "tcp" {
+			network = family
+		}
+		return dial(ctx, network, address)
+	}
+}
+
+// resolverDial is like netDial but for the connections to the DNS servers,
+// which may use UDP as well as TCP.
+func (c *Client) resolverDial() probe.DialFunc {
+	dial := c.Dialer.NetDialContext
+	if dial == nil {
+		d, err := bindx.NewDialer(c.SourceAddress, c.Interface)
+		if err != nil {
+			return func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, err
+			}
+		}
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			// The type of the source address must match the network.
+			if local, ok := d.LocalAddr.(*net.TCPAddr); ok && strings.HasPrefix(network, "udp") {
+				ud := *d
+				ud.LocalAddr = &net.UDPAddr{IP: local.IP}
+				return ud.DialContext(ctx, network, address)
+			}
+			return d.DialContext(ctx, network, address)
+		}
+	}
+	if c.AddressFamily == AddressFamilyAny {
+		return dial
+	}
+	version := strings.TrimPrefix(string(c.AddressFamily), "tcp")
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if network == "tcp" || network == "udp" {
+			network += version
+		}
+		return dial(ctx, network, address)
+	}
+}
+
+// dialLocator is a Locator calling the NearestDial method of a DialLocator
+// with the given function to establish connections.
+type dialLocator struct {
+	DialLocator
+	dial probe.DialFunc
+}
+
+func (l *dialLocator) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	return l.NearestDial(ctx, service, l.dial)
+}
+
+// dialer returns the websocket Dialer used to connect to the server, i.e.,
+// c.Dialer, using proxy, the TLS configuration customized by c.TLS and,
+// if needed, netDial.
+func (c *Client) dialer() (websocket.Dialer, error) {
+	d := c.Dialer
+	d.Proxy = c.proxy()
+	if c.customDial() {
+		d.NetDialContext = c.netDial()
+	}
+	if c.TLS.enabled() {
+		config, err := c.tlsConfig(d.TLSClientConfig)
+		if err != nil {
+			return d, err
+		}
+		d.TLSClientConfig = config
+	}
+	return d, nil
+}
+
+// locator returns the Locator used to discover servers, i.e., c.Locate.
+// When c.Locate is a *locate.Client and the Client options require
+// customizing how we establish TCP connections, choose the proxy or verify
+// the certificates, we return a copy of c.Locate whose HTTP client uses
+// netDial, proxy and the TLS configuration customized by c.TLS, along with
+// a function to release its resources. When c.Locate is a DialLocator and
+// we need to customize how we establish connections, we return a Locator
+// calling its NearestDial method with resolverDial. When c.Locate is a
+// WrappingLocator, we customize the Locator it wraps.
+func (c *Client) locator() (Locator, func()) {
+	return c.customLocator(c.Locate)
+}
+
+// customLocator is like locator but customizes the given Locator.
+func (c *Client) customLocator(l Locator) (Locator, func()) {
+	if wl, ok := l.(WrappingLocator); ok {
+		inner, done := c.customLocator(wl.Unwrap())
+		return wl.Wrap(inner), done
+	}
+	if dl, ok := l.(DialLocator); ok && c.customDial() {
+		return &dialLocator{DialLocator: dl, dial: c.resolverDial()}, func() {}
+	}
+	lc, ok := l.(*locate.Client)
+	if !ok || (!c.customDial() && c.Proxy == nil && c.Dialer.Proxy == nil &&
+		!c.TLS.enabled()) {
+		return l, func() {}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = c.netDial()
+	transport.Proxy = c.proxy()
+	if c.TLS.enabled() {
+		config, err := c.tlsConfig(nil)
+		if err != nil {
+			return &failingLocator{err: err}, func() {}
+		}
+		transport.TLSClientConfig = config
+	}
+	out := *lc
+	out.HTTPClient = &http.Client{Transport: transport}
+	return &out, transport.CloseIdleConnections
+}
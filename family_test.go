@@ -0,0 +1,270 @@
+package ndt7
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/locate/api/locate"
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/locatetest"
+	"github.com/m-lab/ndt7-client-go/internal/bindx"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+func TestNetDialAddressFamily(t *testing.T) {
+	tests := []struct {
+		family AddressFamily
+		want   string
+	}{
+		{AddressFamilyAny, "tcp"},
+		{AddressFamilyIPv4, "tcp4"},
+		{AddressFamilyIPv6, "tcp6"},
+	}
+	for _, tt := range tests {
+		client := NewClient(clientName, clientVersion)
+		client.AddressFamily = tt.family
+		var network string
+		mockedErr := errors.New("mocked error")
+		client.Dialer.NetDialContext = func(ctx context.Context, n, address string) (net.Conn, error) {
+			network = n
+			return nil, mockedErr
+		}
+		d, err := client.dialer()
+		testingx.Must(t, err, "failed to create the dialer")
+		_, err = d.NetDialContext(context.Background(), "tcp", "example.com:80")
+		if err != mockedErr {
+			t.Fatalf("not the error we expected: %v", err)
+		}
+		if network != tt.want {
+			t.Fatalf("family %q: got network %q, want %q", tt.family, network, tt.want)
+		}
+	}
+}
+
+func TestDialerDefault(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	if d, _ := client.dialer(); d.NetDialContext != nil {
+		t.Fatal("the default dialer should not be modified")
+	}
+	client.AddressFamily = AddressFamilyIPv4
+	if d, _ := client.dialer(); d.NetDialContext == nil {
+		t.Fatal("expected a NetDialContext forcing IPv4")
+	}
+	if client.Dialer.NetDialContext != nil {
+		t.Fatal("c.Dialer should not be modified")
+	}
+}
+
+func TestAddressFamilyWithServer(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	for _, family := range []AddressFamily{AddressFamilyIPv4, AddressFamilyIPv6} {
+		client := NewClient(clientName, clientVersion)
+		client.Scheme = s.Scheme()
+		// The server only listens on 127.0.0.1.
+		client.Server = s.Host()
+		client.AddressFamily = family
+		ch, err := client.StartDownload(context.Background())
+		if family == AddressFamilyIPv6 {
+			if err == nil {
+				t.Fatal("expected to fail connecting using IPv6")
+			}
+			continue
+		}
+		testingx.Must(t, err, "failed to connect using IPv4")
+		for range ch {
+		}
+		if client.Results()[spec.TestDownload].Client.AppInfo == nil {
+			t.Fatal("no download measurements")
+		}
+	}
+}
+
+func TestInvalidSourceAddress(t *testing.T) {
+	client := newMockedClient()
+	client.SourceAddress = "not-an-ip"
+	_, err := client.StartDownload(context.Background())
+	if !errors.Is(err, bindx.ErrInvalidSource) {
+		t.Fatalf("not the error we expected: %v", err)
+	}
+	_, err = client.StartBidirectional(context.Background())
+	if !errors.Is(err, bindx.ErrInvalidSource) {
+		t.Fatalf("not the error we expected: %v", err)
+	}
+}
+
+func TestSourceAddressWithServer(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	client.SourceAddress = "127.0.0.1"
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	for range ch {
+	}
+	ci := client.Results()[spec.TestDownload].ConnectionInfo
+	if ci == nil {
+		t.Fatal("missing connection info")
+	}
+	host, _, err := net.SplitHostPort(ci.Client)
+	testingx.Must(t, err, "failed to parse client address")
+	if host != "127.0.0.1" {
+		t.Fatalf("unexpected client address: %s", ci.Client)
+	}
+}
+
+func TestLocator(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	l, done := client.locator()
+	defer done()
+	if l != client.Locate {
+		t.Fatal("expected c.Locate without custom dialing")
+	}
+	client.SourceAddress = "127.0.0.1"
+	l, done = client.locator()
+	defer done()
+	lc, ok := l.(*locate.Client)
+	if !ok || lc == client.Locate || lc.HTTPClient == http.DefaultClient {
+		t.Fatal("expected a copy of c.Locate using a custom HTTP client")
+	}
+	if lc.UserAgent != client.Locate.(*locate.Client).UserAgent {
+		t.Fatal("the copy should keep the other settings")
+	}
+	client.Locate = &f{}
+	if l, _ := client.locator(); l != client.Locate {
+		t.Fatal("expected c.Locate when it is not a *locate.Client")
+	}
+}
+
+// filterLocator is a WrappingLocator, like the one used by the dual-stack
+// mode to only return the IPv4 server.
+type filterLocator struct {
+	Locator
+}
+
+func (l *filterLocator) Unwrap() Locator {
+	return l.Locator
+}
+
+func (l *filterLocator) Wrap(inner Locator) Locator {
+	return &filterLocator{Locator: inner}
+}
+
+func TestLocatorWrapped(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	inner := client.Locate
+	client.Locate = &filterLocator{Locator: inner}
+	client.AddressFamily = AddressFamilyIPv6
+	client.SourceAddress = "::1"
+	client.Proxy = &url.URL{Scheme: "socks5", Host: "[::1]:1080"}
+	l, done := client.locator()
+	defer done()
+	fl, ok := l.(*filterLocator)
+	if !ok || fl == client.Locate {
+		t.Fatal("expected a copy of the wrapping locator")
+	}
+	lc, ok := fl.Unwrap().(*locate.Client)
+	if !ok || lc == inner || lc.HTTPClient == http.DefaultClient {
+		t.Fatal("expected the wrapped locator to use a custom HTTP client")
+	}
+	transport := lc.HTTPClient.Transport.(*http.Transport)
+	req, err := http.NewRequest(http.MethodGet, "https://locate.example.com/", nil)
+	testingx.Must(t, err, "failed to create request")
+	if u, err := transport.Proxy(req); err != nil || u != client.Proxy {
+		t.Fatalf("expected the client proxy, got %v, %v", u, err)
+	}
+	if transport.DialContext == nil {
+		t.Fatal("expected the wrapped locator to bind to the source address")
+	}
+
+	dl := &fakeDialLocator{}
+	client.Locate = &filterLocator{Locator: dl}
+	client.SourceAddress = ""
+	client.Proxy = nil
+	l, _ = client.locator()
+	if _, ok := l.(*filterLocator).Unwrap().(*dialLocator); !ok {
+		t.Fatal("expected the wrapped DialLocator to use the custom dialer")
+	}
+}
+
+// fakeDialLocator is a DialLocator recording the networks it dials.
+type fakeDialLocator struct {
+	networks []string
+}
+
+func (l *fakeDialLocator) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	l.networks = append(l.networks, "")
+	return nil, nil
+}
+
+func (l *fakeDialLocator) NearestDial(ctx context.Context, service string,
+	dial func(ctx context.Context, network, address string) (net.Conn, error)) ([]v2.Target, error) {
+	for _, network := range []string{"udp", "tcp"} {
+		dial(ctx, network, "192.0.2.53:53")
+		l.networks = append(l.networks, network)
+	}
+	return nil, nil
+}
+
+func TestDialLocator(t *testing.T) {
+	l := &fakeDialLocator{}
+	client := NewClient(clientName, clientVersion)
+	client.Locate = l
+	var networks []string
+	client.Dialer.NetDialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		networks = append(networks, network)
+		return nil, errors.New("mocked error")
+	}
+	loc, _ := client.locator()
+	loc.Nearest(context.Background(), "ndt/ndt7")
+	if len(l.networks) != 1 || len(networks) != 0 {
+		t.Fatal("expected to call Nearest without custom dialing")
+	}
+	client.AddressFamily = AddressFamilyIPv6
+	loc, _ = client.locator()
+	loc.Nearest(context.Background(), "ndt/ndt7")
+	if len(networks) != 2 || networks[0] != "udp6" || networks[1] != "tcp6" {
+		t.Fatalf("expected to call NearestDial forcing IPv6, got %v", networks)
+	}
+}
+
+func TestResolverDialSourceAddress(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	testingx.Must(t, err, "failed to listen")
+	defer pc.Close()
+	client := NewClient(clientName, clientVersion)
+	client.SourceAddress = "127.0.0.1"
+	conn, err := client.resolverDial()(context.Background(), "udp", pc.LocalAddr().String())
+	testingx.Must(t, err, "failed to dial")
+	defer conn.Close()
+	if ip := conn.LocalAddr().(*net.UDPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("unexpected local address: %s", ip)
+	}
+}
+
+func TestIntegrationLocateSourceAddress(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	l := locatetest.NewLocateServerV2(newLocator(t, "ws://"+s.Host()))
+	defer l.Close()
+	u, err := url.Parse(l.URL + "/v2/nearest")
+	testingx.Must(t, err, "failed to parse locate URL")
+	loc := locate.NewClient(MakeUserAgent(clientName, clientVersion))
+	loc.BaseURL = u
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Locate = loc
+	client.SourceAddress = "127.0.0.1"
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	for range ch {
+	}
+}
@@ -0,0 +1,53 @@
+// Package bindx creates dialers bound to a source address or to a network
+// interface, e.g., to run tests using one of the uplinks of a multi-homed
+// host.
+//
+// Binding to a network interface uses SO_BINDTODEVICE, which is only
+// supported on Linux. On other systems, NewDialer fails with ErrNoSupport
+// when asked to bind to an interface.
+package bindx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ErrNoSupport is returned on systems where we cannot bind to an interface.
+var ErrNoSupport = errors.New("binding to an interface not supported")
+
+// ErrInvalidSource is returned when the source address is not an IP address.
+var ErrInvalidSource = errors.New("invalid source address")
+
+// NewDialer returns a net.Dialer whose connections use the given source
+// IP address, if not empty, and the given network interface, if not empty.
+func NewDialer(source, iface string) (*net.Dialer, error) {
+	d := &net.Dialer{}
+	if source != "" {
+		ip := net.ParseIP(source)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSource, source)
+		}
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	if iface != "" {
+		if _, err := net.InterfaceByName(iface); err != nil {
+			return nil, err
+		}
+		if !supported {
+			return nil, ErrNoSupport
+		}
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			var err error
+			cerr := c.Control(func(fd uintptr) {
+				err = bindToDevice(fd, iface)
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		}
+	}
+	return d, nil
+}
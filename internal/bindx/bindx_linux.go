@@ -0,0 +1,13 @@
+//go:build linux
+
+package bindx
+
+import "golang.org/x/sys/unix"
+
+// supported indicates whether we can bind to an interface.
+const supported = true
+
+// bindToDevice binds the socket fd to the given interface.
+func bindToDevice(fd uintptr, iface string) error {
+	return unix.BindToDevice(int(fd), iface)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package bindx
+
+// supported indicates whether we can bind to an interface.
+const supported = false
+
+// bindToDevice always fails with ErrNoSupport on this system.
+func bindToDevice(fd uintptr, iface string) error {
+	return ErrNoSupport
+}
@@ -0,0 +1,92 @@
+package bindx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+)
+
+// listen returns a listener accepting connections on the loopback.
+func listen(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return ln
+}
+
+func TestNewDialerSource(t *testing.T) {
+	ln := listen(t)
+	defer ln.Close()
+	d, err := NewDialer("127.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("unexpected local address: %s", ip)
+	}
+}
+
+func TestNewDialerInvalidSource(t *testing.T) {
+	if _, err := NewDialer("not-an-ip", ""); !errors.Is(err, ErrInvalidSource) {
+		t.Fatalf("not the error we expected: %v", err)
+	}
+}
+
+func TestNewDialerUnknownInterface(t *testing.T) {
+	if _, err := NewDialer("", "does-not-exist0"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestNewDialerInterface(t *testing.T) {
+	lo := loopbackInterface(t)
+	d, err := NewDialer("", lo)
+	if runtime.GOOS != "linux" {
+		if !errors.Is(err, ErrNoSupport) {
+			t.Fatalf("not the error we expected: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := listen(t)
+	defer ln.Close()
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+// loopbackInterface returns the name of the loopback interface.
+func loopbackInterface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
@@ -0,0 +1,135 @@
+// Package budget accounts the bytes transferred by the tests against a
+// monthly data budget, e.g., on metered links.
+package budget
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// periodLayout is the layout of the calendar period, i.e., the month.
+const periodLayout = "2006-01"
+
+// state is the content of the state file.
+type state struct {
+	// Period is the calendar period in which we transferred Used bytes.
+	Period string
+
+	// Used is the number of bytes transferred during Period.
+	Used int64
+}
+
+// Tracker accounts the bytes transferred during the current calendar
+// month, in local time, and stores them into a state file, so that they
+// survive restarts. It is safe to use from multiple goroutines.
+type Tracker struct {
+	// Now returns the current time. It's set to time.Now by New; you may
+	// override it for testing.
+	Now func() time.Time
+
+	path  string
+	limit int64
+
+	mu    sync.Mutex
+	state state
+}
+
+// New returns a Tracker allowing limit bytes per month, which stores the
+// bytes transferred into the file at path, reading them back if the file
+// exists. An empty path means not storing them.
+func New(path string, limit int64) (*Tracker, error) {
+	t := &Tracker{
+		Now:   time.Now,
+		path:  path,
+		limit: limit,
+	}
+	if path == "" {
+		return t, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &t.state); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Limit returns the number of bytes allowed per month.
+func (t *Tracker) Limit() int64 {
+	return t.limit
+}
+
+// Used returns the number of bytes transferred during the current month.
+func (t *Tracker) Used() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.usedLocked()
+}
+
+// Remaining returns the number of bytes left for the current month, which
+// is zero when the budget is exhausted.
+func (t *Tracker) Remaining() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return max(t.limit-t.usedLocked(), 0)
+}
+
+// Add accounts n more bytes transferred during the current month and
+// updates the state file.
+func (t *Tracker) Add(n int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = state{
+		Period: t.period(),
+		Used:   t.usedLocked() + n,
+	}
+	return t.save()
+}
+
+// usedLocked implements Used. The caller must hold t.mu.
+func (t *Tracker) usedLocked() int64 {
+	if t.state.Period != t.period() {
+		return 0 // A new month started.
+	}
+	return t.state.Used
+}
+
+// period returns the current calendar period.
+func (t *Tracker) period() string {
+	return t.Now().Format(periodLayout)
+}
+
+// save atomically replaces the state file with the current state. The
+// caller must hold t.mu.
+func (t *Tracker) save() error {
+	if t.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(t.state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
@@ -0,0 +1,86 @@
+package budget
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.json")
+	tr, err := New(path, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, time.October, 31, 23, 0, 0, 0, time.Local)
+	tr.Now = func() time.Time { return now }
+	if tr.Used() != 0 || tr.Remaining() != 1000 {
+		t.Fatalf("unexpected initial state: %d %d", tr.Used(), tr.Remaining())
+	}
+	if err := tr.Add(400); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Add(700); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Used() != 1100 || tr.Remaining() != 0 {
+		t.Fatalf("unexpected state: %d %d", tr.Used(), tr.Remaining())
+	}
+
+	// The bytes transferred survive restarts.
+	tr, err = New(path, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Now = func() time.Time { return now }
+	if tr.Used() != 1100 || tr.Remaining() != 900 || tr.Limit() != 2000 {
+		t.Fatalf("unexpected state after restart: %d %d", tr.Used(), tr.Remaining())
+	}
+
+	// A new month resets the budget.
+	now = now.Add(2 * time.Hour)
+	if tr.Used() != 0 || tr.Remaining() != 2000 {
+		t.Fatalf("unexpected state in a new month: %d %d", tr.Used(), tr.Remaining())
+	}
+	if err := tr.Add(10); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Used() != 10 {
+		t.Fatalf("unexpected state in a new month: %d", tr.Used())
+	}
+}
+
+func TestTrackerNoFile(t *testing.T) {
+	tr, err := New("", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Add(10); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Remaining() != 990 {
+		t.Fatalf("unexpected remaining bytes: %d", tr.Remaining())
+	}
+}
+
+func TestNewInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.json")
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(path, 1000); err == nil {
+		t.Fatal("expected an error with an invalid state file")
+	}
+}
+
+func TestAddSaveError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "budget.json")
+	tr, err := New(path, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Add(10); err == nil {
+		t.Fatal("expected an error when the directory does not exist")
+	}
+}
@@ -0,0 +1,85 @@
+// Package convergence detects when the throughput of a test has converged,
+// i.e., when it has been stable for a while, so that running the test any
+// longer would waste data and time without changing the result.
+package convergence
+
+import "time"
+
+// sample is the number of bytes transferred at a given time.
+type sample struct {
+	elapsed time.Duration
+	total   int64
+}
+
+// rate is the rolling throughput computed at a given time.
+type rate struct {
+	elapsed time.Duration
+	value   float64
+}
+
+// Detector watches the rolling throughput of a test, computed over the
+// latest Window, and reports when it has stayed within Tolerance of its
+// mean for a whole Window. A zero Tolerance disables the detector.
+type Detector struct {
+	// Tolerance is the maximum difference between the largest and the
+	// smallest rolling throughput, relative to their mean, e.g., 0.05
+	// for 5%.
+	Tolerance float64
+
+	// MinDuration is the minimum duration of the test before it can be
+	// considered converged. Note that the test must anyway last at least
+	// twice Window.
+	MinDuration time.Duration
+
+	// Window is the time over which we compute the rolling throughput
+	// and during which it must be stable.
+	Window time.Duration
+
+	samples []sample
+	rates   []rate
+}
+
+// Add adds the total number of bytes transferred after elapsed time and
+// returns whether the throughput has converged.
+func (d *Detector) Add(elapsed time.Duration, total int64) bool {
+	if d.Tolerance <= 0 || d.Window <= 0 {
+		return false
+	}
+	if d.samples == nil {
+		// The test starts without any byte transferred.
+		d.samples = []sample{{}}
+	}
+	// Find the latest sample at least a window before this one, if any.
+	prev := -1
+	for i, s := range d.samples {
+		if s.elapsed > elapsed-d.Window {
+			break
+		}
+		prev = i
+	}
+	d.samples = append(d.samples, sample{elapsed: elapsed, total: total})
+	if prev < 0 {
+		return false // We need a whole window of samples.
+	}
+	d.samples = d.samples[prev:]
+	first := d.samples[0]
+	d.rates = append(d.rates, rate{
+		elapsed: elapsed,
+		value:   float64(total-first.total) / float64(elapsed-first.elapsed),
+	})
+	if elapsed < d.MinDuration || d.rates[0].elapsed > elapsed-d.Window {
+		return false // Too early or we need a whole window of rates.
+	}
+	// Only keep the rates within the latest window.
+	for len(d.rates) > 1 && d.rates[1].elapsed <= elapsed-d.Window {
+		d.rates = d.rates[1:]
+	}
+	lowest, highest, sum := d.rates[0].value, d.rates[0].value, 0.0
+	for _, r := range d.rates {
+		lowest = min(lowest, r.value)
+		highest = max(highest, r.value)
+		sum += r.value
+	}
+	mean := sum / float64(len(d.rates))
+	return mean > 0 && highest-lowest <= d.Tolerance*mean
+}
@@ -0,0 +1,76 @@
+package convergence
+
+import (
+	"testing"
+	"time"
+)
+
+// run adds a sample every 250 ms using rate(elapsed), in bytes per 250 ms,
+// and returns the elapsed time at which d converged, or zero.
+func run(d *Detector, duration time.Duration, rate func(time.Duration) int64) time.Duration {
+	var total int64
+	for elapsed := 250 * time.Millisecond; elapsed <= duration; elapsed += 250 * time.Millisecond {
+		total += rate(elapsed)
+		if d.Add(elapsed, total) {
+			return elapsed
+		}
+	}
+	return 0
+}
+
+func TestDetector(t *testing.T) {
+	rampUp := func(elapsed time.Duration) int64 {
+		if elapsed < 2*time.Second {
+			return int64(elapsed / time.Millisecond)
+		}
+		return 2000
+	}
+	tests := []struct {
+		name     string
+		detector Detector
+		rate     func(time.Duration) int64
+		want     time.Duration
+	}{{
+		name:     "constant",
+		detector: Detector{Tolerance: 0.05, Window: time.Second},
+		rate:     func(time.Duration) int64 { return 1000 },
+		want:     2 * time.Second,
+	}, {
+		name:     "minimum duration",
+		detector: Detector{Tolerance: 0.05, MinDuration: 5 * time.Second, Window: time.Second},
+		rate:     func(time.Duration) int64 { return 1000 },
+		want:     5 * time.Second,
+	}, {
+		name:     "ramp-up",
+		detector: Detector{Tolerance: 0.05, Window: time.Second},
+		rate:     rampUp,
+		want:     3500 * time.Millisecond,
+	}, {
+		name:     "unstable",
+		detector: Detector{Tolerance: 0.05, Window: time.Second},
+		rate: func(elapsed time.Duration) int64 {
+			if elapsed%time.Second == 0 {
+				return 5000
+			}
+			return 1000 + int64(elapsed/time.Millisecond)
+		},
+		want: 0,
+	}, {
+		name:     "disabled",
+		detector: Detector{Window: time.Second},
+		rate:     func(time.Duration) int64 { return 1000 },
+		want:     0,
+	}, {
+		name:     "nothing transferred",
+		detector: Detector{Tolerance: 0.05, Window: time.Second},
+		rate:     func(time.Duration) int64 { return 0 },
+		want:     0,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := run(&tt.detector, 10*time.Second, tt.rate); got != tt.want {
+				t.Fatalf("converged after %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
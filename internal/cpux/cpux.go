@@ -0,0 +1,49 @@
+// Package cpux reads the CPU time used by the client process.
+//
+// Reading the CPU time is only supported on Unix systems. On other
+// systems, Usage always fails with ErrNoSupport.
+package cpux
+
+import (
+	"errors"
+	"time"
+
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// ErrNoSupport is returned on systems where we cannot read the CPU time.
+var ErrNoSupport = errors.New("CPU time not supported")
+
+// Usage returns the user and system CPU time used by the process so far.
+func Usage() (time.Duration, error) {
+	return getUsage()
+}
+
+// Meter measures the CPU time used by the process since its creation.
+type Meter struct {
+	start time.Duration
+	err   error
+}
+
+// NewMeter returns a Meter measuring the CPU time used from now on.
+func NewMeter() *Meter {
+	start, err := Usage()
+	return &Meter{start: start, err: err}
+}
+
+// Sample returns a CPUInfo measurement containing the CPU time used since
+// the creation of m and the given elapsed time, or nil if we cannot read
+// the CPU time.
+func (m *Meter) Sample(elapsed time.Duration) *spec.CPUInfo {
+	if m.err != nil {
+		return nil
+	}
+	usage, err := Usage()
+	if err != nil {
+		return nil
+	}
+	return &spec.CPUInfo{
+		ElapsedTime: elapsed.Microseconds(),
+		CPUTime:     (usage - m.start).Microseconds(),
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package cpux
+
+import "time"
+
+// getUsage always fails with ErrNoSupport on this system.
+func getUsage() (time.Duration, error) {
+	return 0, ErrNoSupport
+}
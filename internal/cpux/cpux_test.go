@@ -0,0 +1,53 @@
+package cpux
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// supported is true on the systems where we can read the CPU time.
+var supported = runtime.GOOS != "windows" && runtime.GOOS != "plan9" &&
+	runtime.GOOS != "js" && runtime.GOOS != "wasip1"
+
+// spin keeps the CPU busy for at least d.
+func spin(d time.Duration) {
+	for begin := time.Now(); time.Since(begin) < d; {
+	}
+}
+
+func TestUsage(t *testing.T) {
+	before, err := Usage()
+	if !supported {
+		if err != ErrNoSupport {
+			t.Fatalf("expected ErrNoSupport, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	spin(50 * time.Millisecond)
+	after, err := Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after-before < 10*time.Millisecond {
+		t.Fatalf("unexpected CPU time: %v", after-before)
+	}
+}
+
+func TestMeterSample(t *testing.T) {
+	m := NewMeter()
+	spin(50 * time.Millisecond)
+	info := m.Sample(time.Second)
+	if !supported {
+		if info != nil {
+			t.Fatal("expected nil on this system")
+		}
+		return
+	}
+	if info == nil || info.ElapsedTime != 1000000 || info.CPUTime < 10000 {
+		t.Fatalf("unexpected sample: %+v", info)
+	}
+}
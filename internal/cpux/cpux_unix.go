@@ -0,0 +1,18 @@
+//go:build unix
+
+package cpux
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// getUsage reads the CPU time of the process using getrusage.
+func getUsage() (time.Duration, error) {
+	var ru unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_SELF, &ru); err != nil {
+		return 0, err
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), nil
+}
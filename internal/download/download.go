@@ -9,31 +9,48 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt7-client-go/internal/convergence"
+	"github.com/m-lab/ndt7-client-go/internal/cpux"
 	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/internal/tcpinfox"
 	"github.com/m-lab/ndt7-client-go/internal/websocketx"
 	"github.com/m-lab/ndt7-client-go/spec"
 )
 
-// Run runs the download test. It runs until the ctx expires or the maximum
-// download time expires. Uses the provided websocket connection. Emits zero
-// or more measurements to the provided channel. Returns the error that caused
-// the download loop to stop, which is mainly useful when testing, since the
-// normal usage of this function is to be run in a separate goroutine. Note
-// that this function would block if you don't read from the channel.
+// Run runs the download test. It runs until the ctx expires, the
+// opts.DownloadDuration expires or, if opts.MaxBytes is positive, we have
+// received opts.MaxBytes bytes or, if opts.ConvergenceTolerance is
+// positive, the throughput converged. In the latter cases, we ask the
+// server to close the connection and return nil. Uses the provided
+// websocket connection. Emits zero or more measurements to the provided
+// channel. Returns the error that caused the download loop to stop, which
+// is mainly useful when testing, since the normal usage of this function
+// is to be run in a separate goroutine. Note that this function would
+// block if you don't read from the channel.
+//
+// The client measurements also contain the CPU time used by the process
+// since the beginning of the test, where supported.
 //
 // Note that this function closes conn and ch when exiting.
-func Run(ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement) error {
+func Run(ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+	opts params.TestOptions) error {
 	defer close(ch)
 	defer conn.Close()
-	wholectx, cancel := context.WithTimeout(ctx, params.DownloadTimeout)
+	wholectx, cancel := context.WithTimeout(ctx, opts.DownloadDuration)
 	defer cancel()
-	conn.SetReadLimit(params.MaxMessageSize)
+	conn.SetReadLimit(int64(opts.MaxMessageSize))
 	start := time.Now()
+	meter := cpux.NewMeter()
 	prev := start
 	sent := false
+	detector := &convergence.Detector{
+		Tolerance:   opts.ConvergenceTolerance,
+		MinDuration: opts.ConvergenceMinDuration,
+		Window:      params.ConvergenceWindow,
+	}
 	var total int64
 	for wholectx.Err() == nil {
-		err := conn.SetReadDeadline(time.Now().Add(params.IOTimeout))
+		err := conn.SetReadDeadline(time.Now().Add(opts.IOTimeout))
 		if err != nil {
 			return err
 		}
@@ -42,7 +59,7 @@ func Run(ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement)
 			// If the test finishes before any measurements have been sent through the channel,
 			// and at least one message has been received, send the measurement data before exiting.
 			if !sent && total > 0 {
-				sendMeasurement(ch, time.Now().Sub(start), total)
+				sendMeasurement(ch, conn, meter, time.Now().Sub(start), total, false)
 			}
 			return err
 		}
@@ -63,36 +80,53 @@ func Run(ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement)
 			msgSize = int64(len(mdata))
 		}
 		total += msgSize
+		limited := opts.MaxBytes > 0 && total >= opts.MaxBytes
+		converged := false
 		now := time.Now()
-		if now.Sub(prev) > params.UpdateInterval {
+		if limited || now.Sub(prev) > opts.UpdateInterval {
 			prev = now
 			elapsed := now.Sub(start)
-			sendMeasurement(ch, elapsed, total)
+			converged = detector.Add(elapsed, total)
+			sendMeasurement(ch, conn, meter, elapsed, total, converged)
 			sent = true
 			// FALLTHROUGH
 		}
-		if mtype != websocket.TextMessage {
-			continue
+		if mtype == websocket.TextMessage {
+			var measurement spec.Measurement
+			err = json.Unmarshal(mdata, &measurement)
+			if err != nil {
+				return err
+			}
+			measurement.Origin = spec.OriginServer
+			measurement.Test = spec.TestDownload
+			ch <- measurement
 		}
-		var measurement spec.Measurement
-		err = json.Unmarshal(mdata, &measurement)
-		if err != nil {
-			return err
+		if limited || converged {
+			// We're not interested in whether the server got the close
+			// message, since we're closing the connection anyway.
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(opts.IOTimeout))
+			return nil
 		}
-		measurement.Origin = spec.OriginServer
-		measurement.Test = spec.TestDownload
-		ch <- measurement
 	}
 	return nil // this is how success looks like
 }
 
-func sendMeasurement(ch chan<- spec.Measurement, elapsed time.Duration, total int64) {
+// sendMeasurement emits a client measurement, marking whether the
+// throughput converged. When possible, it also includes the client side
+// TCP_INFO of conn and the CPU time measured by meter.
+func sendMeasurement(ch chan<- spec.Measurement, conn websocketx.Conn, meter *cpux.Meter,
+	elapsed time.Duration, total int64, converged bool) {
 	ch <- spec.Measurement{
 		AppInfo: &spec.AppInfo{
 			ElapsedTime: int64(elapsed) / int64(time.Microsecond),
 			NumBytes:    total,
 		},
-		Origin: spec.OriginClient,
-		Test:   spec.TestDownload,
+		CPUInfo:   meter.Sample(elapsed),
+		Converged: converged,
+		Origin:    spec.OriginClient,
+		TCPInfo:   tcpinfox.Sample(conn.NetConn(), elapsed),
+		Test:      spec.TestDownload,
 	}
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/m-lab/ndt7-client-go/internal/mocks"
+	"github.com/m-lab/ndt7-client-go/internal/params"
 	"github.com/m-lab/ndt7-client-go/spec"
 	"github.com/m-lab/tcp-info/inetdiag"
 	"github.com/m-lab/tcp-info/tcp"
@@ -51,7 +52,7 @@ func TestReadText(t *testing.T) {
 		MessageByteArray:      data,
 	}
 	go func() {
-		err := Run(ctx, &conn, outch)
+		err := Run(ctx, &conn, outch, params.DefaultTestOptions())
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -93,7 +94,7 @@ func TestReadBinary(t *testing.T) {
 		MessageByteArray:      []byte("12345678"),
 	}
 	go func() {
-		err := Run(ctx, &conn, outch)
+		err := Run(ctx, &conn, outch, params.DefaultTestOptions())
 		if err != nil {
 			t.Errorf("error: %v", err)
 		}
@@ -138,7 +139,7 @@ func TestSetReadDeadlineError(t *testing.T) {
 			t.Error("We didn't expect measurements here")
 		}
 	}()
-	err := Run(ctx, &conn, outch)
+	err := Run(ctx, &conn, outch, params.DefaultTestOptions())
 	if err != mockedErr {
 		t.Fatal("Not the error that we were expecting")
 	}
@@ -161,7 +162,7 @@ func TestReadMessageError(t *testing.T) {
 			t.Error("We didn't expect measurements here")
 		}
 	}()
-	err := Run(ctx, &conn, outch)
+	err := Run(ctx, &conn, outch, params.DefaultTestOptions())
 	if err != mockedErr {
 		t.Fatal("Not the error that we were expecting")
 	}
@@ -206,7 +207,7 @@ func TestReaderError(t *testing.T) {
 				}
 			}()
 
-			if err := Run(ctx, &conn, outch); !errors.Is(err, mocks.ErrReadFailed) {
+			if err := Run(ctx, &conn, outch, params.DefaultTestOptions()); !errors.Is(err, mocks.ErrReadFailed) {
 				t.Fatal("Not the error that we were expecting", err)
 			}
 		})
@@ -228,8 +229,86 @@ func TestReadInvalidJSON(t *testing.T) {
 			t.Error("We didn't expect measurements here")
 		}
 	}()
-	err := Run(ctx, &conn, outch)
+	err := Run(ctx, &conn, outch, params.DefaultTestOptions())
 	if err == nil {
 		t.Fatal("We expected to have an error here")
 	}
 }
+
+func TestDownloadDuration(t *testing.T) {
+	outch := make(chan spec.Measurement)
+	conn := mocks.Conn{
+		NextReaderMessageType: websocket.BinaryMessage,
+		MessageByteArray:      []byte("12345678"),
+	}
+	go func() {
+		for range outch {
+			// drain
+		}
+	}()
+	opts := params.DefaultTestOptions()
+	opts.DownloadDuration = 100 * time.Millisecond
+	start := time.Now()
+	err := Run(context.Background(), &conn, outch, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if elapsed < opts.DownloadDuration || elapsed > opts.DownloadDuration+500*time.Millisecond {
+		t.Fatalf("download did not honor the configured duration: %v", elapsed)
+	}
+}
+
+func TestDownloadMaxBytes(t *testing.T) {
+	outch := make(chan spec.Measurement)
+	conn := mocks.Conn{
+		NextReaderMessageType: websocket.BinaryMessage,
+		MessageByteArray:      []byte("12345678"),
+	}
+	opts := params.DefaultTestOptions()
+	opts.MaxBytes = 100
+	errch := make(chan error, 1)
+	go func() {
+		errch <- Run(context.Background(), &conn, outch, opts)
+	}()
+	var last spec.Measurement
+	for m := range outch {
+		last = m
+	}
+	if err := <-errch; err != nil {
+		t.Fatal(err)
+	}
+	if last.AppInfo == nil || last.AppInfo.NumBytes != 104 {
+		t.Fatalf("expected a final measurement with 104 bytes, got %+v", last)
+	}
+}
+
+func TestDownloadConvergence(t *testing.T) {
+	outch := make(chan spec.Measurement)
+	conn := mocks.Conn{
+		NextReaderMessageType: websocket.BinaryMessage,
+		MessageByteArray:      []byte("12345678"),
+	}
+	opts := params.DefaultTestOptions()
+	opts.DownloadDuration = 4 * params.ConvergenceWindow
+	opts.ConvergenceTolerance = 0.5
+	opts.ConvergenceMinDuration = 0
+	errch := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		errch <- Run(context.Background(), &conn, outch, opts)
+	}()
+	var last spec.Measurement
+	for m := range outch {
+		last = m
+	}
+	if err := <-errch; err != nil {
+		t.Fatal(err)
+	}
+	if !last.Converged {
+		t.Fatalf("expected the final measurement to be converged, got %+v", last)
+	}
+	if elapsed := time.Since(start); elapsed >= opts.DownloadDuration {
+		t.Fatalf("the download did not stop early: %v", elapsed)
+	}
+}
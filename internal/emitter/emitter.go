@@ -29,6 +29,22 @@ type Emitter interface {
 	// OnUploadEvent is emitted during the upload.
 	OnUploadEvent(m *spec.Measurement) error
 
+	// OnServerSelection is emitted after probing the servers returned by
+	// the Locate API to choose the one with the lowest RTT.
+	OnServerSelection(probes []spec.ServerProbe) error
+
+	// OnLatencyEvent is emitted when the latency probe takes a sample
+	// before or during the download or the upload.
+	OnLatencyEvent(m *spec.Measurement) error
+
+	// OnConnectionTiming is emitted after connecting to the ndt7 server,
+	// or failing to, with how long each phase of connecting took.
+	OnConnectionTiming(test spec.TestKind, timing *spec.ConnectionTiming) error
+
+	// OnWarning is emitted when a test ran but its result may not be
+	// accurate, e.g., because the client was the bottleneck.
+	OnWarning(test spec.TestKind, warning string) error
+
 	// OnComplete is always emitted when the test is over.
 	OnComplete(test spec.TestKind) error
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/m-lab/ndt7-client-go/spec"
 )
@@ -38,6 +39,18 @@ func (h HumanReadable) OnError(test spec.TestKind, err error) error {
 	return failure
 }
 
+// OnConnectionTiming handles how long connecting to the server took,
+// which we show in the summary
+func (h HumanReadable) OnConnectionTiming(test spec.TestKind, timing *spec.ConnectionTiming) error {
+	return nil
+}
+
+// OnWarning handles the warning event
+func (h HumanReadable) OnWarning(test spec.TestKind, warning string) error {
+	_, err := fmt.Fprintf(h.out, "\r%s warning: %s\n", test, warning)
+	return err
+}
+
 // OnConnected handles the connected event
 func (h HumanReadable) OnConnected(test spec.TestKind, fqdn string) error {
 	_, err := fmt.Fprintf(h.out, "\r%s in progress with %s\n", test, fqdn)
@@ -55,31 +68,61 @@ func (h HumanReadable) OnUploadEvent(m *spec.Measurement) error {
 }
 
 func (h HumanReadable) onSpeedEvent(m *spec.Measurement) error {
+	v, ok, err := averageSpeed(m)
+	if !ok || err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(h.out, "\r%s: %7.1f Mbit/s", speedLabel(m), v)
+	return err
+}
+
+// speedLabel returns the label of the average speed of m, which tells the
+// direction apart, since the bidirectional test shows the speed of both.
+func speedLabel(m *spec.Measurement) string {
+	return fmt.Sprintf("%-8s avg. speed", m.Test)
+}
+
+// averageSpeed returns the average speed in Mbit/s since the beginning of
+// the test according to m, and whether we show the speed of m at all.
+func averageSpeed(m *spec.Measurement) (float64, bool, error) {
 	// The specification recommends that we show application level
 	// measurements. Let's just do that in interactive mode. To this
-	// end, we ignore any measurement coming from the server.
+	// end, we ignore any measurement coming from the server. In
+	// multi-stream tests, we only show the aggregate measurements.
+	if m.Stream != 0 {
+		return 0, false, nil
+	}
 	switch m.Test {
 	case spec.TestDownload:
 		if m.Origin == spec.OriginClient {
 			if m.AppInfo == nil || m.AppInfo.ElapsedTime <= 0 {
-				return errors.New("missing AppInfo or invalid ElapsedTime")
+				return 0, false, errors.New("missing AppInfo or invalid ElapsedTime")
 			}
 			elapsed := float64(m.AppInfo.ElapsedTime)
-			v := 8.0 * float64(m.AppInfo.NumBytes) / elapsed
-			_, err := fmt.Fprintf(h.out, "\rAvg. speed  : %7.1f Mbit/s", v)
-			return err
+			return 8.0 * float64(m.AppInfo.NumBytes) / elapsed, true, nil
 		}
 	case spec.TestUpload:
 		if m.Origin == spec.OriginServer {
 			if m.TCPInfo == nil || m.TCPInfo.ElapsedTime <= 0 {
-				return errors.New("missing TCPInfo or invalid ElapsedTime")
+				return 0, false, errors.New("missing TCPInfo or invalid ElapsedTime")
 			}
 			elapsed := float64(m.TCPInfo.ElapsedTime)
-			v := 8.0 * float64(m.TCPInfo.BytesReceived) / elapsed
-			_, err := fmt.Fprintf(h.out, "\rAvg. speed  : %7.1f Mbit/s", v)
-			return err
+			return 8.0 * float64(m.TCPInfo.BytesReceived) / elapsed, true, nil
 		}
 	}
+	return 0, false, nil
+}
+
+// OnServerSelection handles the results of probing the servers. We show
+// them in the summary.
+func (h HumanReadable) OnServerSelection(probes []spec.ServerProbe) error {
+	return nil
+}
+
+// OnLatencyEvent handles a sample taken by the latency probe. We do not
+// show the samples, which would interfere with the speed display, and we
+// only show the resulting percentiles in the summary.
+func (h HumanReadable) OnLatencyEvent(m *spec.Measurement) error {
 	return nil
 }
 
@@ -108,12 +151,30 @@ Test results
 %15s: %7.1f %s
 %15s: %7.1f %s
 `
+	const bidirectionalFormat = `
+%27s
+%15s: %7.1f %s
+%15s: %7.1f %s
+%15s: %7.1f %s
+%15s: %7.1f %s
+`
+	if s.IPv4 != nil || s.IPv6 != nil {
+		return h.onDualStackSummary(s)
+	}
+
 	_, err := fmt.Fprintf(h.out, summaryHeaderFormat,
 		"Server", s.ServerFQDN,
 		"Client", s.ClientIP)
 	if err != nil {
 		return err
 	}
+	if err := h.onBindingSummary(s); err != nil {
+		return err
+	}
+
+	if err := h.onServerSelectionSummary(s.ServerSelection); err != nil {
+		return err
+	}
 
 	if s.Download != nil {
 		_, err := fmt.Fprintf(h.out, downloadFormat, "Download",
@@ -123,6 +184,27 @@ Test results
 		if err != nil {
 			return err
 		}
+		if err := h.onClientSummary(s.Download, false); err != nil {
+			return err
+		}
+		if err := h.onEstimatorSummary(s.Download); err != nil {
+			return err
+		}
+		if err := h.onConvergenceSummary(s.Download, s.Options); err != nil {
+			return err
+		}
+		if err := h.onBudgetSummary(s.Download); err != nil {
+			return err
+		}
+		if err := h.onStreamsSummary(s.Download.Streams); err != nil {
+			return err
+		}
+		if err := h.onResponsivenessSummary(s.Download.Responsiveness); err != nil {
+			return err
+		}
+		if err := h.onConnectionTimingSummary(s.Download.ConnectionTiming); err != nil {
+			return err
+		}
 	}
 
 	if s.Upload != nil {
@@ -132,7 +214,361 @@ Test results
 		if err != nil {
 			return err
 		}
+		if err := h.onClientSummary(s.Upload, true); err != nil {
+			return err
+		}
+		if err := h.onEstimatorSummary(s.Upload); err != nil {
+			return err
+		}
+		if err := h.onConvergenceSummary(s.Upload, s.Options); err != nil {
+			return err
+		}
+		if err := h.onBudgetSummary(s.Upload); err != nil {
+			return err
+		}
+		if err := h.onStreamsSummary(s.Upload.Streams); err != nil {
+			return err
+		}
+		if err := h.onResponsivenessSummary(s.Upload.Responsiveness); err != nil {
+			return err
+		}
+		if err := h.onConnectionTimingSummary(s.Upload.ConnectionTiming); err != nil {
+			return err
+		}
+	}
+
+	if s.Bidirectional != nil {
+		dl, ul := s.Bidirectional.Download, s.Bidirectional.Upload
+		_, err := fmt.Fprintf(h.out, bidirectionalFormat, "Bidirectional",
+			"Download", dl.Throughput.Value, dl.Throughput.Unit,
+			"Download lat.", dl.Latency.Value, dl.Latency.Unit,
+			"Upload", ul.Throughput.Value, ul.Throughput.Unit,
+			"Upload lat.", ul.Latency.Value, ul.Latency.Unit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return h.onOptionsSummary(s.Options)
+}
+
+// onOptionsSummary prints the options used to run the subtests, if any.
+func (h HumanReadable) onOptionsSummary(o *OptionsSummary) error {
+	const optionsFormat = `
+%21s
+%15s: %7.2f %s
+%15s: %7.2f %s
+%15s: %7.2f %s
+%15s: %7.2f %s
+%15s: %7.0f %s
+%15s: %7d
+`
+	if o == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(h.out, optionsFormat, "Options",
+		"Download", o.DownloadDuration.Value, o.DownloadDuration.Unit,
+		"Upload", o.UploadDuration.Value, o.UploadDuration.Unit,
+		"I/O timeout", o.IOTimeout.Value, o.IOTimeout.Unit,
+		"Update", o.UpdateInterval.Value, o.UpdateInterval.Unit,
+		"Max message", o.MaxMessageSize.Value, o.MaxMessageSize.Unit,
+		"Scaling", o.ScalingFraction)
+	if err != nil {
+		return err
+	}
+	if o.MaxBytes != nil {
+		_, err = fmt.Fprintf(h.out, "%15s: %7.0f %s\n", "Max bytes",
+			o.MaxBytes.Value, o.MaxBytes.Unit)
+		if err != nil {
+			return err
+		}
+	}
+	if o.ConvergenceTolerance != nil {
+		_, err = fmt.Fprintf(h.out, "%15s: %7.1f %s\n", "Convergence",
+			o.ConvergenceTolerance.Value, o.ConvergenceTolerance.Unit)
+		if err != nil {
+			return err
+		}
+	}
+	if o.ConvergenceMinDuration != nil {
+		_, err = fmt.Fprintf(h.out, "%15s: %7.2f %s\n", "Min duration",
+			o.ConvergenceMinDuration.Value, o.ConvergenceMinDuration.Unit)
+	}
+	return err
+}
+
+// onEstimatorSummary notes which estimator computed the throughput of a
+// subtest, unless it's the mean of the whole subtest.
+func (h HumanReadable) onEstimatorSummary(s *SubtestSummary) error {
+	if s.ThroughputEstimator == "" || s.ThroughputEstimator == "mean" {
+		return nil
+	}
+	_, err := fmt.Fprintf(h.out, "%15s: %s\n", "Estimator", s.ThroughputEstimator)
+	return err
+}
+
+// onConvergenceSummary notes, when the options enable convergence, whether
+// a subtest stopped because its throughput converged or ran until its
+// duration expired. A subtest stopped by the budget is noted by
+// onBudgetSummary instead.
+func (h HumanReadable) onConvergenceSummary(s *SubtestSummary, o *OptionsSummary) error {
+	if o == nil || o.ConvergenceTolerance == nil {
+		return nil
+	}
+	var err error
+	switch {
+	case s.Converged:
+		_, err = fmt.Fprintf(h.out, "%15s: %s\n", "Convergence", "reached, stopped early")
+	case !s.BudgetLimited:
+		_, err = fmt.Fprintf(h.out, "%15s: %s\n", "Convergence", "not reached, ran until timeout")
+	}
+	return err
+}
+
+// onBudgetSummary notes when a subtest stopped because it transferred
+// the maximum number of bytes allowed by the options.
+func (h HumanReadable) onBudgetSummary(s *SubtestSummary) error {
+	if !s.BudgetLimited {
+		return nil
+	}
+	_, err := fmt.Fprintf(h.out, "%15s: %s\n", "Budget", "reached, stopped early")
+	return err
+}
+
+// onDualStackSummary prints the results of the IPv4 and of the IPv6
+// subtests side by side.
+func (h HumanReadable) onDualStackSummary(s *Summary) error {
+	const dualStackHeaderFormat = `
+Test results
+
+%10s: %s
+`
+	_, err := fmt.Fprintf(h.out, dualStackHeaderFormat, "Server", s.ServerFQDN)
+	if err != nil {
+		return err
+	}
+	if err := h.onBindingSummary(s); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(h.out, "\n%15s  %-25s %s\n", "", "IPv4", "IPv6")
+	if err != nil {
+		return err
+	}
+	names := []string{"Client", "Server IP", "Download", "Download lat.",
+		"Upload", "Upload lat."}
+	v4, v6 := dualStackColumn(s.IPv4), dualStackColumn(s.IPv6)
+	for i, name := range names {
+		if _, err := fmt.Fprintf(h.out, "%15s: %-25s %s\n", name, v4[i], v6[i]); err != nil {
+			return err
+		}
+	}
+	return h.onOptionsSummary(s.Options)
+}
+
+// dualStackColumn returns the values shown by onDualStackSummary for the
+// address family whose summary is s, i.e., the client and server IPs, and
+// the throughput and latency of the download and of the upload.
+func dualStackColumn(s *Summary) []string {
+	column := []string{"-", "-", "-", "-", "-", "-"}
+	if s == nil {
+		return column
+	}
+	if s.ClientIP != "" {
+		column[0] = s.ClientIP
+	}
+	if s.ServerIP != "" {
+		column[1] = s.ServerIP
+	}
+	if s.Download != nil {
+		column[2] = formatPair(s.Download.Throughput)
+		column[3] = formatPair(s.Download.Latency)
+	}
+	if s.Upload != nil {
+		column[4] = formatPair(s.Upload.Throughput)
+		column[5] = formatPair(s.Upload.Latency)
+	}
+	return column
+}
+
+// formatPair formats p like the other values of the summary.
+func formatPair(p ValueUnitPair) string {
+	if p.Unit == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f %s", p.Value, p.Unit)
+}
+
+// onBindingSummary prints the network interface, the source address and
+// the proxy used by the client, if set, and the TLS parameters negotiated
+// with the server.
+func (h HumanReadable) onBindingSummary(s *Summary) error {
+	if s.Interface != "" {
+		if _, err := fmt.Fprintf(h.out, "%10s: %s\n", "Interface", s.Interface); err != nil {
+			return err
+		}
+	}
+	if s.SourceAddress != "" {
+		if _, err := fmt.Fprintf(h.out, "%10s: %s\n", "Source", s.SourceAddress); err != nil {
+			return err
+		}
+	}
+	if s.Proxy != "" {
+		if _, err := fmt.Fprintf(h.out, "%10s: %s\n", "Proxy", s.Proxy); err != nil {
+			return err
+		}
+	}
+	return h.onTLSSummary(s.TLS)
+}
+
+// onTLSSummary prints the TLS version and cipher suite negotiated with the
+// server, and the subject of its certificate, if any.
+func (h HumanReadable) onTLSSummary(t *TLSSummary) error {
+	if t == nil {
+		return nil
+	}
+	if _, err := fmt.Fprintf(h.out, "%10s: %s, %s\n", "TLS", t.Version, t.CipherSuite); err != nil {
+		return err
+	}
+	if t.PeerSubject != "" {
+		if _, err := fmt.Fprintf(h.out, "%10s: %s\n", "Peer", t.PeerSubject); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onServerSelectionSummary prints the RTT of each server we probed to
+// choose the server with the lowest RTT, if any.
+func (h HumanReadable) onServerSelectionSummary(probes []*ServerProbeSummary) error {
+	if len(probes) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(h.out, "\n%30s\n", "Server selection"); err != nil {
+		return err
+	}
+	for _, p := range probes {
+		var err error
+		if p.RTT != nil {
+			_, err = fmt.Fprintf(h.out, "%24.1f %s  %s\n", p.RTT.Value, p.RTT.Unit, p.Server)
+		} else {
+			_, err = fmt.Fprintf(h.out, "%24s  %s: %s\n", "failed", p.Server, p.Failure)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onClientSummary prints the values measured by the client, if available,
+// i.e., using the client side TCP_INFO, the client latency and, if
+// retransmission is true, the retransmission rate, and the client CPU
+// utilization, noting when the client likely limited the throughput.
+func (h HumanReadable) onClientSummary(s *SubtestSummary, retransmission bool) error {
+	if retransmission && s.Retransmission.Unit != "" {
+		_, err := fmt.Fprintf(h.out, "%15s: %7.1f %s\n", "Retransmission",
+			s.Retransmission.Value, s.Retransmission.Unit)
+		if err != nil {
+			return err
+		}
+	}
+	if s.ClientLatency != nil {
+		_, err := fmt.Fprintf(h.out, "%15s: %7.1f %s\n", "Client latency",
+			s.ClientLatency.Value, s.ClientLatency.Unit)
+		if err != nil {
+			return err
+		}
+	}
+	if s.ClientCPU != nil {
+		limited := ""
+		if s.ClientLimited {
+			limited = " (client-limited)"
+		}
+		_, err := fmt.Fprintf(h.out, "%15s: %7.1f %s%s\n", "Client CPU",
+			s.ClientCPU.Value, s.ClientCPU.Unit, limited)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onResponsivenessSummary prints the median idle and loaded latency, the
+// 90th percentile of the loaded latency and the responsiveness, if any.
+func (h HumanReadable) onResponsivenessSummary(r *ResponsivenessSummary) error {
+	if r == nil {
+		return nil
+	}
+	if r.Idle != nil {
+		_, err := fmt.Fprintf(h.out, "%15s: %7.1f %s\n", "Idle latency",
+			r.Idle.P50.Value, r.Idle.P50.Unit)
+		if err != nil {
+			return err
+		}
+	}
+	if r.Loaded != nil {
+		_, err := fmt.Fprintf(h.out, "%15s: %7.1f %s (p90: %.1f %s)\n%15s: %7.0f %s\n",
+			"Loaded latency", r.Loaded.P50.Value, r.Loaded.P50.Unit,
+			r.Loaded.P90.Value, r.Loaded.P90.Unit,
+			"Responsiveness", r.RPM.Value, r.RPM.Unit)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// onConnectionTimingSummary prints how long connecting to the server took,
+// followed by the duration of each phase that occurred, and how long the
+// query to the Locate API took, if any.
+func (h HumanReadable) onConnectionTimingSummary(t *ConnectionTimingSummary) error {
+	if t == nil {
+		return nil
+	}
+	if t.Locate != nil {
+		_, err := fmt.Fprintf(h.out, "%15s: %7.1f %s\n", "Locate", t.Locate.Value, t.Locate.Unit)
+		if err != nil {
+			return err
+		}
+	}
+	var phases []string
+	for _, p := range []struct {
+		name  string
+		value *ValueUnitPair
+	}{
+		{"DNS", t.DNS},
+		{"TCP", t.TCPConnect},
+		{"TLS", t.TLSHandshake},
+		{"WebSocket", t.WebSocketUpgrade},
+	} {
+		if p.value != nil {
+			phases = append(phases, fmt.Sprintf("%s: %.1f %s", p.name, p.value.Value, p.value.Unit))
+		}
+	}
+	details := ""
+	if len(phases) > 0 {
+		details = " (" + strings.Join(phases, ", ") + ")"
+	}
+	_, err := fmt.Fprintf(h.out, "%15s: %7.1f %s%s\n", "Connect",
+		t.Total.Value, t.Total.Unit, details)
+	return err
+}
+
+// onStreamsSummary prints the throughput and UUID of each stream, or the
+// reason why a stream failed.
+func (h HumanReadable) onStreamsSummary(streams []*SubtestSummary) error {
+	for i, stream := range streams {
+		name := fmt.Sprintf("Stream %d", i+1)
+		var err error
+		if stream.Failure != "" {
+			_, err = fmt.Fprintf(h.out, "%15s: failed: %s\n", name, stream.Failure)
+		} else {
+			_, err = fmt.Fprintf(h.out, "%15s: %7.1f %s (%s)\n", name,
+				stream.Throughput.Value, stream.Throughput.Unit, stream.UUID)
+		}
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
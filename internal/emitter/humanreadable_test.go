@@ -55,6 +55,21 @@ func TestHumanReadableOnErrorFailure(t *testing.T) {
 	}
 }
 
+func TestHumanReadableOnWarning(t *testing.T) {
+	sw := &mocks.SavingWriter{}
+	hr := HumanReadable{sw}
+	err := hr.OnWarning("download", "mocked warning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 1 {
+		t.Fatal("invalid length")
+	}
+	if !reflect.DeepEqual(sw.Data[0], []byte("\rdownload warning: mocked warning\n")) {
+		t.Fatal("unexpected output")
+	}
+}
+
 func TestHumanReadableOnConnected(t *testing.T) {
 	sw := &mocks.SavingWriter{}
 	hr := HumanReadable{sw}
@@ -97,7 +112,7 @@ func TestHumanReadableOnDownloadEvent(t *testing.T) {
 	}
 	if !reflect.DeepEqual(
 		sw.Data[0],
-		[]byte("\rAvg. speed  :   266.7 Mbit/s"),
+		[]byte("\rdownload avg. speed:   266.7 Mbit/s"),
 	) {
 		t.Fatal("unexpected output")
 	}
@@ -150,12 +165,52 @@ func TestHumanReadableOnUploadEvent(t *testing.T) {
 	}
 	if !reflect.DeepEqual(
 		sw.Data[0],
-		[]byte("\rAvg. speed  :     8.0 Mbit/s"),
+		[]byte("\rupload   avg. speed:     8.0 Mbit/s"),
 	) {
 		t.Fatal("unexpected output")
 	}
 }
 
+func TestHumanReadableInterleavedEvents(t *testing.T) {
+	sw := &mocks.SavingWriter{}
+	hr := HumanReadable{sw}
+	// The bidirectional test interleaves the events of both directions.
+	download := &spec.Measurement{
+		AppInfo: &spec.AppInfo{ElapsedTime: 1000000, NumBytes: 10000000},
+		Origin:  spec.OriginClient,
+		Test:    spec.TestDownload,
+	}
+	upload := &spec.Measurement{
+		TCPInfo: &spec.TCPInfo{},
+		Origin:  spec.OriginServer,
+		Test:    spec.TestUpload,
+	}
+	upload.TCPInfo.BytesReceived = 1000000
+	upload.TCPInfo.ElapsedTime = 1000000
+	for _, emit := range []func() error{
+		func() error { return hr.OnDownloadEvent(download) },
+		func() error { return hr.OnUploadEvent(upload) },
+		func() error { return hr.OnDownloadEvent(download) },
+	} {
+		if err := emit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{
+		"\rdownload avg. speed:    80.0 Mbit/s",
+		"\rupload   avg. speed:     8.0 Mbit/s",
+		"\rdownload avg. speed:    80.0 Mbit/s",
+	}
+	if len(sw.Data) != len(expected) {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	for i, want := range expected {
+		if string(sw.Data[i]) != want {
+			t.Fatalf("unexpected data %q", sw.Data[i])
+		}
+	}
+}
+
 func TestHumanReadableOnUploadEventSafetyCheck(t *testing.T) {
 	sw := &mocks.SavingWriter{}
 	hr := HumanReadable{sw}
@@ -311,3 +366,462 @@ func TestNewHumanReadableWithWriter(t *testing.T) {
 		t.Fatal("NewHumanReadableWithWriter() did not return a HumanReadable")
 	}
 }
+
+func TestHumanReadableOnSummaryOptions(t *testing.T) {
+	expectedOptions := `
+              Options
+       Download:   15.00 s
+         Upload:   10.00 s
+    I/O timeout:    7.00 s
+         Update:    0.25 s
+    Max message: 1048576 bytes
+        Scaling:      16
+`
+	summary := &Summary{
+		Options: &OptionsSummary{
+			DownloadDuration: ValueUnitPair{Value: 15, Unit: "s"},
+			UploadDuration:   ValueUnitPair{Value: 10, Unit: "s"},
+			IOTimeout:        ValueUnitPair{Value: 7, Unit: "s"},
+			UpdateInterval:   ValueUnitPair{Value: 0.25, Unit: "s"},
+			MaxMessageSize:   ValueUnitPair{Value: 1048576, Unit: "bytes"},
+			ScalingFraction:  16,
+		},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 2 {
+		t.Fatal("invalid length")
+	}
+	if string(sw.Data[1]) != expectedOptions {
+		t.Fatalf("OnSummary(): unexpected data %q", sw.Data[1])
+	}
+}
+
+func TestHumanReadableOnSummaryBidirectional(t *testing.T) {
+	expected := `
+              Bidirectional
+       Download:    90.0 Mbit/s
+  Download lat.:    25.0 ms
+         Upload:    40.0 Mbit/s
+    Upload lat.:    30.0 ms
+`
+	summary := &Summary{
+		Bidirectional: &BidirectionalSummary{
+			Download: &SubtestSummary{
+				Throughput: ValueUnitPair{Value: 90, Unit: "Mbit/s"},
+				Latency:    ValueUnitPair{Value: 25, Unit: "ms"},
+			},
+			Upload: &SubtestSummary{
+				Throughput: ValueUnitPair{Value: 40, Unit: "Mbit/s"},
+				Latency:    ValueUnitPair{Value: 30, Unit: "ms"},
+			},
+		},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 2 {
+		t.Fatal("invalid length")
+	}
+	if string(sw.Data[1]) != expected {
+		t.Fatalf("OnSummary(): unexpected data %q", sw.Data[1])
+	}
+}
+
+func TestHumanReadableOnSummaryResponsiveness(t *testing.T) {
+	expected := []string{
+		"   Idle latency:    10.0 ms\n",
+		" Loaded latency:    80.0 ms (p90: 120.0 ms)\n Responsiveness:     750 RPM\n",
+	}
+	summary := &Summary{
+		Upload: &SubtestSummary{
+			Responsiveness: &ResponsivenessSummary{
+				Idle: &LatencySummary{
+					P50: ValueUnitPair{Value: 10, Unit: "ms"},
+				},
+				Loaded: &LatencySummary{
+					P50: ValueUnitPair{Value: 80, Unit: "ms"},
+					P90: ValueUnitPair{Value: 120, Unit: "ms"},
+				},
+				RPM: ValueUnitPair{Value: 750, Unit: "RPM"},
+			},
+		},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 4 {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	for i, line := range expected {
+		if string(sw.Data[2+i]) != line {
+			t.Fatalf("OnSummary(): unexpected data %q", sw.Data[2+i])
+		}
+	}
+}
+
+func TestHumanReadableOnSummaryClientTCPInfo(t *testing.T) {
+	expected := []string{
+		" Client latency:     5.0 ms\n",
+		" Retransmission:     2.0 %\n",
+		" Client latency:     6.0 ms\n",
+	}
+	summary := &Summary{
+		Download: &SubtestSummary{
+			ClientLatency: &ValueUnitPair{Value: 5, Unit: "ms"},
+		},
+		Upload: &SubtestSummary{
+			Retransmission: ValueUnitPair{Value: 2, Unit: "%"},
+			ClientLatency:  &ValueUnitPair{Value: 6, Unit: "ms"},
+		},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 6 {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	for i, idx := range []int{2, 4, 5} {
+		if string(sw.Data[idx]) != expected[i] {
+			t.Fatalf("OnSummary(): unexpected data %q", sw.Data[idx])
+		}
+	}
+}
+
+func TestHumanReadableOnSummaryServerSelection(t *testing.T) {
+	expected := []string{
+		"\n              Server selection\n",
+		"                    12.5 ms  near.example\n",
+		"                  failed  down.example: connection refused\n",
+	}
+	summary := &Summary{
+		ServerSelection: []*ServerProbeSummary{{
+			Server: "near.example",
+			RTT:    &ValueUnitPair{Value: 12.5, Unit: "ms"},
+		}, {
+			Server:  "down.example",
+			Failure: "connection refused",
+		}},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 4 {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	for i, line := range expected {
+		if string(sw.Data[1+i]) != line {
+			t.Fatalf("OnSummary(): unexpected data %q", sw.Data[1+i])
+		}
+	}
+}
+
+func TestHumanReadableOnServerSelection(t *testing.T) {
+	sw := &mocks.SavingWriter{}
+	hr := HumanReadable{sw}
+	err := hr.OnServerSelection([]spec.ServerProbe{{Server: "near.example"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 0 {
+		t.Fatal("OnServerSelection(): unexpected data")
+	}
+}
+
+func TestHumanReadableOnSummaryDualStack(t *testing.T) {
+	expected := []string{
+		"\nTest results\n\n    Server: test\n",
+		"\n                 IPv4                      IPv6\n",
+		"         Client: 192.0.2.1                 2001:db8::1\n",
+		"      Server IP: -                         -\n",
+		"       Download: 100.0 Mbit/s              -\n",
+		"  Download lat.: 10.0 ms                   -\n",
+		"         Upload: -                         -\n",
+		"    Upload lat.: -                         -\n",
+	}
+	summary := &Summary{
+		ServerFQDN: "test",
+		IPv4: &Summary{
+			ClientIP: "192.0.2.1",
+			Download: &SubtestSummary{
+				Throughput: ValueUnitPair{Value: 100, Unit: "Mbit/s"},
+				Latency:    ValueUnitPair{Value: 10, Unit: "ms"},
+			},
+		},
+		IPv6: &Summary{
+			ClientIP: "2001:db8::1",
+		},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != len(expected) {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	for i, line := range expected {
+		if string(sw.Data[i]) != line {
+			t.Fatalf("OnSummary(): unexpected data %q", sw.Data[i])
+		}
+	}
+}
+
+func TestHumanReadableOnSummaryBinding(t *testing.T) {
+	expected := []string{
+		" Interface: wan1\n",
+		"    Source: 192.0.2.1\n",
+		"     Proxy: http://proxy.example.com:3128\n",
+	}
+	summary := &Summary{
+		Interface:     "wan1",
+		SourceAddress: "192.0.2.1",
+		Proxy:         "http://proxy.example.com:3128",
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 4 {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	for i, line := range expected {
+		if string(sw.Data[1+i]) != line {
+			t.Fatalf("OnSummary(): unexpected data %q", sw.Data[1+i])
+		}
+	}
+}
+
+func TestHumanReadableOnSummaryTLS(t *testing.T) {
+	expected := []string{
+		"       TLS: TLS 1.3, TLS_AES_128_GCM_SHA256\n",
+		"      Peer: CN=ndt.example\n",
+	}
+	summary := &Summary{
+		TLS: &TLSSummary{
+			Version:     "TLS 1.3",
+			CipherSuite: "TLS_AES_128_GCM_SHA256",
+			PeerSubject: "CN=ndt.example",
+		},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 3 {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	for i, line := range expected {
+		if string(sw.Data[1+i]) != line {
+			t.Fatalf("OnSummary(): unexpected data %q", sw.Data[1+i])
+		}
+	}
+}
+
+func TestHumanReadableOnSummaryBudget(t *testing.T) {
+	summary := &Summary{
+		Download: &SubtestSummary{BudgetLimited: true},
+		Options: &OptionsSummary{
+			MaxBytes: &ValueUnitPair{Value: 1000, Unit: "bytes"},
+		},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 5 {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	if string(sw.Data[2]) != "         Budget: reached, stopped early\n" {
+		t.Fatalf("OnSummary(): unexpected data %q", sw.Data[2])
+	}
+	if string(sw.Data[4]) != "      Max bytes:    1000 bytes\n" {
+		t.Fatalf("OnSummary(): unexpected data %q", sw.Data[4])
+	}
+}
+
+func TestHumanReadableOnSummaryConvergence(t *testing.T) {
+	summary := &Summary{
+		Download: &SubtestSummary{Converged: true},
+		Upload:   &SubtestSummary{},
+		Options: &OptionsSummary{
+			ConvergenceTolerance:   &ValueUnitPair{Value: 5, Unit: "%"},
+			ConvergenceMinDuration: &ValueUnitPair{Value: 4, Unit: "s"},
+		},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 8 {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	expected := map[int]string{
+		2: "    Convergence: reached, stopped early\n",
+		4: "    Convergence: not reached, ran until timeout\n",
+		6: "    Convergence:     5.0 %\n",
+		7: "   Min duration:    4.00 s\n",
+	}
+	for i, want := range expected {
+		if string(sw.Data[i]) != want {
+			t.Fatalf("OnSummary(): unexpected data %q", sw.Data[i])
+		}
+	}
+}
+
+func TestHumanReadableOnSummaryEstimator(t *testing.T) {
+	summary := &Summary{
+		Download: &SubtestSummary{ThroughputEstimator: "steady-state"},
+		Upload:   &SubtestSummary{ThroughputEstimator: "mean"},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 4 {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	if string(sw.Data[2]) != "      Estimator: steady-state\n" {
+		t.Fatalf("OnSummary(): unexpected data %q", sw.Data[2])
+	}
+}
+
+func TestHumanReadableOnSummaryClientCPU(t *testing.T) {
+	summary := &Summary{
+		Download: &SubtestSummary{
+			ClientCPU:     &ValueUnitPair{Value: 97.5, Unit: "%"},
+			ClientLimited: true,
+		},
+		Upload: &SubtestSummary{
+			ClientCPU: &ValueUnitPair{Value: 12, Unit: "%"},
+		},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 5 {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	expected := map[int]string{
+		2: "     Client CPU:    97.5 % (client-limited)\n",
+		4: "     Client CPU:    12.0 %\n",
+	}
+	for i, want := range expected {
+		if string(sw.Data[i]) != want {
+			t.Fatalf("OnSummary(): unexpected data %q", sw.Data[i])
+		}
+	}
+}
+
+func TestHumanReadableOnSummaryConnectionTiming(t *testing.T) {
+	summary := &Summary{
+		Download: &SubtestSummary{
+			ConnectionTiming: &ConnectionTimingSummary{
+				Locate:           &ValueUnitPair{Value: 45.2, Unit: "ms"},
+				DNS:              &ValueUnitPair{Value: 1.5, Unit: "ms"},
+				TCPConnect:       &ValueUnitPair{Value: 10, Unit: "ms"},
+				WebSocketUpgrade: &ValueUnitPair{Value: 10.5, Unit: "ms"},
+				Total:            ValueUnitPair{Value: 67.4, Unit: "ms"},
+			},
+		},
+		Upload: &SubtestSummary{
+			ConnectionTiming: &ConnectionTimingSummary{
+				Total: ValueUnitPair{Value: 0.5, Unit: "ms"},
+			},
+		},
+	}
+	sw := &mocks.SavingWriter{}
+	j := HumanReadable{sw}
+	err := j.OnSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 6 {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	expected := map[int]string{
+		2: "         Locate:    45.2 ms\n",
+		3: "        Connect:    67.4 ms (DNS: 1.5 ms, TCP: 10.0 ms, WebSocket: 10.5 ms)\n",
+		5: "        Connect:     0.5 ms\n",
+	}
+	for i, want := range expected {
+		if string(sw.Data[i]) != want {
+			t.Fatalf("OnSummary(): unexpected data %q", sw.Data[i])
+		}
+	}
+}
+
+func TestHumanReadableLiveOnDownloadEvent(t *testing.T) {
+	sw := &mocks.SavingWriter{}
+	e := NewHumanReadableLiveWithWriter(sw)
+	m := &spec.Measurement{
+		AppInfo: &spec.AppInfo{ElapsedTime: 1000000, NumBytes: 1000000},
+		Origin:  spec.OriginClient,
+		Test:    spec.TestDownload,
+	}
+	if err := e.OnDownloadEvent(m); err != nil {
+		t.Fatal(err)
+	}
+	m.Throughput = &spec.ThroughputSample{Throughput: 12.5}
+	if err := e.OnDownloadEvent(m); err != nil {
+		t.Fatal(err)
+	}
+	// Server measurements are not shown.
+	server := &spec.Measurement{Origin: spec.OriginServer, Test: spec.TestDownload}
+	if err := e.OnDownloadEvent(server); err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 2 {
+		t.Fatalf("invalid length %d", len(sw.Data))
+	}
+	if string(sw.Data[0]) != "\rdownload avg. speed:     8.0 Mbit/s  Now:       - Mbit/s" {
+		t.Fatalf("unexpected data %q", sw.Data[0])
+	}
+	if string(sw.Data[1]) != "\rdownload avg. speed:     8.0 Mbit/s  Now:    12.5 Mbit/s" {
+		t.Fatalf("unexpected data %q", sw.Data[1])
+	}
+}
+
+func TestHumanReadableLiveOnUploadEventFailure(t *testing.T) {
+	e := NewHumanReadableLiveWithWriter(&mocks.FailingWriter{})
+	tcpInfo := &spec.TCPInfo{ElapsedTime: 1000000}
+	tcpInfo.BytesReceived = 1000000
+	err := e.OnUploadEvent(&spec.Measurement{
+		Origin:  spec.OriginServer,
+		TCPInfo: tcpInfo,
+		Test:    spec.TestUpload,
+	})
+	if err != mocks.ErrMocked {
+		t.Fatal("Not the error we expected")
+	}
+}
@@ -42,6 +42,9 @@ type batchValue struct {
 	spec.Measurement
 	Failure string `json:",omitempty"`
 	Server  string `json:",omitempty"`
+	Warning string `json:",omitempty"`
+
+	ConnectionTiming *spec.ConnectionTiming `json:",omitempty"`
 }
 
 // OnStarting emits the starting event
@@ -69,6 +72,32 @@ func (j jsonEmitter) OnError(test spec.TestKind, err error) error {
 	})
 }
 
+// OnConnectionTiming emits the timing event
+func (j jsonEmitter) OnConnectionTiming(test spec.TestKind, timing *spec.ConnectionTiming) error {
+	return j.emitInterface(batchEvent{
+		Key: "timing",
+		Value: batchValue{
+			Measurement: spec.Measurement{
+				Test: test,
+			},
+			ConnectionTiming: timing,
+		},
+	})
+}
+
+// OnWarning emits the warning event
+func (j jsonEmitter) OnWarning(test spec.TestKind, warning string) error {
+	return j.emitInterface(batchEvent{
+		Key: "warning",
+		Value: batchValue{
+			Measurement: spec.Measurement{
+				Test: test,
+			},
+			Warning: warning,
+		},
+	})
+}
+
 // OnConnected emits the connected event
 func (j jsonEmitter) OnConnected(test spec.TestKind, fqdn string) error {
 	return j.emitInterface(batchEvent{
@@ -98,6 +127,22 @@ func (j jsonEmitter) OnUploadEvent(m *spec.Measurement) error {
 	})
 }
 
+// OnServerSelection handles the results of probing the servers
+func (j jsonEmitter) OnServerSelection(probes []spec.ServerProbe) error {
+	return j.emitInterface(batchEvent{
+		Key:   "selection",
+		Value: probes,
+	})
+}
+
+// OnLatencyEvent handles a sample taken by the latency probe
+func (j jsonEmitter) OnLatencyEvent(m *spec.Measurement) error {
+	return j.emitInterface(batchEvent{
+		Key:   "latency",
+		Value: m,
+	})
+}
+
 // OnComplete is the event signalling the end of the test
 func (j jsonEmitter) OnComplete(test spec.TestKind) error {
 	return j.emitInterface(batchEvent{
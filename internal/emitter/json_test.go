@@ -86,6 +86,73 @@ func TestJSONOnErrorFailure(t *testing.T) {
 	}
 }
 
+func TestJSONOnConnectionTiming(t *testing.T) {
+	sw := &mocks.SavingWriter{}
+	j := NewJSON(sw)
+	err := j.OnConnectionTiming("download", &spec.ConnectionTiming{
+		DNS: 1000, TCPConnect: 2000, WebSocketUpgrade: 3000, Total: 6000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 1 {
+		t.Fatal("invalid length")
+	}
+	var event struct {
+		Key   string
+		Value struct {
+			Test             string
+			ConnectionTiming map[string]int64
+		}
+	}
+	err = json.Unmarshal(sw.Data[0], &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Key != "timing" || event.Value.Test != "download" {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	timing := event.Value.ConnectionTiming
+	if timing["TCPConnect"] != 2000 || timing["Total"] != 6000 {
+		t.Fatalf("unexpected timing: %+v", timing)
+	}
+	if _, found := timing["TLSHandshake"]; found {
+		t.Fatal("expected the phases that did not occur to be omitted")
+	}
+}
+
+func TestJSONOnWarning(t *testing.T) {
+	sw := &mocks.SavingWriter{}
+	j := NewJSON(sw)
+	err := j.OnWarning("upload", "mocked warning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 1 {
+		t.Fatal("invalid length")
+	}
+	var event struct {
+		Key   string
+		Value struct {
+			Test    string
+			Warning string
+		}
+	}
+	err = json.Unmarshal(sw.Data[0], &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Key != "warning" {
+		t.Fatal("Unexpected event key")
+	}
+	if event.Value.Test != "upload" {
+		t.Fatal("Unexpected test field value")
+	}
+	if event.Value.Warning != "mocked warning" {
+		t.Fatal("Unexpected warning field value")
+	}
+}
+
 func TestJSONOnConnected(t *testing.T) {
 	sw := &mocks.SavingWriter{}
 	j := NewJSON(sw)
@@ -240,6 +307,81 @@ func TestJSONOnUploadEventFailure(t *testing.T) {
 	}
 }
 
+func TestJSONOnLatencyEvent(t *testing.T) {
+	sw := &mocks.SavingWriter{}
+	j := NewJSON(sw)
+	err := j.OnLatencyEvent(&spec.Measurement{
+		Latency: &spec.LatencyInfo{
+			RTT:    25000,
+			Loaded: true,
+		},
+		Test:   spec.TestDownload,
+		Origin: spec.OriginClient,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 1 {
+		t.Fatal("invalid length")
+	}
+	var event struct {
+		Key   string
+		Value struct {
+			Latency struct {
+				RTT    int64
+				Loaded bool
+			}
+			Test string
+		}
+	}
+	err = json.Unmarshal(sw.Data[0], &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Key != "latency" {
+		t.Fatal("Unexpected event key")
+	}
+	if event.Value.Latency.RTT != 25000 || !event.Value.Latency.Loaded {
+		t.Fatal("Unexpected latency field value")
+	}
+	if event.Value.Test != "download" {
+		t.Fatal("Unexpected direction field value")
+	}
+}
+
+func TestJSONOnServerSelection(t *testing.T) {
+	sw := &mocks.SavingWriter{}
+	j := NewJSON(sw)
+	err := j.OnServerSelection([]spec.ServerProbe{{
+		Server: "near.example",
+		RTT:    12500,
+	}, {
+		Server:  "down.example",
+		Failure: "connection refused",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 1 {
+		t.Fatal("invalid length")
+	}
+	var event struct {
+		Key   string
+		Value []spec.ServerProbe
+	}
+	err = json.Unmarshal(sw.Data[0], &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Key != "selection" {
+		t.Fatal("Unexpected event key")
+	}
+	if len(event.Value) != 2 || event.Value[0].RTT != 12500 ||
+		event.Value[1].Failure != "connection refused" {
+		t.Fatalf("Unexpected value: %+v", event.Value)
+	}
+}
+
 func TestJSONOnComplete(t *testing.T) {
 	sw := &mocks.SavingWriter{}
 	j := NewJSON(sw)
@@ -0,0 +1,50 @@
+package emitter
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// humanReadableLive is like HumanReadable but also shows the throughput
+// measured during the latest update interval next to the average speed.
+type humanReadableLive struct {
+	HumanReadable
+}
+
+// NewHumanReadableLive returns a new human readable emitter that also shows
+// the instantaneous throughput.
+func NewHumanReadableLive() Emitter {
+	return humanReadableLive{HumanReadable{os.Stdout}}
+}
+
+// NewHumanReadableLiveWithWriter returns a new human readable emitter that
+// also shows the instantaneous throughput using the specified writer.
+func NewHumanReadableLiveWithWriter(w io.Writer) Emitter {
+	return humanReadableLive{HumanReadable{w}}
+}
+
+// OnDownloadEvent handles an event emitted by the download test
+func (h humanReadableLive) OnDownloadEvent(m *spec.Measurement) error {
+	return h.onLiveSpeedEvent(m)
+}
+
+// OnUploadEvent handles an event emitted during the upload test
+func (h humanReadableLive) OnUploadEvent(m *spec.Measurement) error {
+	return h.onLiveSpeedEvent(m)
+}
+
+func (h humanReadableLive) onLiveSpeedEvent(m *spec.Measurement) error {
+	v, ok, err := averageSpeed(m)
+	if !ok || err != nil {
+		return err
+	}
+	now := "      -"
+	if m.Throughput != nil {
+		now = fmt.Sprintf("%7.1f", m.Throughput.Throughput)
+	}
+	_, err = fmt.Fprintf(h.out, "\r%s: %7.1f Mbit/s  Now: %s Mbit/s", speedLabel(m), v, now)
+	return err
+}
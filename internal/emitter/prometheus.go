@@ -31,12 +31,17 @@ type Prometheus struct {
 	// Value: time in seconds since unix epoch
 	// labels: test, result
 	lastResult *prometheus.GaugeVec
+	// Client limited
+	// Value: 1 if the client CPU likely limited the throughput, else 0
+	// Labels: client_ip, server_ip, interface, test
+	clientLimited *prometheus.GaugeVec
 }
 
 // NewPrometheus returns a Summary emitter which emits messages
 // via the passed Emitter.
-func NewPrometheus(e Emitter, dlThroughput, dlLatency, ulThroughput, ulLatency, lastResult *prometheus.GaugeVec) Emitter {
-	return &Prometheus{e, dlThroughput, dlLatency, ulThroughput, ulLatency, lastResult}
+func NewPrometheus(e Emitter, dlThroughput, dlLatency, ulThroughput, ulLatency, lastResult,
+	clientLimited *prometheus.GaugeVec) Emitter {
+	return &Prometheus{e, dlThroughput, dlLatency, ulThroughput, ulLatency, lastResult, clientLimited}
 }
 
 // OnStarting emits the starting event
@@ -66,6 +71,26 @@ func (p Prometheus) OnUploadEvent(m *spec.Measurement) error {
 	return p.emitter.OnUploadEvent(m)
 }
 
+// OnServerSelection handles the results of probing the servers
+func (p Prometheus) OnServerSelection(probes []spec.ServerProbe) error {
+	return p.emitter.OnServerSelection(probes)
+}
+
+// OnLatencyEvent handles a sample taken by the latency probe
+func (p Prometheus) OnLatencyEvent(m *spec.Measurement) error {
+	return p.emitter.OnLatencyEvent(m)
+}
+
+// OnConnectionTiming handles how long connecting to the server took
+func (p Prometheus) OnConnectionTiming(test spec.TestKind, timing *spec.ConnectionTiming) error {
+	return p.emitter.OnConnectionTiming(test, timing)
+}
+
+// OnWarning emits the warning event
+func (p Prometheus) OnWarning(test spec.TestKind, warning string) error {
+	return p.emitter.OnWarning(test, warning)
+}
+
 // OnComplete is the event signalling the end of the test
 func (p Prometheus) OnComplete(test spec.TestKind) error {
 	g := p.lastResult.WithLabelValues(string(test), "OK")
@@ -78,13 +103,40 @@ func (p *Prometheus) OnSummary(s *Summary) error {
 	// Note this assumes download and upload throughput units are Mbit/s
 	// and latency units are msecs.
 	p.dlTp.Reset()
-	p.dlTp.WithLabelValues(s.ClientIP, s.ServerIP).Set(s.Download.Throughput.Value * 1000.0 * 1000.0)
 	p.dlLat.Reset()
-	p.dlLat.WithLabelValues(s.ClientIP, s.ServerIP).Set(s.Download.Latency.Value / 1000.0)
 	p.ulTp.Reset()
-	p.ulTp.WithLabelValues(s.ClientIP, s.ServerIP).Set(s.Upload.Throughput.Value * 1000.0 * 1000.0)
 	p.ulLat.Reset()
-	p.ulLat.WithLabelValues(s.ClientIP, s.ServerIP).Set(s.Upload.Latency.Value / 1000.0)
+	p.clientLimited.Reset()
+	// When comparing address families, the client and server IPs of
+	// each family label the respective values.
+	for _, fs := range []*Summary{s, s.IPv4, s.IPv6} {
+		if fs != nil {
+			p.set(fs)
+		}
+	}
 
 	return p.emitter.OnSummary(s)
 }
+
+// set sets the gauges using the download and upload results of s, if any.
+func (p *Prometheus) set(s *Summary) {
+	if s.Download != nil {
+		p.dlTp.WithLabelValues(s.ClientIP, s.ServerIP, s.Interface).Set(s.Download.Throughput.Value * 1000.0 * 1000.0)
+		p.dlLat.WithLabelValues(s.ClientIP, s.ServerIP, s.Interface).Set(s.Download.Latency.Value / 1000.0)
+		p.setClientLimited(s, spec.TestDownload, s.Download)
+	}
+	if s.Upload != nil {
+		p.ulTp.WithLabelValues(s.ClientIP, s.ServerIP, s.Interface).Set(s.Upload.Throughput.Value * 1000.0 * 1000.0)
+		p.ulLat.WithLabelValues(s.ClientIP, s.ServerIP, s.Interface).Set(s.Upload.Latency.Value / 1000.0)
+		p.setClientLimited(s, spec.TestUpload, s.Upload)
+	}
+}
+
+// setClientLimited sets the client limited gauge of the given subtest.
+func (p *Prometheus) setClientLimited(s *Summary, test spec.TestKind, ss *SubtestSummary) {
+	value := 0.0
+	if ss.ClientLimited {
+		value = 1
+	}
+	p.clientLimited.WithLabelValues(s.ClientIP, s.ServerIP, s.Interface, string(test)).Set(value)
+}
@@ -4,7 +4,7 @@ import (
 	"github.com/m-lab/ndt7-client-go/spec"
 )
 
-// Quiet acts as a filter allowing summary and error messages only, and
+// Quiet acts as a filter allowing summary, error and warning messages only, and
 // doesn't perform any formatting.
 // The message is actually emitted by the embedded Emitter.
 type Quiet struct {
@@ -44,6 +44,26 @@ func (q Quiet) OnUploadEvent(m *spec.Measurement) error {
 	return nil
 }
 
+// OnServerSelection handles the results of probing the servers
+func (q Quiet) OnServerSelection(probes []spec.ServerProbe) error {
+	return nil
+}
+
+// OnLatencyEvent handles a sample taken by the latency probe
+func (q Quiet) OnLatencyEvent(m *spec.Measurement) error {
+	return nil
+}
+
+// OnConnectionTiming handles how long connecting to the server took
+func (q Quiet) OnConnectionTiming(test spec.TestKind, timing *spec.ConnectionTiming) error {
+	return nil
+}
+
+// OnWarning emits the warning event
+func (q Quiet) OnWarning(test spec.TestKind, warning string) error {
+	return q.emitter.OnWarning(test, warning)
+}
+
 // OnComplete is the event signalling the end of the test
 func (q Quiet) OnComplete(test spec.TestKind) error {
 	return nil
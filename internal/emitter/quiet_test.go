@@ -41,6 +41,30 @@ func TestQuiet_OnError(t *testing.T) {
 	}
 }
 
+func TestQuiet_OnWarning(t *testing.T) {
+	// Warnings are passed through, like errors.
+	sw := &mocks.FailingWriter{}
+	e := jsonEmitter{sw}
+	quiet := Quiet{e}
+	err := quiet.OnWarning("download", "mocked warning")
+	if err != mocks.ErrMocked {
+		t.Fatal("OnWarning(): unexpected error type or nil")
+	}
+}
+
+func TestQuiet_OnConnectionTiming(t *testing.T) {
+	sw := &mocks.SavingWriter{}
+	e := jsonEmitter{sw}
+	quiet := Quiet{e}
+	err := quiet.OnConnectionTiming("download", &spec.ConnectionTiming{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 0 {
+		t.Fatal("OnConnectionTiming(): unexpected data")
+	}
+}
+
 func TestQuiet_OnConnected(t *testing.T) {
 	sw := &mocks.SavingWriter{}
 	e := jsonEmitter{sw}
@@ -104,3 +128,29 @@ func TestQuiet_OnSummary(t *testing.T) {
 		t.Fatal("OnSummary(): unexpected error type or nil")
 	}
 }
+
+func TestQuiet_OnLatencyEvent(t *testing.T) {
+	sw := &mocks.SavingWriter{}
+	e := jsonEmitter{sw}
+	quiet := Quiet{e}
+	err := quiet.OnLatencyEvent(&spec.Measurement{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 0 {
+		t.Fatal("OnLatencyEvent(): unexpected data")
+	}
+}
+
+func TestQuiet_OnServerSelection(t *testing.T) {
+	sw := &mocks.SavingWriter{}
+	e := jsonEmitter{sw}
+	quiet := Quiet{e}
+	err := quiet.OnServerSelection([]spec.ServerProbe{{Server: "near.example"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sw.Data) != 0 {
+		t.Fatal("OnServerSelection(): unexpected data")
+	}
+}
@@ -8,16 +8,175 @@ type ValueUnitPair struct {
 
 // SubtestSummary contains all the results of a single subtest (download or
 // upload). All the values are from the server's perspective, except for the
-// download throughput.
+// download throughput, the upload retransmission and ClientLatency, which
+// are measured by the client. The client reads TCP_INFO only on Linux, so
+// the upload retransmission and ClientLatency are missing elsewhere.
 type SubtestSummary struct {
 	// UUID is the unique identified of this subtest.
 	UUID string
 	// Throughput is the measured throughput during this subtest.
 	Throughput ValueUnitPair
+	// ThroughputEstimator is the estimator that computed Throughput,
+	// e.g., "mean", which divides the bytes by the duration, or
+	// "steady-state", which excludes the TCP ramp-up phase.
+	ThroughputEstimator string `json:",omitempty"`
 	// Latency is the MinRTT value of the latest measurement, in milliseconds.
 	Latency ValueUnitPair
 	// Retransmission is BytesRetrans / BytesSent from TCPInfo
 	Retransmission ValueUnitPair
+	// ClientLatency is the MinRTT measured by the client, in milliseconds.
+	ClientLatency *ValueUnitPair `json:",omitempty"`
+	// Streams contains the summary of each stream in multi-stream subtests,
+	// in which case the other fields aggregate all the streams and UUID is
+	// empty.
+	Streams []*SubtestSummary `json:",omitempty"`
+	// Degraded indicates that at least one of the Streams failed.
+	Degraded bool `json:",omitempty"`
+	// Failure is the error that caused a stream to fail, if any.
+	Failure string `json:",omitempty"`
+	// BudgetLimited indicates that the subtest stopped because it
+	// transferred the maximum number of bytes allowed by the options.
+	BudgetLimited bool `json:",omitempty"`
+	// ClientCPU is the CPU time used by the client process divided by the
+	// duration of the subtest, in percent of one CPU, if available.
+	ClientCPU *ValueUnitPair `json:",omitempty"`
+	// ClientLimited indicates that the client process used most of the
+	// CPUs available to the subtest, i.e., one per stream, in which case
+	// the client, rather than the network, likely limited the throughput.
+	ClientLimited bool `json:",omitempty"`
+	// Converged indicates that the subtest stopped because its throughput
+	// converged. When the options enable convergence and it is false, the
+	// subtest ran until its duration expired, unless BudgetLimited.
+	Converged bool `json:",omitempty"`
+	// Responsiveness contains the latency measured by the latency probe
+	// before and during this subtest, if enabled.
+	Responsiveness *ResponsivenessSummary `json:",omitempty"`
+	// ThroughputSeries contains the throughput measured during each
+	// update interval of this subtest.
+	ThroughputSeries *ThroughputSeries `json:",omitempty"`
+	// ConnectionTiming contains how long connecting to the server took.
+	ConnectionTiming *ConnectionTimingSummary `json:",omitempty"`
+}
+
+// ConnectionTimingSummary contains how long each phase of connecting to the
+// server took, in milliseconds. The phases that did not occur, e.g., the
+// TLS handshake when using ws, are missing.
+type ConnectionTimingSummary struct {
+	// Locate is the duration of the query to the Locate API.
+	Locate *ValueUnitPair `json:",omitempty"`
+	// DNS is the duration of resolving the server name.
+	DNS *ValueUnitPair `json:",omitempty"`
+	// TCPConnect is the duration of the TCP handshake.
+	TCPConnect *ValueUnitPair `json:",omitempty"`
+	// TLSHandshake is the duration of the TLS handshake.
+	TLSHandshake *ValueUnitPair `json:",omitempty"`
+	// WebSocketUpgrade is the duration of the WebSocket handshake.
+	WebSocketUpgrade *ValueUnitPair `json:",omitempty"`
+	// Total is the time elapsed until we connected, including the phases
+	// above and trying other servers after a failure.
+	Total ValueUnitPair
+}
+
+// ThroughputSample is the throughput measured during an update interval.
+type ThroughputSample struct {
+	// ElapsedTime is the time elapsed since the beginning of the subtest
+	// at the end of the interval, in seconds.
+	ElapsedTime float64
+	// Throughput is the throughput during the interval in Mbit/s.
+	Throughput float64
+}
+
+// ThroughputSeries contains the throughput measured during each update
+// interval according to the client and to the server measurements.
+type ThroughputSeries struct {
+	// Client is computed from the bytes counted by the application.
+	Client []ThroughputSample `json:",omitempty"`
+	// Server is computed from the server TCPInfo, i.e., the bytes acked
+	// by the client, for the download, and the bytes received by the
+	// server, for the upload.
+	Server []ThroughputSample `json:",omitempty"`
+}
+
+// LatencySummary contains the percentiles of the latency samples taken by
+// the latency probe, in milliseconds.
+type LatencySummary struct {
+	// Samples is the number of samples.
+	Samples int
+	// P50 is the median latency.
+	P50 ValueUnitPair
+	// P90 is the 90th percentile of the latency.
+	P90 ValueUnitPair
+	// P99 is the 99th percentile of the latency.
+	P99 ValueUnitPair
+}
+
+// ResponsivenessSummary compares the latency before a subtest (idle) with
+// the latency while the subtest is saturating the link (loaded). A large
+// difference indicates bufferbloat.
+type ResponsivenessSummary struct {
+	// Idle summarizes the samples taken before the subtest.
+	Idle *LatencySummary `json:",omitempty"`
+	// Loaded summarizes the samples taken during the subtest.
+	Loaded *LatencySummary `json:",omitempty"`
+	// RPM is the number of round trips per minute under load, computed
+	// from the median loaded latency. The higher, the more responsive.
+	RPM ValueUnitPair
+}
+
+// BidirectionalSummary contains the results of the download and of the
+// upload measured at the same time, i.e., each under the load of the other.
+type BidirectionalSummary struct {
+	// Download is a summary of the download direction.
+	Download *SubtestSummary
+	// Upload is a summary of the upload direction.
+	Upload *SubtestSummary
+}
+
+// ServerProbeSummary contains the result of probing one of the servers
+// returned by the Locate API when choosing the server with the lowest RTT.
+type ServerProbeSummary struct {
+	// Server is the FQDN of the server.
+	Server string
+	// RTT is the lowest RTT, in milliseconds, if we could connect.
+	RTT *ValueUnitPair `json:",omitempty"`
+	// Failure is the error that occurred when probing, if any.
+	Failure string `json:",omitempty"`
+}
+
+// TLSSummary contains the parameters negotiated by the TLS connection with
+// the server.
+type TLSSummary struct {
+	// Version is the TLS version, e.g., "TLS 1.3".
+	Version string
+	// CipherSuite is the name of the cipher suite.
+	CipherSuite string
+	// PeerSubject is the subject of the certificate of the server.
+	PeerSubject string `json:",omitempty"`
+}
+
+// OptionsSummary echoes the options used to run the subtests.
+type OptionsSummary struct {
+	// DownloadDuration is the maximum duration of the download.
+	DownloadDuration ValueUnitPair
+	// UploadDuration is the maximum duration of the upload.
+	UploadDuration ValueUnitPair
+	// IOTimeout is the timeout for I/O operations.
+	IOTimeout ValueUnitPair
+	// UpdateInterval is the interval between client side measurements.
+	UpdateInterval ValueUnitPair
+	// MaxMessageSize is the maximum WebSocket message size.
+	MaxMessageSize ValueUnitPair
+	// ScalingFraction is the upload message scaling fraction.
+	ScalingFraction int64
+	// MaxBytes is the maximum number of bytes transferred by each
+	// subtest, if limited.
+	MaxBytes *ValueUnitPair `json:",omitempty"`
+	// ConvergenceTolerance is the tolerance used to stop the subtests
+	// once their throughput converged, if enabled.
+	ConvergenceTolerance *ValueUnitPair `json:",omitempty"`
+	// ConvergenceMinDuration is the minimum duration of the subtests
+	// stopped because their throughput converged, if enabled.
+	ConvergenceMinDuration *ValueUnitPair `json:",omitempty"`
 }
 
 // Summary is a struct containing the values displayed to the user at
@@ -32,11 +191,46 @@ type Summary struct {
 	// ClientIP is the (v4 or v6) IP address of the client.
 	ClientIP string
 
+	// Interface is the network interface used by the client, if set.
+	Interface string `json:",omitempty"`
+
+	// SourceAddress is the source address used by the client, if set.
+	SourceAddress string `json:",omitempty"`
+
+	// Proxy is the URL of the proxy used to connect to the server, without
+	// its credentials, if any.
+	Proxy string `json:",omitempty"`
+
+	// TLS contains the parameters negotiated with the server, when using
+	// TLS.
+	TLS *TLSSummary `json:",omitempty"`
+
 	// Download is a summary of the download subtest.
 	Download *SubtestSummary
 
 	// Upload is a summary of the upload subtest.
 	Upload *SubtestSummary
+
+	// Bidirectional is a summary of the bidirectional subtest.
+	Bidirectional *BidirectionalSummary `json:",omitempty"`
+
+	// ClientLimited indicates that the client, rather than the network,
+	// likely limited the throughput of any of the subtests.
+	ClientLimited bool `json:",omitempty"`
+
+	// IPv4 and IPv6 contain the summary of the subtests run using each
+	// address family, when comparing them. In such case, Download, Upload
+	// and the IP addresses are empty.
+	IPv4 *Summary `json:",omitempty"`
+	IPv6 *Summary `json:",omitempty"`
+
+	// ServerSelection contains the results of probing the servers, in
+	// the order in which the client tried them, when choosing the server
+	// with the lowest RTT.
+	ServerSelection []*ServerProbeSummary `json:",omitempty"`
+
+	// Options contains the options used to run the subtests.
+	Options *OptionsSummary `json:",omitempty"`
 }
 
 // NewSummary returns a new Summary struct for a given FQDN.
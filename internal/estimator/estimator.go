@@ -0,0 +1,119 @@
+// Package estimator computes the throughput of a subtest from the series
+// of the throughput measured during each update interval. Dividing the
+// total bytes by the total time, i.e., the mean, penalises the paths with
+// a large bandwidth-delay product, where TCP slow start takes seconds to
+// fill the pipe, so the other estimators discard the ramp-up phase or the
+// outliers.
+package estimator
+
+import (
+	"sort"
+
+	"github.com/m-lab/ndt7-client-go/internal/percentile"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// Kind is the kind of estimator.
+type Kind string
+
+const (
+	// Mean is the total number of bytes divided by the total time.
+	Mean = Kind("mean")
+
+	// SteadyState is the mean after the end of the ramp-up phase. We
+	// consider the ramp-up over at the first interval reaching
+	// RampUpFraction of the median throughput of the second half of
+	// the subtest, where we expect the throughput to be steady.
+	SteadyState = Kind("steady-state")
+
+	// TrimmedMean is the mean of the intervals, after discarding
+	// TrimFraction of the slowest and of the fastest intervals.
+	TrimmedMean = Kind("trimmed-mean")
+
+	// P90 is the 90th percentile of the throughput of the intervals.
+	P90 = Kind("p90")
+)
+
+const (
+	// RampUpFraction is the fraction of the steady throughput reached
+	// at the end of the ramp-up phase.
+	RampUpFraction = 0.8
+
+	// TrimFraction is the fraction of the intervals discarded at each
+	// end by TrimmedMean.
+	TrimFraction = 0.1
+
+	// MinSamples is the minimum number of intervals needed to estimate
+	// the steady state throughput.
+	MinSamples = 4
+)
+
+// Estimate returns the throughput in Mbit/s of the given series using the
+// given estimator. It returns false if there are too few samples for the
+// estimator, in which case the caller should fall back to Mean.
+func Estimate(kind Kind, samples []spec.ThroughputSample) (float64, bool) {
+	switch kind {
+	case Mean:
+		return mean(samples)
+	case SteadyState:
+		if len(samples) < MinSamples {
+			return 0, false
+		}
+		return mean(samples[rampUpEnd(samples):])
+	case TrimmedMean:
+		if len(samples) == 0 {
+			return 0, false
+		}
+		sorted := sortedThroughput(samples)
+		trim := int(TrimFraction * float64(len(sorted)))
+		sorted = sorted[trim : len(sorted)-trim]
+		var sum float64
+		for _, v := range sorted {
+			sum += v
+		}
+		return sum / float64(len(sorted)), true
+	case P90:
+		if len(samples) == 0 {
+			return 0, false
+		}
+		sorted := sortedThroughput(samples)
+		return sorted[percentile.Rank(90, len(sorted))], true
+	}
+	return 0, false
+}
+
+// mean returns the mean throughput of samples weighted by the duration
+// of each interval, i.e., the bytes transferred divided by the time.
+func mean(samples []spec.ThroughputSample) (float64, bool) {
+	var bits, elapsed float64
+	for _, sample := range samples {
+		bits += sample.Throughput * float64(sample.Interval)
+		elapsed += float64(sample.Interval)
+	}
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return bits / elapsed, true
+}
+
+// rampUpEnd returns the index of the first sample after the ramp-up.
+func rampUpEnd(samples []spec.ThroughputSample) int {
+	steady := sortedThroughput(samples[len(samples)/2:])
+	threshold := RampUpFraction * steady[percentile.Rank(50, len(steady))]
+	for i, sample := range samples {
+		if sample.Throughput >= threshold {
+			return i
+		}
+	}
+	return 0 // Not reached, since the median is in the series.
+}
+
+// sortedThroughput returns the throughput of samples in ascending order.
+func sortedThroughput(samples []spec.ThroughputSample) []float64 {
+	sorted := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		sorted = append(sorted, sample.Throughput)
+	}
+	sort.Float64s(sorted)
+	return sorted
+}
@@ -0,0 +1,56 @@
+package estimator
+
+import (
+	"math"
+	"testing"
+
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// series returns a series with an interval of 250 ms and the given
+// throughput in each interval.
+func series(values ...float64) []spec.ThroughputSample {
+	var samples []spec.ThroughputSample
+	for i, v := range values {
+		samples = append(samples, spec.ThroughputSample{
+			ElapsedTime: int64(i+1) * 250000,
+			Interval:    250000,
+			Throughput:  v,
+		})
+	}
+	return samples
+}
+
+func TestEstimate(t *testing.T) {
+	// A slow start followed by a steady throughput with an outlier.
+	rampUp := series(1, 2, 4, 8, 16, 50, 90, 100, 100, 100, 110, 100, 100, 90, 100, 100, 100, 100, 100, 300)
+	tests := []struct {
+		kind    Kind
+		samples []spec.ThroughputSample
+		want    float64
+		ok      bool
+	}{
+		{Mean, rampUp, 83.55, true},
+		{SteadyState, rampUp, 113.571429, true},
+		{TrimmedMean, rampUp, 78.625, true},
+		{P90, rampUp, 100, true},
+		{Mean, nil, 0, false},
+		{SteadyState, series(1, 2, 3), 0, false},
+		{TrimmedMean, nil, 0, false},
+		{P90, nil, 0, false},
+		{Kind("nonexistent"), rampUp, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := Estimate(tt.kind, tt.samples)
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("Estimate(%s) = %f, %v; want %f, %v", tt.kind, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEstimateSteadyStateNoRampUp(t *testing.T) {
+	got, ok := Estimate(SteadyState, series(10, 10, 10, 10))
+	if !ok || got != 10 {
+		t.Fatalf("unexpected estimate: %f %v", got, ok)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"time"
 
@@ -45,6 +46,9 @@ type Conn struct {
 
 	// WritePreparedMessageResult is the result returned by conn.WritePreparedMessage
 	WritePreparedMessageResult error
+
+	// WriteControlResult is the result returned by conn.WriteControl
+	WriteControlResult error
 }
 
 // Close closes the mocked connection
@@ -83,6 +87,16 @@ func (c *Conn) WritePreparedMessage(pm *websocket.PreparedMessage) error {
 	return c.WritePreparedMessageResult
 }
 
+// WriteControl writes a control message on the mocked connection
+func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return c.WriteControlResult
+}
+
+// NetConn returns the underlying connection, which is always nil
+func (*Conn) NetConn() net.Conn {
+	return nil
+}
+
 // reponseBody is a fake HTTP response body.
 type reponseBody struct {
 	reader io.Reader
@@ -3,6 +3,9 @@
 package params
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -12,19 +15,23 @@ const SecWebSocketProtocol = "net.measurementlab.ndt.v7"
 // InitialMessageSize is initial size of uploaded messages.
 const InitialMessageSize = 1 << 13
 
-// MaxMessageSize is the maximum accepted message size.
+// MaxMessageSize is the default maximum accepted message size.
 const MaxMessageSize = 1 << 20
 
-// ScalingFraction sets the threshold for scaling binary messages. When
+// MaxAcceptedMessageSize is the largest message size accepted by the
+// server, hence the upper bound of TestOptions.MaxMessageSize.
+const MaxAcceptedMessageSize = 1 << 24
+
+// ScalingFraction sets the default threshold for scaling binary messages. When
 // the current binary message size is <= than 1/scalingFactor of the
 // amount of bytes sent so far, we scale the message. This is documented
 // in the appendix of the ndt7 specification.
 const ScalingFraction = 16
 
-// DownloadTimeout is the time after which the download must stop.
+// DownloadTimeout is the default time after which the download must stop.
 const DownloadTimeout = 15 * time.Second
 
-// IOTimeout is the timeout for I/O operations.
+// IOTimeout is the default timeout for I/O operations.
 const IOTimeout = 7 * time.Second
 
 // DownloadURLPath is the URL path used for the download.
@@ -33,8 +40,158 @@ const DownloadURLPath = "/ndt/v7/download"
 // UploadURLPath is the URL path used for the download.
 const UploadURLPath = "/ndt/v7/upload"
 
-// UploadTimeout is the time after which the upload must stop.
+// UploadTimeout is the default time after which the upload must stop.
 const UploadTimeout = 10 * time.Second
 
-// UpdateInterval is the interval between client side upload measurements.
+// UpdateInterval is the default interval between client side measurements.
 const UpdateInterval = 250 * time.Millisecond
+
+// ProbeIdleSamples is the number of latency samples taken before a test
+// when latency probing is enabled.
+const ProbeIdleSamples = 5
+
+// SelectionSamples is the number of RTT samples taken for each server
+// when selecting the server with the lowest RTT.
+const SelectionSamples = 3
+
+// SelectionTimeout is the timeout of each RTT sample taken when selecting
+// the server with the lowest RTT.
+const SelectionTimeout = 2 * time.Second
+
+// EarlyExitParameterName is the name of the query parameter asking the
+// server to end the download once it has sent as many megabytes as the
+// parameter's value.
+const EarlyExitParameterName = "early_exit"
+
+// EarlyExitValues contains, in increasing order, the megabytes values of
+// the early exit parameter accepted by the server.
+var EarlyExitValues = []int64{250}
+
+// EarlyExit returns the largest value of the early exit parameter that
+// does not exceed maxBytes, or an empty string if there is none.
+func EarlyExit(maxBytes int64) string {
+	value := ""
+	for _, mb := range EarlyExitValues {
+		if mb*1000*1000 <= maxBytes {
+			value = strconv.FormatInt(mb, 10)
+		}
+	}
+	return value
+}
+
+// ConvergenceMinDuration is the default minimum duration of a test ended
+// because its throughput converged.
+const ConvergenceMinDuration = 4 * time.Second
+
+// ConvergenceWindow is the time over which we compute the rolling
+// throughput, and during which it must be stable, to consider it
+// converged.
+const ConvergenceWindow = 2 * time.Second
+
+// ClientLimitedUtilization is the fraction of the CPUs available to a test,
+// i.e., one per stream, used by the client process beyond which we consider
+// the client, rather than the network, the bottleneck.
+const ClientLimitedUtilization = 0.9
+
+// ClientLimitedMinDuration is the minimum duration of a test for telling
+// whether the client was the bottleneck, since the CPU time used by short
+// tests mostly depends on establishing the connection.
+const ClientLimitedMinDuration = time.Second
+
+// ErrInvalidTestOptions is returned when TestOptions fail validation.
+var ErrInvalidTestOptions = errors.New("invalid test options")
+
+// TestOptions contains the tunable parameters of the download and
+// upload tests. Use DefaultTestOptions to obtain the defaults.
+type TestOptions struct {
+	// DownloadDuration is the time after which the download must stop.
+	DownloadDuration time.Duration
+
+	// UploadDuration is the time after which the upload must stop.
+	UploadDuration time.Duration
+
+	// IOTimeout is the timeout for I/O operations.
+	IOTimeout time.Duration
+
+	// UpdateInterval is the interval between client side measurements.
+	UpdateInterval time.Duration
+
+	// MaxMessageSize is the maximum accepted message size when downloading
+	// and the maximum size of the messages we send when uploading.
+	MaxMessageSize int
+
+	// ScalingFraction sets the threshold for scaling upload messages. See
+	// the documentation of the ScalingFraction constant.
+	ScalingFraction int64
+
+	// ProbeInterval is the interval between latency samples taken while
+	// a test is running. Zero, the default, disables latency probing.
+	ProbeInterval time.Duration
+
+	// MaxBytes is the maximum number of bytes received by the download,
+	// or sent by the upload, after which the test stops. With multiple
+	// streams, it is split evenly among them. Zero, the default, means
+	// no limit.
+	MaxBytes int64
+
+	// ConvergenceTolerance enables stopping the test once its throughput
+	// converged, i.e., once the rolling throughput over the latest
+	// ConvergenceWindow stayed within this fraction of its mean for a
+	// whole window, e.g., 0.05 for 5%. Zero, the default, disables it.
+	ConvergenceTolerance float64
+
+	// ConvergenceMinDuration is the minimum duration of a test stopped
+	// because its throughput converged.
+	ConvergenceMinDuration time.Duration
+}
+
+// DefaultTestOptions returns the default TestOptions.
+func DefaultTestOptions() TestOptions {
+	return TestOptions{
+		DownloadDuration: DownloadTimeout,
+		UploadDuration:   UploadTimeout,
+		IOTimeout:        IOTimeout,
+		UpdateInterval:   UpdateInterval,
+		MaxMessageSize:   MaxMessageSize,
+		ScalingFraction:  ScalingFraction,
+
+		ConvergenceMinDuration: ConvergenceMinDuration,
+	}
+}
+
+// Validate returns an error wrapping ErrInvalidTestOptions if any of
+// the options has a value that would not allow to run a test.
+func (o TestOptions) Validate() error {
+	if o.DownloadDuration <= 0 {
+		return fmt.Errorf("%w: download duration must be positive", ErrInvalidTestOptions)
+	}
+	if o.UploadDuration <= 0 {
+		return fmt.Errorf("%w: upload duration must be positive", ErrInvalidTestOptions)
+	}
+	if o.IOTimeout <= 0 {
+		return fmt.Errorf("%w: I/O timeout must be positive", ErrInvalidTestOptions)
+	}
+	if o.UpdateInterval <= 0 {
+		return fmt.Errorf("%w: update interval must be positive", ErrInvalidTestOptions)
+	}
+	if o.MaxMessageSize < InitialMessageSize || o.MaxMessageSize > MaxAcceptedMessageSize {
+		return fmt.Errorf("%w: max message size must be between %d and %d bytes",
+			ErrInvalidTestOptions, InitialMessageSize, MaxAcceptedMessageSize)
+	}
+	if o.ScalingFraction <= 0 {
+		return fmt.Errorf("%w: scaling fraction must be positive", ErrInvalidTestOptions)
+	}
+	if o.ProbeInterval < 0 {
+		return fmt.Errorf("%w: probe interval must not be negative", ErrInvalidTestOptions)
+	}
+	if o.MaxBytes < 0 {
+		return fmt.Errorf("%w: max bytes must not be negative", ErrInvalidTestOptions)
+	}
+	if o.ConvergenceTolerance < 0 || o.ConvergenceTolerance >= 1 {
+		return fmt.Errorf("%w: convergence tolerance must be between 0 and 1", ErrInvalidTestOptions)
+	}
+	if o.ConvergenceMinDuration < 0 {
+		return fmt.Errorf("%w: convergence min duration must not be negative", ErrInvalidTestOptions)
+	}
+	return nil
+}
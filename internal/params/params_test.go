@@ -0,0 +1,79 @@
+package params
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDefaultTestOptionsValid(t *testing.T) {
+	if err := DefaultTestOptions().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTestOptionsValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(o *TestOptions)
+	}{{
+		name:   "download duration",
+		modify: func(o *TestOptions) { o.DownloadDuration = 0 },
+	}, {
+		name:   "upload duration",
+		modify: func(o *TestOptions) { o.UploadDuration = -time.Second },
+	}, {
+		name:   "io timeout",
+		modify: func(o *TestOptions) { o.IOTimeout = 0 },
+	}, {
+		name:   "update interval",
+		modify: func(o *TestOptions) { o.UpdateInterval = 0 },
+	}, {
+		name:   "max message size",
+		modify: func(o *TestOptions) { o.MaxMessageSize = InitialMessageSize - 1 },
+	}, {
+		name:   "max message size too large",
+		modify: func(o *TestOptions) { o.MaxMessageSize = MaxAcceptedMessageSize + 1 },
+	}, {
+		name:   "scaling fraction",
+		modify: func(o *TestOptions) { o.ScalingFraction = 0 },
+	}, {
+		name:   "probe interval",
+		modify: func(o *TestOptions) { o.ProbeInterval = -1 },
+	}, {
+		name:   "max bytes",
+		modify: func(o *TestOptions) { o.MaxBytes = -1 },
+	}, {
+		name:   "convergence tolerance",
+		modify: func(o *TestOptions) { o.ConvergenceTolerance = 1 },
+	}, {
+		name:   "convergence min duration",
+		modify: func(o *TestOptions) { o.ConvergenceMinDuration = -1 },
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultTestOptions()
+			tc.modify(&opts)
+			if err := opts.Validate(); !errors.Is(err, ErrInvalidTestOptions) {
+				t.Fatalf("expected ErrInvalidTestOptions, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEarlyExit(t *testing.T) {
+	cases := []struct {
+		maxBytes int64
+		want     string
+	}{
+		{0, ""},
+		{249999999, ""},
+		{250000000, "250"},
+		{1 << 40, "250"},
+	}
+	for _, tc := range cases {
+		if got := EarlyExit(tc.maxBytes); got != tc.want {
+			t.Errorf("EarlyExit(%d) = %q, want %q", tc.maxBytes, got, tc.want)
+		}
+	}
+}
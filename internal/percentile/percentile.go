@@ -0,0 +1,30 @@
+// Package percentile computes percentiles using the nearest-rank method. We
+// use it for both the latency percentiles and the throughput estimators, so
+// that they agree on what a percentile is.
+package percentile
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// Rank returns the index of the pth percentile, with p between 0 and 100,
+// of n sorted samples using the nearest-rank method. The n argument must
+// be positive.
+func Rank(p float64, n int) int {
+	return max(int(math.Ceil(p/100*float64(n))), 1) - 1
+}
+
+// Of returns the pth percentile, with p between 0 and 100, of the given
+// samples using the nearest-rank method. It returns the zero value when
+// there are no samples. It does not modify samples.
+func Of[T cmp.Ordered](samples []T, p float64) T {
+	if len(samples) == 0 {
+		var zero T
+		return zero
+	}
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	return sorted[Rank(p, len(sorted))]
+}
@@ -0,0 +1,54 @@
+package percentile
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOf(t *testing.T) {
+	var samples []time.Duration
+	for i := 10; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	cases := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{50, 5 * time.Millisecond},
+		{90, 9 * time.Millisecond},
+		{99, 10 * time.Millisecond},
+		{100, 10 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		if got := Of(samples, tc.p); got != tc.want {
+			t.Fatalf("Of(%v): got %v, want %v", tc.p, got, tc.want)
+		}
+	}
+	if samples[0] != 10*time.Millisecond {
+		t.Fatal("Of modified the samples")
+	}
+	if Of([]time.Duration(nil), 50) != 0 {
+		t.Fatal("expected zero without samples")
+	}
+}
+
+func TestRank(t *testing.T) {
+	cases := []struct {
+		p    float64
+		n    int
+		want int
+	}{
+		{0, 1, 0},
+		{50, 1, 0},
+		{50, 4, 1},
+		{90, 10, 8},
+		{90, 11, 9},
+		{100, 10, 9},
+	}
+	for _, tc := range cases {
+		if got := Rank(tc.p, tc.n); got != tc.want {
+			t.Fatalf("Rank(%v, %d): got %d, want %d", tc.p, tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Package probe contains a lightweight latency probe. The probe measures
+// the round-trip time as the time it takes to establish a TCP connection
+// with the server, which requires a single round trip and does not load
+// the network. Comparing the samples taken before a test (idle) with the
+// ones taken while the test saturates the link (loaded) shows how much
+// latency increases under load, i.e., bufferbloat.
+package probe
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DialFunc is the type of the function used to establish connections.
+type DialFunc = func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Once returns the time it takes to establish a TCP connection with the
+// given address using dial. The connection attempt fails after timeout.
+func Once(ctx context.Context, dial DialFunc, address string, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	begin := time.Now()
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(begin)
+	conn.Close()
+	return rtt, nil
+}
+
+// Run takes a sample every interval, until the context is done, and
+// emits it on ch. Failed samples are not emitted. Run closes ch when
+// it returns.
+func Run(ctx context.Context, dial DialFunc, address string, interval,
+	timeout time.Duration, ch chan<- time.Duration) {
+	defer close(ch)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		rtt, err := Once(ctx, dial, address, timeout)
+		if err != nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case ch <- rtt:
+		}
+	}
+}
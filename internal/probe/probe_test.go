@@ -0,0 +1,66 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOnce(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	dialer := &net.Dialer{}
+	rtt, err := Once(context.Background(), dialer.DialContext, ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt <= 0 {
+		t.Fatal("expected a positive RTT")
+	}
+}
+
+func TestOnceError(t *testing.T) {
+	mockedErr := errors.New("mocked error")
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, mockedErr
+	}
+	_, err := Once(context.Background(), dial, "127.0.0.1:1", time.Second)
+	if err != mockedErr {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestRun(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	failed := false
+	dialer := &net.Dialer{}
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		// Make sure that a failing sample does not stop the probe.
+		if !failed {
+			failed = true
+			return nil, errors.New("mocked error")
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan time.Duration)
+	go Run(ctx, dial, ln.Addr().String(), time.Millisecond, time.Second, ch)
+	for i := 0; i < 3; i++ {
+		if rtt := <-ch; rtt <= 0 {
+			t.Fatal("expected a positive RTT")
+		}
+	}
+	cancel()
+	for range ch {
+		// Drain until Run closes the channel.
+	}
+}
@@ -2,20 +2,46 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"time"
 
 	"github.com/m-lab/go/memoryless"
+	v2 "github.com/m-lab/locate/api/v2"
 	"github.com/m-lab/ndt7-client-go"
 	"github.com/m-lab/ndt7-client-go/internal/emitter"
+	"github.com/m-lab/ndt7-client-go/internal/estimator"
+	"github.com/m-lab/ndt7-client-go/internal/percentile"
 	"github.com/m-lab/ndt7-client-go/spec"
 )
 
 type RunnerOptions struct {
 	Download, Upload bool
-	Timeout          time.Duration
-	ClientFactory    func() *ndt7.Client
+	// Bidirectional runs the download and the upload at the same time,
+	// after the download and the upload subtests, if any.
+	Bidirectional bool
+	// DualStack runs the subtests twice, using IPv4 and then IPv6 with
+	// the same server, and emits a summary comparing the two.
+	DualStack     bool
+	Timeout       time.Duration
+	ClientFactory func() *ndt7.Client
+	// Budget, if not nil, limits the bytes transferred by the subtests.
+	Budget Budget
+	// Estimator computes the throughput in the summary. The zero value
+	// means estimator.Mean.
+	Estimator estimator.Kind
+}
+
+// Budget accounts the bytes transferred by the subtests against a data
+// budget. The runner shrinks the subtests to fit into the bytes left, and
+// skips them when there are too few bytes left, i.e., when the share of
+// each subtest would be less than minSubtestBytes.
+type Budget interface {
+	// Remaining returns the number of bytes left.
+	Remaining() int64
+	// Add accounts n more bytes transferred.
+	Add(n int64) error
 }
 
 type Runner struct {
@@ -41,21 +67,127 @@ func (r Runner) doRunTest(
 	ch, err := start(ctx)
 	if err != nil {
 		r.emitter.OnError(test, err)
-		return fmt.Errorf("Failed to start test %v: %v", test, err)
+		r.emitConnectionTiming(test)
+		return fmt.Errorf("Failed to start test %v: %w", test, err)
 	}
 	err = r.emitter.OnConnected(test, r.client.FQDN)
 	if err != nil {
 		return fmt.Errorf("Failed to emit connection event for test %v: %v", test, err)
 	}
+	err = r.emitConnectionTiming(test)
+	if err != nil {
+		return fmt.Errorf("Failed to emit timing event for test %v: %v", test, err)
+	}
 	for ev := range ch {
-		err = emitEvent(&ev)
+		if ev.Latency != nil {
+			err = r.emitter.OnLatencyEvent(&ev)
+		} else {
+			err = emitEvent(&ev)
+		}
 		if err != nil {
 			return fmt.Errorf("Failed to emit event for test %v: %v", test, err)
 		}
 	}
+	if err := r.emitClientLimited(test); err != nil {
+		return fmt.Errorf("Failed to emit warning event for test %v: %v", test, err)
+	}
+	lm, ok := r.client.Results()[test]
+	// In multi-stream tests, a failing stream only degrades the result,
+	// while we consider the test failed when all the streams failed.
+	if ok && allStreamsFailed(lm) {
+		return fmt.Errorf("All the streams of test %v failed: %w", test, lm.Error)
+	}
+	return r.testError(test)
+}
+
+// testError returns the error that caused the given test to stop, if any.
+// In multi-stream tests, it is only set when all the streams failed.
+func (r Runner) testError(test spec.TestKind) error {
+	results, kinds := r.directions(test)
+	for _, kind := range kinds {
+		lm, ok := results[kind]
+		if !ok || lm.Error == nil {
+			continue
+		}
+		if kind != test {
+			return fmt.Errorf("The %v direction of test %v failed: %w", kind, test, lm.Error)
+		}
+		return fmt.Errorf("Test %v failed: %w", test, lm.Error)
+	}
 	return nil
 }
 
+// directions returns the results of the given test and the directions
+// it measured, which are both the download and the upload in the case of
+// the bidirectional test.
+func (r Runner) directions(test spec.TestKind) (map[spec.TestKind]*ndt7.LatestMeasurements, []spec.TestKind) {
+	if test == spec.TestBidirectional {
+		return r.client.BidirectionalResults(), []spec.TestKind{spec.TestDownload, spec.TestUpload}
+	}
+	return r.client.Results(), []spec.TestKind{test}
+}
+
+// emitConnectionTiming emits how long connecting to the server took for
+// the given test, if we tried to. For the bidirectional test, we emit the
+// timing of each direction, like its measurements.
+func (r Runner) emitConnectionTiming(test spec.TestKind) error {
+	results, kinds := r.directions(test)
+	for _, kind := range kinds {
+		lm, ok := results[kind]
+		if !ok || lm.ConnectionTiming == nil {
+			continue
+		}
+		if err := r.emitter.OnConnectionTiming(kind, lm.ConnectionTiming); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitClientLimited emits a warning for each direction of the given test
+// whose throughput the client likely limited. Both directions of the
+// bidirectional test run at the same time, hence share the client CPU.
+func (r Runner) emitClientLimited(test spec.TestKind) error {
+	results, kinds := r.directions(test)
+	for _, kind := range kinds {
+		lm, ok := results[kind]
+		if !ok || !lm.ClientLimited {
+			continue
+		}
+		streams := max(len(lm.Streams), len(kinds))
+		if err := r.emitter.OnWarning(kind, clientLimitedWarning(lm, streams)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clientLimitedWarning returns the warning emitted when the client likely
+// limited the throughput of the test measured by lm, which ran at the same
+// time as the given number of streams.
+func clientLimitedWarning(lm *ndt7.LatestMeasurements, streams int) string {
+	warning := "the client CPU was likely the bottleneck"
+	if cpu := makeClientCPU(lm.Client.CPUInfo); cpu != nil {
+		warning += fmt.Sprintf(" (the client process used %.0f%s of a CPU", cpu.Value, cpu.Unit)
+		if streams > 1 {
+			warning += fmt.Sprintf(" for %d concurrent streams", streams)
+		}
+		warning += ")"
+	}
+	return warning + ", the network may be faster than measured"
+}
+
+// allStreamsFailed returns whether lm belongs to a multi-stream test
+// where all the streams failed.
+func allStreamsFailed(lm *ndt7.LatestMeasurements) bool {
+	for _, stream := range lm.Streams {
+		if stream.Error == nil {
+			return false
+		}
+	}
+	return len(lm.Streams) > 0
+}
+
 func (r Runner) runTest(
 	ctx context.Context, test spec.TestKind,
 	start func(context.Context) (<-chan spec.Measurement, error),
@@ -72,7 +204,7 @@ func (r Runner) runTest(
 		return fmt.Errorf("Failed to emit completion event for test %v: %v", test, emitErr)
 	}
 	if err != nil {
-		return fmt.Errorf("Failed to run test %v: %v", test, err)
+		return fmt.Errorf("Failed to run test %v: %w", test, err)
 	}
 	return nil
 }
@@ -87,13 +219,56 @@ func (r Runner) runUpload(ctx context.Context) error {
 		r.emitter.OnUploadEvent)
 }
 
+func (r Runner) runBidirectional(ctx context.Context) error {
+	return r.runTest(ctx, spec.TestBidirectional, r.client.StartBidirectional,
+		r.emitBidirectionalEvent)
+}
+
+// emitBidirectionalEvent emits m as a download or upload event depending
+// on the direction it belongs to.
+func (r Runner) emitBidirectionalEvent(m *spec.Measurement) error {
+	if m.Test == spec.TestUpload {
+		return r.emitter.OnUploadEvent(m)
+	}
+	return r.emitter.OnDownloadEvent(m)
+}
+
 func (r Runner) RunTestsOnce() []error {
+	if r.opt.DualStack {
+		return r.runDualStack()
+	}
+	r.client = r.opt.ClientFactory()
+	errs, s := r.runSuite(1)
+	if s != nil {
+		r.emitter.OnSummary(s)
+	}
+	return errs
+}
+
+// errBudgetExhausted indicates that we skipped the subtests because the
+// data budget does not allow running them.
+var errBudgetExhausted = errors.New("data budget exhausted, skipping the tests")
+
+// runSuite runs the subtests enabled by r.opt using r.client and returns
+// the errors that occurred and the summary. With a data budget, we give
+// the subtests an even share of the bytes left for the given number of
+// suites we are going to run, and we return a nil summary when such share
+// is too small, since we did not run any subtest, after emitting why.
+func (r Runner) runSuite(suites int) ([]error, *emitter.Summary) {
+	if r.opt.Budget != nil {
+		if !r.limitBytes(suites) {
+			r.emitSkipped(errBudgetExhausted)
+			return []error{errBudgetExhausted}, nil
+		}
+	}
 	errs := make([]error, 0)
 
 	ctx, cancel := context.WithTimeout(context.Background(), r.opt.Timeout)
 	defer cancel()
 
-	r.client = r.opt.ClientFactory()
+	if err := r.selectServer(ctx); err != nil {
+		errs = append(errs, err)
+	}
 
 	if r.opt.Download {
 		err := r.runDownload(ctx)
@@ -108,12 +283,197 @@ func (r Runner) RunTestsOnce() []error {
 		}
 	}
 
+	if r.opt.Bidirectional {
+		err := r.runBidirectional(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	s := makeSummary(r.client.FQDN, r.client.Results())
-	r.emitter.OnSummary(s)
+	addBidirectionalSummary(s, r.client.BidirectionalResults())
+	estimateSummary(s, r.client.Results(), r.client.BidirectionalResults(), r.opt.Estimator)
+	s.ClientLimited = anyClientLimited(s)
+	s.Interface = r.client.Interface
+	s.SourceAddress = r.client.SourceAddress
+	s.Proxy = r.client.ProxyUsed()
+	s.ServerSelection = makeServerSelectionSummary(r.client.ServerProbes())
+	s.Options = makeOptionsSummary(r.client.TestOptions)
+	if r.opt.Budget != nil {
+		if err := r.accountBytes(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs, s
+}
 
+// emitSkipped emits err as the reason why we did not run each subtest
+// enabled by r.opt.
+func (r Runner) emitSkipped(err error) {
+	if r.opt.Download {
+		r.emitter.OnError(spec.TestDownload, err)
+	}
+	if r.opt.Upload {
+		r.emitter.OnError(spec.TestUpload, err)
+	}
+	if r.opt.Bidirectional {
+		r.emitter.OnError(spec.TestBidirectional, err)
+	}
+}
+
+// runDualStack runs the subtests using IPv4 and then using IPv6 with the
+// same server, and emits a summary comparing the two address families.
+func (r Runner) runDualStack() []error {
+	r.client = r.opt.ClientFactory()
+	r.client.AddressFamily = ndt7.AddressFamilyIPv4
+	errs, s4 := r.runSuite(2)
+	if s4 == nil {
+		return errs
+	}
+	fqdn := r.client.FQDN
+
+	r.client = r.opt.ClientFactory()
+	r.client.AddressFamily = ndt7.AddressFamilyIPv6
+	if fqdn != "" {
+		r.client.Locate = &sameServerLocator{Locator: r.client.Locate, fqdn: fqdn}
+	}
+	errs6, s6 := r.runSuite(1)
+	errs = append(errs, errs6...)
+
+	s := emitter.NewSummary(fqdn)
+	s.Interface = s4.Interface
+	s.SourceAddress = s4.SourceAddress
+	s.Proxy = s4.Proxy
+	s.IPv4 = s4
+	s.IPv6 = s6
+	s.ClientLimited = s4.ClientLimited || (s6 != nil && s6.ClientLimited)
+	s.Options = s4.Options
+	r.emitter.OnSummary(s)
 	return errs
 }
 
+// minSubtestBytes is the smallest share of the budget that allows running
+// a subtest, since measuring fewer bytes is not meaningful.
+const minSubtestBytes = 2 * 1000 * 1000
+
+// budgetHeadroom is the percentage of the bytes left that we do not give
+// to the subtests, since we only account the application level bytes (see
+// accountBytes), while the link also carries the WebSocket, TLS, TCP and
+// IP overhead, the retransmissions and the measurement messages, and the
+// subtests may transfer a few more bytes than their share before stopping.
+const budgetHeadroom = 10
+
+// subtests returns the number of directions measured by the subtests
+// enabled by r.opt, in which the bidirectional subtest counts twice.
+func (r Runner) subtests() int64 {
+	var n int64
+	if r.opt.Download {
+		n++
+	}
+	if r.opt.Upload {
+		n++
+	}
+	if r.opt.Bidirectional {
+		n += 2
+	}
+	return n
+}
+
+// limitBytes limits the bytes transferred by each subtest of r.client to
+// its share of the budget for the given number of suites, after reserving
+// budgetHeadroom, and returns false when such share is less than
+// minSubtestBytes.
+func (r Runner) limitBytes(suites int) bool {
+	n := r.subtests() * int64(suites)
+	if n == 0 {
+		return true
+	}
+	remaining := r.opt.Budget.Remaining()
+	share := (remaining - remaining*budgetHeadroom/100) / n
+	if share < minSubtestBytes {
+		return false
+	}
+	opts := &r.client.TestOptions
+	if opts.MaxBytes == 0 || opts.MaxBytes > share {
+		opts.MaxBytes = share
+	}
+	return true
+}
+
+// accountBytes adds the bytes transferred by the subtests of r.client to
+// the budget. We only know the bytes of the WebSocket messages received or
+// sent by the client, hence limitBytes reserves budgetHeadroom for the
+// rest of the traffic.
+func (r Runner) accountBytes() error {
+	var total int64
+	for _, results := range []map[spec.TestKind]*ndt7.LatestMeasurements{
+		r.client.Results(), r.client.BidirectionalResults(),
+	} {
+		for _, lm := range results {
+			if lm.Client.AppInfo != nil {
+				total += lm.Client.AppInfo.NumBytes
+			}
+		}
+	}
+	return r.opt.Budget.Add(total)
+}
+
+// errServerNotLocated indicates that Locate did not return the server
+// used for the IPv4 subtests.
+var errServerNotLocated = errors.New("Locate did not return the IPv4 server")
+
+// sameServerLocator is a Locator that only returns the server with the
+// given FQDN, which allows to run the IPv6 subtests with the server used
+// for the IPv4 subtests while still getting fresh access tokens. It is a
+// ndt7.WrappingLocator, so that the client still customizes how the
+// wrapped Locator connects, e.g., using IPv6 or a proxy.
+type sameServerLocator struct {
+	ndt7.Locator
+	fqdn string
+}
+
+// Unwrap implements ndt7.WrappingLocator.
+func (l *sameServerLocator) Unwrap() ndt7.Locator {
+	return l.Locator
+}
+
+// Wrap implements ndt7.WrappingLocator.
+func (l *sameServerLocator) Wrap(inner ndt7.Locator) ndt7.Locator {
+	return &sameServerLocator{Locator: inner, fqdn: l.fqdn}
+}
+
+// Nearest implements ndt7.Locator.
+func (l *sameServerLocator) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	targets, err := l.Locator.Nearest(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		if t.Hostname == l.fqdn || t.Machine == l.fqdn {
+			return []v2.Target{t}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", errServerNotLocated, l.fqdn)
+}
+
+// selectServer probes the servers returned by the Locate API, when the
+// client chooses the server with the lowest RTT, and emits the results.
+// A failure to query the Locate API is not fatal here, since the tests
+// will fail to start and report it.
+func (r Runner) selectServer(ctx context.Context) error {
+	if r.client.ServerSelection != ndt7.SelectLowestRTT {
+		return nil
+	}
+	probes, err := r.client.SelectServer(ctx)
+	if err != nil || len(probes) == 0 {
+		return nil
+	}
+	if err := r.emitter.OnServerSelection(probes); err != nil {
+		return fmt.Errorf("Failed to emit server selection event: %v", err)
+	}
+	return nil
+}
+
 func (r Runner) RunTestsInLoop() {
 	for {
 		// We ignore the return value here since we rely on the emitters
@@ -134,69 +494,61 @@ func makeSummary(FQDN string, results map[spec.TestKind]*ndt7.LatestMeasurements
 
 	// If there is a download result, populate the summary.
 	if dl, ok := results[spec.TestDownload]; ok {
-		s.Download = &emitter.SubtestSummary{}
+		s.Download = makeDownloadSummary(dl)
+		makeStreamsSummary(s.Download, dl.Streams, makeDownloadSummary)
 		if dl.ConnectionInfo != nil {
-			connInfo := dl.ConnectionInfo
-			s.Download.UUID = connInfo.UUID
-			client = connInfo.Client
-			server = connInfo.Server
-		}
-		// Read the throughput at the receiver (i.e. the client).
-		if dl.Client.AppInfo != nil &&
-			dl.Client.AppInfo.ElapsedTime > 0 {
-			appInfo := dl.Client.AppInfo
-			elapsed := float64(appInfo.ElapsedTime) / 1e06
-			s.Download.Throughput = emitter.ValueUnitPair{
-				Value: (8.0 * float64(appInfo.NumBytes)) /
-					elapsed / (1000.0 * 1000.0),
-				Unit: "Mbit/s",
-			}
+			client = dl.ConnectionInfo.Client
+			server = dl.ConnectionInfo.Server
 		}
-		if dl.Server.TCPInfo != nil {
-			tcpInfo := dl.Server.TCPInfo
-			// Read the retransmission rate at the sender.
-			if tcpInfo.BytesSent > 0 {
-				s.Download.Retransmission = emitter.ValueUnitPair{
-					Value: float64(tcpInfo.BytesRetrans) /
-						float64(tcpInfo.BytesSent) * 100,
-					Unit: "%",
-				}
-			}
-			// Read the latency at the sender.
-			s.Download.Latency = emitter.ValueUnitPair{
-				Value: float64(tcpInfo.MinRTT) / 1000,
-				Unit:  "ms",
-			}
+		if dl.TLS != nil {
+			s.TLS = makeTLSSummary(dl.TLS)
 		}
 	}
 
 	if ul, ok := results[spec.TestUpload]; ok {
-		s.Upload = &emitter.SubtestSummary{}
+		s.Upload = makeUploadSummary(ul)
+		makeStreamsSummary(s.Upload, ul.Streams, makeUploadSummary)
 		if ul.ConnectionInfo != nil {
-			connInfo := ul.ConnectionInfo
-			s.Upload.UUID = connInfo.UUID
-			client = connInfo.Client
-			server = connInfo.Server
-		}
-		if ul.Server.TCPInfo != nil {
-			tcpInfo := ul.Server.TCPInfo
-			// Read the throughput at the receiver (i.e. the server).
-			if tcpInfo.ElapsedTime > 0 {
-				elapsed := float64(tcpInfo.ElapsedTime) / 1e06
-				s.Upload.Throughput = emitter.ValueUnitPair{
-					Value: (8.0 * float64(tcpInfo.BytesReceived)) /
-						elapsed / (1000.0 * 1000.0),
-					Unit: "Mbit/s",
-				}
-			}
-			// Read the latency at the receiver.
-			s.Upload.Latency = emitter.ValueUnitPair{
-				Value: float64(tcpInfo.MinRTT) / 1000,
-				Unit:  "ms",
-			}
+			client = ul.ConnectionInfo.Client
+			server = ul.ConnectionInfo.Server
+		}
+		if ul.TLS != nil {
+			s.TLS = makeTLSSummary(ul.TLS)
 		}
 	}
 
+	setAddresses(s, client, server)
+	return s
+}
+
+// makeTLSSummary returns the summary of the parameters negotiated with
+// the server.
+func makeTLSSummary(info *spec.TLSInfo) *emitter.TLSSummary {
+	return &emitter.TLSSummary{
+		Version:     info.Version,
+		CipherSuite: info.CipherSuite,
+		PeerSubject: info.PeerSubject,
+	}
+}
+
+// anyClientLimited returns whether the client limited any of the subtests
+// summarized by s.
+func anyClientLimited(s *emitter.Summary) bool {
+	subtests := []*emitter.SubtestSummary{s.Download, s.Upload}
+	if s.Bidirectional != nil {
+		subtests = append(subtests, s.Bidirectional.Download, s.Bidirectional.Upload)
+	}
+	for _, ss := range subtests {
+		if ss != nil && ss.ClientLimited {
+			return true
+		}
+	}
+	return false
+}
+
+// setAddresses sets the client and server IPs of s using the given
+// endpoints, which are in the "ip:port" form.
+func setAddresses(s *emitter.Summary, client, server string) {
 	clientIP, _, err := net.SplitHostPort(client)
 	if err == nil {
 		s.ClientIP = clientIP
@@ -205,6 +557,356 @@ func makeSummary(FQDN string, results map[spec.TestKind]*ndt7.LatestMeasurements
 	if err == nil {
 		s.ServerIP = serverIP
 	}
+}
+
+// addBidirectionalSummary adds to s the summary of the bidirectional test
+// using the results of each direction, if we ran such test.
+func addBidirectionalSummary(s *emitter.Summary, results map[spec.TestKind]*ndt7.LatestMeasurements) {
+	dl, okDownload := results[spec.TestDownload]
+	ul, okUpload := results[spec.TestUpload]
+	if !okDownload || !okUpload {
+		return
+	}
+	s.Bidirectional = &emitter.BidirectionalSummary{
+		Download: makeDownloadSummary(dl),
+		Upload:   makeUploadSummary(ul),
+	}
+	// Both directions use the same server, so either one will do.
+	if s.ClientIP == "" && dl.ConnectionInfo != nil {
+		setAddresses(s, dl.ConnectionInfo.Client, dl.ConnectionInfo.Server)
+	}
+	if s.TLS == nil && dl.TLS != nil {
+		s.TLS = makeTLSSummary(dl.TLS)
+	}
+}
+
+// makeStreamsSummary appends the summary of each stream to s, and marks s
+// as degraded if any of the streams failed.
+func makeStreamsSummary(s *emitter.SubtestSummary, streams []*ndt7.LatestMeasurements,
+	makeSubtestSummary func(*ndt7.LatestMeasurements) *emitter.SubtestSummary) {
+	for _, stream := range streams {
+		ss := makeSubtestSummary(stream)
+		if stream.Error != nil {
+			ss.Failure = stream.Error.Error()
+			s.Degraded = true
+		}
+		s.Streams = append(s.Streams, ss)
+	}
+}
+
+func makeDownloadSummary(dl *ndt7.LatestMeasurements) *emitter.SubtestSummary {
+	s := &emitter.SubtestSummary{}
+	if dl.ConnectionInfo != nil {
+		s.UUID = dl.ConnectionInfo.UUID
+	}
+	// Read the throughput at the receiver (i.e. the client).
+	if dl.Client.AppInfo != nil &&
+		dl.Client.AppInfo.ElapsedTime > 0 {
+		appInfo := dl.Client.AppInfo
+		elapsed := float64(appInfo.ElapsedTime) / 1e06
+		s.Throughput = emitter.ValueUnitPair{
+			Value: (8.0 * float64(appInfo.NumBytes)) /
+				elapsed / (1000.0 * 1000.0),
+			Unit: "Mbit/s",
+		}
+		s.ThroughputEstimator = string(estimator.Mean)
+	}
+	if dl.Server.TCPInfo != nil {
+		tcpInfo := dl.Server.TCPInfo
+		// Read the retransmission rate at the sender.
+		if tcpInfo.BytesSent > 0 {
+			s.Retransmission = emitter.ValueUnitPair{
+				Value: float64(tcpInfo.BytesRetrans) /
+					float64(tcpInfo.BytesSent) * 100,
+				Unit: "%",
+			}
+		}
+		// Read the latency at the sender.
+		s.Latency = emitter.ValueUnitPair{
+			Value: float64(tcpInfo.MinRTT) / 1000,
+			Unit:  "ms",
+		}
+	}
+	s.ClientLatency = makeClientLatency(dl.Client.TCPInfo)
+	s.ClientCPU = makeClientCPU(dl.Client.CPUInfo)
+	s.ClientLimited = dl.ClientLimited
+	s.Responsiveness = makeResponsivenessSummary(dl.Latency)
+	s.BudgetLimited = dl.BudgetLimited
+	s.Converged = dl.Converged
+	s.ThroughputSeries = makeThroughputSeries(dl)
+	s.ConnectionTiming = makeConnectionTiming(dl.ConnectionTiming)
+	return s
+}
+
+func makeUploadSummary(ul *ndt7.LatestMeasurements) *emitter.SubtestSummary {
+	s := &emitter.SubtestSummary{}
+	if ul.ConnectionInfo != nil {
+		s.UUID = ul.ConnectionInfo.UUID
+	}
+	if ul.Server.TCPInfo != nil {
+		tcpInfo := ul.Server.TCPInfo
+		// Read the throughput at the receiver (i.e. the server).
+		if tcpInfo.ElapsedTime > 0 {
+			elapsed := float64(tcpInfo.ElapsedTime) / 1e06
+			s.Throughput = emitter.ValueUnitPair{
+				Value: (8.0 * float64(tcpInfo.BytesReceived)) /
+					elapsed / (1000.0 * 1000.0),
+				Unit: "Mbit/s",
+			}
+			s.ThroughputEstimator = string(estimator.Mean)
+		}
+		// Read the latency at the receiver.
+		s.Latency = emitter.ValueUnitPair{
+			Value: float64(tcpInfo.MinRTT) / 1000,
+			Unit:  "ms",
+		}
+	}
+	// Read the retransmission rate at the sender (i.e. the client).
+	if ul.Client.TCPInfo != nil && ul.Client.TCPInfo.BytesSent > 0 {
+		tcpInfo := ul.Client.TCPInfo
+		s.Retransmission = emitter.ValueUnitPair{
+			Value: float64(tcpInfo.BytesRetrans) /
+				float64(tcpInfo.BytesSent) * 100,
+			Unit: "%",
+		}
+	}
+	s.ClientLatency = makeClientLatency(ul.Client.TCPInfo)
+	s.ClientCPU = makeClientCPU(ul.Client.CPUInfo)
+	s.ClientLimited = ul.ClientLimited
+	s.Responsiveness = makeResponsivenessSummary(ul.Latency)
+	s.BudgetLimited = ul.BudgetLimited
+	s.Converged = ul.Converged
+	s.ThroughputSeries = makeThroughputSeries(ul)
+	s.ConnectionTiming = makeConnectionTiming(ul.ConnectionTiming)
+	return s
+}
+
+// estimateSummary replaces the mean throughput of the subtests in s with
+// the estimate computed by kind, using the results of the subtests and
+// of the bidirectional test.
+func estimateSummary(s *emitter.Summary, results, bidirectional map[spec.TestKind]*ndt7.LatestMeasurements,
+	kind estimator.Kind) {
+	if kind == "" || kind == estimator.Mean {
+		return
+	}
+	if dl, ok := results[spec.TestDownload]; ok && s.Download != nil {
+		estimateThroughput(s.Download, dl, downloadSeries, kind)
+	}
+	if ul, ok := results[spec.TestUpload]; ok && s.Upload != nil {
+		estimateThroughput(s.Upload, ul, uploadSeries, kind)
+	}
+	if s.Bidirectional != nil {
+		estimateThroughput(s.Bidirectional.Download, bidirectional[spec.TestDownload], downloadSeries, kind)
+		estimateThroughput(s.Bidirectional.Upload, bidirectional[spec.TestUpload], uploadSeries, kind)
+	}
+}
+
+// estimateThroughput sets the throughput of s, and of its streams, to the
+// estimate computed by kind from the throughput series returned by series.
+// When there are too few samples, the throughput remains the mean.
+func estimateThroughput(s *emitter.SubtestSummary, lm *ndt7.LatestMeasurements,
+	series func(*ndt7.LatestMeasurements) []spec.ThroughputSample, kind estimator.Kind) {
+	if v, ok := estimator.Estimate(kind, series(lm)); ok {
+		s.Throughput = emitter.ValueUnitPair{Value: v, Unit: "Mbit/s"}
+		s.ThroughputEstimator = string(kind)
+	}
+	for i, stream := range lm.Streams {
+		if i < len(s.Streams) {
+			estimateThroughput(s.Streams[i], stream, series, kind)
+		}
+	}
+}
+
+// downloadSeries returns the throughput series measured by the receiver
+// of the download, i.e., the client, like the mean in the summary.
+func downloadSeries(dl *ndt7.LatestMeasurements) []spec.ThroughputSample {
+	return dl.ClientThroughput
+}
+
+// uploadSeries returns the throughput series measured by the receiver of
+// the upload, i.e., the server, like the mean in the summary.
+func uploadSeries(ul *ndt7.LatestMeasurements) []spec.ThroughputSample {
+	return ul.ServerThroughput
+}
+
+// makeThroughputSeries returns the throughput series of lm, or nil if
+// there are no samples.
+func makeThroughputSeries(lm *ndt7.LatestMeasurements) *emitter.ThroughputSeries {
+	if len(lm.ClientThroughput) == 0 && len(lm.ServerThroughput) == 0 {
+		return nil
+	}
+	convert := func(samples []spec.ThroughputSample) []emitter.ThroughputSample {
+		var out []emitter.ThroughputSample
+		for _, sample := range samples {
+			out = append(out, emitter.ThroughputSample{
+				ElapsedTime: float64(sample.ElapsedTime) / 1e06,
+				Throughput:  sample.Throughput,
+			})
+		}
+		return out
+	}
+	return &emitter.ThroughputSeries{
+		Client: convert(lm.ClientThroughput),
+		Server: convert(lm.ServerThroughput),
+	}
+}
+
+// makeConnectionTiming converts the duration of each phase of connecting
+// to the server to milliseconds, omitting the phases that did not occur.
+func makeConnectionTiming(timing *spec.ConnectionTiming) *emitter.ConnectionTimingSummary {
+	if timing == nil {
+		return nil
+	}
+	ms := func(us int64) emitter.ValueUnitPair {
+		return emitter.ValueUnitPair{Value: float64(us) / 1000, Unit: "ms"}
+	}
+	optional := func(us int64) *emitter.ValueUnitPair {
+		if us <= 0 {
+			return nil
+		}
+		v := ms(us)
+		return &v
+	}
+	return &emitter.ConnectionTimingSummary{
+		Locate:           optional(timing.Locate),
+		DNS:              optional(timing.DNS),
+		TCPConnect:       optional(timing.TCPConnect),
+		TLSHandshake:     optional(timing.TLSHandshake),
+		WebSocketUpgrade: optional(timing.WebSocketUpgrade),
+		Total:            ms(timing.Total),
+	}
+}
+
+// makeClientCPU returns the CPU utilization of the client in percent of
+// one CPU, or nil if the CPU time is not available.
+func makeClientCPU(info *spec.CPUInfo) *emitter.ValueUnitPair {
+	if info == nil || info.ElapsedTime <= 0 {
+		return nil
+	}
+	return &emitter.ValueUnitPair{
+		Value: float64(info.CPUTime) / float64(info.ElapsedTime) * 100,
+		Unit:  "%",
+	}
+}
+
+// makeClientLatency returns the MinRTT measured by the client, or nil if
+// the client could not read TCP_INFO.
+func makeClientLatency(tcpInfo *spec.TCPInfo) *emitter.ValueUnitPair {
+	if tcpInfo == nil || tcpInfo.MinRTT == 0 {
+		return nil
+	}
+	return &emitter.ValueUnitPair{
+		Value: float64(tcpInfo.MinRTT) / 1000,
+		Unit:  "ms",
+	}
+}
 
+// makeResponsivenessSummary summarizes the samples taken by the latency
+// probe. It returns nil if there are no samples.
+func makeResponsivenessSummary(samples []spec.LatencyInfo) *emitter.ResponsivenessSummary {
+	var idle, loaded []time.Duration
+	for _, li := range samples {
+		rtt := time.Duration(li.RTT) * time.Microsecond
+		if li.Loaded {
+			loaded = append(loaded, rtt)
+		} else {
+			idle = append(idle, rtt)
+		}
+	}
+	if len(idle) == 0 && len(loaded) == 0 {
+		return nil
+	}
+	s := &emitter.ResponsivenessSummary{
+		Idle:   makeLatencySummary(idle),
+		Loaded: makeLatencySummary(loaded),
+	}
+	if s.Loaded != nil && s.Loaded.P50.Value > 0 {
+		s.RPM = emitter.ValueUnitPair{
+			Value: 60000 / s.Loaded.P50.Value,
+			Unit:  "RPM",
+		}
+	}
+	return s
+}
+
+// makeLatencySummary returns the percentiles of the given samples, or nil
+// if there are no samples.
+func makeLatencySummary(samples []time.Duration) *emitter.LatencySummary {
+	if len(samples) == 0 {
+		return nil
+	}
+	ms := func(p float64) emitter.ValueUnitPair {
+		return emitter.ValueUnitPair{
+			Value: float64(percentile.Of(samples, p)) / float64(time.Millisecond),
+			Unit:  "ms",
+		}
+	}
+	return &emitter.LatencySummary{
+		Samples: len(samples),
+		P50:     ms(50),
+		P90:     ms(90),
+		P99:     ms(99),
+	}
+}
+
+// makeServerSelectionSummary returns the summary of the given server probes.
+func makeServerSelectionSummary(probes []spec.ServerProbe) []*emitter.ServerProbeSummary {
+	var out []*emitter.ServerProbeSummary
+	for _, p := range probes {
+		sp := &emitter.ServerProbeSummary{
+			Server:  p.Server,
+			Failure: p.Failure,
+		}
+		if p.RTT > 0 {
+			sp.RTT = &emitter.ValueUnitPair{
+				Value: float64(p.RTT) / 1000.0,
+				Unit:  "ms",
+			}
+		}
+		out = append(out, sp)
+	}
+	return out
+}
+
+func makeOptionsSummary(opts ndt7.TestOptions) *emitter.OptionsSummary {
+	s := &emitter.OptionsSummary{
+		DownloadDuration: emitter.ValueUnitPair{
+			Value: opts.DownloadDuration.Seconds(),
+			Unit:  "s",
+		},
+		UploadDuration: emitter.ValueUnitPair{
+			Value: opts.UploadDuration.Seconds(),
+			Unit:  "s",
+		},
+		IOTimeout: emitter.ValueUnitPair{
+			Value: opts.IOTimeout.Seconds(),
+			Unit:  "s",
+		},
+		UpdateInterval: emitter.ValueUnitPair{
+			Value: opts.UpdateInterval.Seconds(),
+			Unit:  "s",
+		},
+		MaxMessageSize: emitter.ValueUnitPair{
+			Value: float64(opts.MaxMessageSize),
+			Unit:  "bytes",
+		},
+		ScalingFraction: opts.ScalingFraction,
+	}
+	if opts.MaxBytes > 0 {
+		s.MaxBytes = &emitter.ValueUnitPair{
+			Value: float64(opts.MaxBytes),
+			Unit:  "bytes",
+		}
+	}
+	if opts.ConvergenceTolerance > 0 {
+		s.ConvergenceTolerance = &emitter.ValueUnitPair{
+			Value: opts.ConvergenceTolerance * 100,
+			Unit:  "%",
+		}
+		s.ConvergenceMinDuration = &emitter.ValueUnitPair{
+			Value: opts.ConvergenceMinDuration.Seconds(),
+			Unit:  "s",
+		}
+	}
 	return s
 }
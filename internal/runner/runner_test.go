@@ -5,20 +5,25 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/m-lab/go/memoryless"
 	"github.com/m-lab/go/testingx"
 	"github.com/m-lab/locate/api/locate"
+	v2 "github.com/m-lab/locate/api/v2"
 	"github.com/m-lab/ndt-server/ndt7/ndt7test"
 	"github.com/m-lab/ndt7-client-go"
 	"github.com/m-lab/ndt7-client-go/internal/emitter"
+	"github.com/m-lab/ndt7-client-go/internal/estimator"
 	"github.com/m-lab/ndt7-client-go/internal/mocks"
 	"github.com/m-lab/ndt7-client-go/internal/params"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
 	"github.com/m-lab/ndt7-client-go/spec"
 )
 
@@ -53,6 +58,22 @@ func (mockedEmitter) OnUploadEvent(m *spec.Measurement) error {
 	return nil
 }
 
+func (mockedEmitter) OnServerSelection(probes []spec.ServerProbe) error {
+	return nil
+}
+
+func (mockedEmitter) OnConnectionTiming(test spec.TestKind, timing *spec.ConnectionTiming) error {
+	return nil
+}
+
+func (mockedEmitter) OnWarning(test spec.TestKind, warning string) error {
+	return nil
+}
+
+func (mockedEmitter) OnLatencyEvent(m *spec.Measurement) error {
+	return nil
+}
+
 func (me mockedEmitter) OnComplete(test spec.TestKind) error {
 	return me.CompleteError
 }
@@ -185,8 +206,8 @@ func TestBatchEmitterEventsOrderNormal(t *testing.T) {
 	)
 	testingx.Must(t, err, "failed to run test")
 	numLines := len(writer.Data)
-	if numLines < 4 {
-		t.Fatal("expected at least four lines")
+	if numLines < 5 {
+		t.Fatal("expected at least five lines")
 	}
 	for lineno, data := range writer.Data {
 		var m struct {
@@ -204,6 +225,13 @@ func TestBatchEmitterEventsOrderNormal(t *testing.T) {
 			if m.Key != "connected" {
 				t.Fatal("unexpected second key")
 			}
+		} else if lineno == 2 {
+			if m.Key != "timing" {
+				t.Fatal("unexpected third key")
+			}
+		} else if lineno == numLines-2 && m.Key == "warning" {
+			// The client may be the bottleneck, since the server runs
+			// in the same process and uses its CPU time.
 		} else if lineno < numLines-1 {
 			if m.Key != "measurement" {
 				t.Fatalf("expected measurement key at line: %d; found %s",
@@ -241,8 +269,8 @@ func TestBatchEmitterEventsOrderFailure(t *testing.T) {
 		t.Fatal("expected error here")
 	}
 	numLines := len(writer.Data)
-	if numLines != 3 {
-		t.Fatal("expected at exactly three lines")
+	if numLines != 4 {
+		t.Fatal("expected at exactly four lines")
 	}
 	for lineno, data := range writer.Data {
 		var m struct {
@@ -262,9 +290,13 @@ func TestBatchEmitterEventsOrderFailure(t *testing.T) {
 				t.Fatal("unexpected second key")
 			}
 		} else if lineno == 2 {
-			if m.Key != "complete" {
+			if m.Key != "timing" {
 				t.Fatal("unexpected third key")
 			}
+		} else if lineno == 3 {
+			if m.Key != "complete" {
+				t.Fatal("unexpected fourth key")
+			}
 		} else {
 			t.Fatal("invalid index")
 		}
@@ -396,6 +428,7 @@ func TestMakeSummary(t *testing.T) {
 				Value: 800.0,
 				Unit:  "Mbit/s",
 			},
+			ThroughputEstimator: "mean",
 			Latency: emitter.ValueUnitPair{
 				Value: 10.0,
 				Unit:  "ms",
@@ -411,6 +444,7 @@ func TestMakeSummary(t *testing.T) {
 				Value: 8.0,
 				Unit:  "Mbit/s",
 			},
+			ThroughputEstimator: "mean",
 			Latency: emitter.ValueUnitPair{
 				Value: 10.0,
 				Unit:  "ms",
@@ -425,3 +459,852 @@ func TestMakeSummary(t *testing.T) {
 		t.Fatal("makeSummary(): unexpected summary data")
 	}
 }
+
+func TestMakeOptionsSummary(t *testing.T) {
+	opts := ndt7.DefaultTestOptions()
+	opts.DownloadDuration = 30 * time.Second
+	s := makeOptionsSummary(opts)
+	if s.DownloadDuration.Value != 30.0 || s.DownloadDuration.Unit != "s" {
+		t.Fatalf("unexpected download duration: %+v", s.DownloadDuration)
+	}
+	if s.UpdateInterval.Value != 0.25 {
+		t.Fatalf("unexpected update interval: %+v", s.UpdateInterval)
+	}
+	if s.MaxMessageSize.Value != float64(params.MaxMessageSize) {
+		t.Fatalf("unexpected max message size: %+v", s.MaxMessageSize)
+	}
+	if s.ScalingFraction != params.ScalingFraction {
+		t.Fatalf("unexpected scaling fraction: %d", s.ScalingFraction)
+	}
+	if s.MaxBytes != nil {
+		t.Fatalf("unexpected max bytes: %+v", s.MaxBytes)
+	}
+	opts.MaxBytes = 1000
+	if s := makeOptionsSummary(opts); s.MaxBytes == nil || s.MaxBytes.Value != 1000 {
+		t.Fatalf("unexpected max bytes: %+v", s.MaxBytes)
+	}
+	if s.ConvergenceTolerance != nil || s.ConvergenceMinDuration != nil {
+		t.Fatalf("unexpected convergence: %+v %+v", s.ConvergenceTolerance, s.ConvergenceMinDuration)
+	}
+	opts.ConvergenceTolerance = 0.05
+	s = makeOptionsSummary(opts)
+	if s.ConvergenceTolerance == nil || s.ConvergenceTolerance.Value != 5 ||
+		s.ConvergenceMinDuration == nil || s.ConvergenceMinDuration.Value != 4 {
+		t.Fatalf("unexpected convergence: %+v %+v", s.ConvergenceTolerance, s.ConvergenceMinDuration)
+	}
+}
+
+func TestMakeSummaryBudgetLimited(t *testing.T) {
+	results := map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestDownload: {BudgetLimited: true},
+		spec.TestUpload:   {},
+	}
+	s := makeSummary("test", results)
+	if !s.Download.BudgetLimited || s.Upload.BudgetLimited {
+		t.Fatalf("unexpected summary: %+v %+v", s.Download, s.Upload)
+	}
+}
+
+func TestMakeConnectionTiming(t *testing.T) {
+	if makeConnectionTiming(nil) != nil {
+		t.Fatal("expected no timing")
+	}
+	timing := makeConnectionTiming(&spec.ConnectionTiming{
+		Locate: 30000, TCPConnect: 1500, WebSocketUpgrade: 2000, Total: 34000,
+	})
+	if timing.Locate == nil || timing.Locate.Value != 30 || timing.Locate.Unit != "ms" ||
+		timing.TCPConnect == nil || timing.TCPConnect.Value != 1.5 ||
+		timing.Total.Value != 34 {
+		t.Fatalf("unexpected timing: %+v", timing)
+	}
+	if timing.DNS != nil || timing.TLSHandshake != nil {
+		t.Fatalf("expected the phases that did not occur to be nil: %+v", timing)
+	}
+	s := makeSummary("test", map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestDownload: {ConnectionTiming: &spec.ConnectionTiming{Total: 1000}},
+	})
+	if s.Download.ConnectionTiming == nil || s.Download.ConnectionTiming.Total.Value != 1 {
+		t.Fatalf("unexpected download summary: %+v", s.Download)
+	}
+}
+
+func TestMakeSummaryTLS(t *testing.T) {
+	s := makeSummary("test", map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestDownload: {},
+	})
+	if s.TLS != nil {
+		t.Fatalf("expected no TLS summary, got %+v", s.TLS)
+	}
+	s = makeSummary("test", map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestDownload: {},
+		spec.TestUpload: {TLS: &spec.TLSInfo{
+			Version:     "TLS 1.3",
+			CipherSuite: "TLS_AES_128_GCM_SHA256",
+			PeerSubject: "CN=ndt.example",
+		}},
+	})
+	if s.TLS == nil || s.TLS.Version != "TLS 1.3" ||
+		s.TLS.CipherSuite != "TLS_AES_128_GCM_SHA256" || s.TLS.PeerSubject != "CN=ndt.example" {
+		t.Fatalf("unexpected TLS summary: %+v", s.TLS)
+	}
+}
+
+func TestClientLimitedWarning(t *testing.T) {
+	lm := &ndt7.LatestMeasurements{
+		Client: spec.Measurement{
+			CPUInfo: &spec.CPUInfo{ElapsedTime: 2000000, CPUTime: 3800000},
+		},
+	}
+	want := "the client CPU was likely the bottleneck (the client process used 190% of a CPU " +
+		"for 2 concurrent streams), the network may be faster than measured"
+	if got := clientLimitedWarning(lm, 2); got != want {
+		t.Fatalf("unexpected warning: %s", got)
+	}
+	lm.Client.CPUInfo.CPUTime = 1900000
+	want = "the client CPU was likely the bottleneck (the client process used 95% of a CPU), " +
+		"the network may be faster than measured"
+	if got := clientLimitedWarning(lm, 1); got != want {
+		t.Fatalf("unexpected warning: %s", got)
+	}
+}
+
+func TestMakeSummaryClientLimited(t *testing.T) {
+	results := map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestDownload: {},
+		spec.TestUpload: {
+			Client: spec.Measurement{
+				CPUInfo: &spec.CPUInfo{ElapsedTime: 2000000, CPUTime: 1900000},
+			},
+			ClientLimited: true,
+		},
+	}
+	s := makeSummary("test", results)
+	if s.Download.ClientCPU != nil || s.Download.ClientLimited {
+		t.Fatalf("unexpected download summary: %+v", s.Download)
+	}
+	if s.Upload.ClientCPU == nil || s.Upload.ClientCPU.Value != 95 ||
+		s.Upload.ClientCPU.Unit != "%" || !s.Upload.ClientLimited {
+		t.Fatalf("unexpected upload summary: %+v", s.Upload)
+	}
+	if !anyClientLimited(s) {
+		t.Fatal("expected the summary to be client-limited")
+	}
+	s.Upload.ClientLimited = false
+	s.Bidirectional = &emitter.BidirectionalSummary{
+		Download: &emitter.SubtestSummary{ClientLimited: true},
+	}
+	if !anyClientLimited(s) {
+		t.Fatal("expected the bidirectional test to be client-limited")
+	}
+	s.Bidirectional = nil
+	if anyClientLimited(s) {
+		t.Fatal("expected the summary not to be client-limited")
+	}
+}
+
+func TestMakeSummaryThroughputSeries(t *testing.T) {
+	results := map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestDownload: {
+			ClientThroughput: []spec.ThroughputSample{
+				{ElapsedTime: 250000, Interval: 250000, Throughput: 32},
+			},
+			ServerThroughput: []spec.ThroughputSample{
+				{ElapsedTime: 500000, Interval: 500000, Throughput: 16},
+			},
+		},
+		spec.TestUpload: {},
+	}
+	s := makeSummary("test", results)
+	want := &emitter.ThroughputSeries{
+		Client: []emitter.ThroughputSample{{ElapsedTime: 0.25, Throughput: 32}},
+		Server: []emitter.ThroughputSample{{ElapsedTime: 0.5, Throughput: 16}},
+	}
+	if !reflect.DeepEqual(s.Download.ThroughputSeries, want) {
+		t.Fatalf("unexpected download series: %+v", s.Download.ThroughputSeries)
+	}
+	if s.Upload.ThroughputSeries != nil {
+		t.Fatalf("unexpected upload series: %+v", s.Upload.ThroughputSeries)
+	}
+}
+
+func TestEstimateSummary(t *testing.T) {
+	rampUp := []spec.ThroughputSample{
+		{ElapsedTime: 250000, Interval: 250000, Throughput: 10},
+		{ElapsedTime: 500000, Interval: 250000, Throughput: 100},
+		{ElapsedTime: 750000, Interval: 250000, Throughput: 100},
+		{ElapsedTime: 1000000, Interval: 250000, Throughput: 100},
+	}
+	stream := &ndt7.LatestMeasurements{ClientThroughput: rampUp}
+	results := map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestDownload: {
+			Client: spec.Measurement{
+				AppInfo: &spec.AppInfo{NumBytes: 9625000, ElapsedTime: 1000000},
+			},
+			ClientThroughput: rampUp,
+			Streams:          []*ndt7.LatestMeasurements{stream},
+		},
+		spec.TestUpload: {
+			// Too few samples: the upload keeps the mean.
+			ServerThroughput: rampUp[:2],
+		},
+	}
+	s := makeSummary("test", results)
+	if s.Download.Throughput.Value != 77 || s.Download.ThroughputEstimator != "mean" {
+		t.Fatalf("unexpected mean: %+v", s.Download)
+	}
+	estimateSummary(s, results, nil, estimator.SteadyState)
+	if s.Download.Throughput.Value != 100 || s.Download.ThroughputEstimator != "steady-state" {
+		t.Fatalf("unexpected download estimate: %+v", s.Download)
+	}
+	if s.Download.Streams[0].Throughput.Value != 100 {
+		t.Fatalf("unexpected stream estimate: %+v", s.Download.Streams[0])
+	}
+	if s.Upload.ThroughputEstimator != "" {
+		t.Fatalf("unexpected upload estimate: %+v", s.Upload)
+	}
+}
+
+func TestMakeSummaryStreams(t *testing.T) {
+	results := map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestDownload: {
+			Client: spec.Measurement{
+				AppInfo: &spec.AppInfo{
+					NumBytes:    200,
+					ElapsedTime: 1,
+				},
+			},
+			ConnectionInfo: &spec.ConnectionInfo{
+				Client: "127.0.0.1:12345",
+				Server: "127.0.0.2:443",
+			},
+			Streams: []*ndt7.LatestMeasurements{{
+				Client: spec.Measurement{
+					AppInfo: &spec.AppInfo{
+						NumBytes:    100,
+						ElapsedTime: 1,
+					},
+				},
+				ConnectionInfo: &spec.ConnectionInfo{UUID: "first-uuid"},
+			}, {
+				Client: spec.Measurement{
+					AppInfo: &spec.AppInfo{
+						NumBytes:    100,
+						ElapsedTime: 1,
+					},
+				},
+				ConnectionInfo: &spec.ConnectionInfo{UUID: "second-uuid"},
+			}},
+		},
+	}
+	s := makeSummary("test", results)
+	if s.Download.Throughput.Value != 1600.0 {
+		t.Fatalf("unexpected aggregate throughput: %+v", s.Download.Throughput)
+	}
+	if len(s.Download.Streams) != 2 {
+		t.Fatal("expected two streams")
+	}
+	if s.Download.Streams[0].UUID != "first-uuid" ||
+		s.Download.Streams[1].UUID != "second-uuid" {
+		t.Fatal("unexpected per-stream UUIDs")
+	}
+	if s.Download.Streams[1].Throughput.Value != 800.0 {
+		t.Fatalf("unexpected stream throughput: %+v", s.Download.Streams[1].Throughput)
+	}
+	if s.ClientIP != "127.0.0.1" {
+		t.Fatal("unexpected client IP")
+	}
+}
+
+func TestMakeSummaryDegraded(t *testing.T) {
+	results := map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestUpload: {
+			Streams: []*ndt7.LatestMeasurements{
+				{},
+				{Error: errors.New("mocked error")},
+			},
+		},
+	}
+	s := makeSummary("test", results)
+	if !s.Upload.Degraded {
+		t.Fatal("expected a degraded upload")
+	}
+	if s.Upload.Streams[0].Failure != "" || s.Upload.Streams[1].Failure != "mocked error" {
+		t.Fatal("unexpected per-stream failures")
+	}
+}
+
+func TestAllStreamsFailed(t *testing.T) {
+	failed := &ndt7.LatestMeasurements{Error: errors.New("mocked error")}
+	if allStreamsFailed(&ndt7.LatestMeasurements{}) {
+		t.Fatal("a single-stream test has no failed streams")
+	}
+	if allStreamsFailed(&ndt7.LatestMeasurements{
+		Streams: []*ndt7.LatestMeasurements{failed, {}},
+	}) {
+		t.Fatal("a degraded test is not failed")
+	}
+	if !allStreamsFailed(&ndt7.LatestMeasurements{
+		Streams: []*ndt7.LatestMeasurements{failed, failed},
+	}) {
+		t.Fatal("expected all the streams to be failed")
+	}
+}
+
+func TestAddBidirectionalSummary(t *testing.T) {
+	s := makeSummary("test", map[spec.TestKind]*ndt7.LatestMeasurements{})
+	addBidirectionalSummary(s, map[spec.TestKind]*ndt7.LatestMeasurements{})
+	if s.Bidirectional != nil {
+		t.Fatal("did not expect a bidirectional summary")
+	}
+	tcpInfo := &spec.TCPInfo{ElapsedTime: 1000000}
+	tcpInfo.BytesReceived = 1000000
+	results := map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestDownload: {
+			Client: spec.Measurement{
+				AppInfo: &spec.AppInfo{NumBytes: 2000000, ElapsedTime: 1000000},
+			},
+			ConnectionInfo: &spec.ConnectionInfo{
+				Client: "127.0.0.1:1234",
+				Server: "127.0.0.2:443",
+			},
+		},
+		spec.TestUpload: {
+			Server: spec.Measurement{TCPInfo: tcpInfo},
+		},
+	}
+	addBidirectionalSummary(s, results)
+	if s.Bidirectional == nil {
+		t.Fatal("expected a bidirectional summary")
+	}
+	if s.Bidirectional.Download.Throughput.Value != 16.0 {
+		t.Fatalf("unexpected download throughput: %+v", s.Bidirectional.Download.Throughput)
+	}
+	if s.Bidirectional.Upload.Throughput.Value != 8.0 {
+		t.Fatalf("unexpected upload throughput: %+v", s.Bidirectional.Upload.Throughput)
+	}
+	if s.ClientIP != "127.0.0.1" || s.ServerIP != "127.0.0.2" {
+		t.Fatal("unexpected IP addresses")
+	}
+}
+
+type summaryEmitter struct {
+	mockedEmitter
+	tests   map[spec.TestKind]int
+	errors  map[spec.TestKind]error
+	summary *emitter.Summary
+}
+
+func (se *summaryEmitter) OnError(test spec.TestKind, err error) error {
+	if se.errors == nil {
+		se.errors = map[spec.TestKind]error{}
+	}
+	se.errors[test] = err
+	return nil
+}
+
+func (se *summaryEmitter) OnDownloadEvent(m *spec.Measurement) error {
+	se.tests[m.Test]++
+	return nil
+}
+
+func (se *summaryEmitter) OnUploadEvent(m *spec.Measurement) error {
+	se.tests[m.Test]++
+	return nil
+}
+
+func (se *summaryEmitter) OnSummary(s *emitter.Summary) error {
+	se.summary = s
+	return nil
+}
+
+func TestRunTestsOnceBidirectional(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping test in short mode")
+	}
+	h, fs := ndt7test.NewNDT7Server(t)
+	defer os.RemoveAll(h.DataDir)
+	defer fs.Close()
+	u, err := url.Parse(fs.URL)
+	testingx.Must(t, err, "failed to parse ndt7test server url")
+
+	e := &summaryEmitter{tests: map[spec.TestKind]int{}}
+	runner := New(RunnerOptions{
+		Bidirectional: true,
+		Timeout:       55 * time.Second,
+		ClientFactory: func() *ndt7.Client {
+			client := ndt7.NewClient(ClientName, ClientVersion)
+			client.Server = u.Host
+			client.Scheme = "ws"
+			return client
+		},
+	}, e, nil)
+	if errs := runner.RunTestsOnce(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if e.tests[spec.TestDownload] == 0 || e.tests[spec.TestUpload] == 0 {
+		t.Fatalf("expected events for both directions: %+v", e.tests)
+	}
+	s := e.summary
+	if s.Download != nil || s.Upload != nil {
+		t.Fatal("did not expect download and upload summaries")
+	}
+	if s.Bidirectional == nil || s.Bidirectional.Download.UUID == "" ||
+		s.Bidirectional.Upload.UUID == "" {
+		t.Fatalf("unexpected bidirectional summary: %+v", s.Bidirectional)
+	}
+	if s.ClientIP == "" {
+		t.Fatal("missing client IP")
+	}
+}
+
+func TestMakeResponsivenessSummary(t *testing.T) {
+	if makeResponsivenessSummary(nil) != nil {
+		t.Fatal("expected nil without samples")
+	}
+	samples := []spec.LatencyInfo{{RTT: 10000}, {RTT: 12000}}
+	for i := 1; i <= 10; i++ {
+		samples = append(samples, spec.LatencyInfo{
+			RTT:    int64(i) * 20000,
+			Loaded: true,
+		})
+	}
+	s := makeResponsivenessSummary(samples)
+	if s.Idle.Samples != 2 || s.Idle.P50.Value != 10.0 || s.Idle.P99.Value != 12.0 {
+		t.Fatalf("unexpected idle summary: %+v", s.Idle)
+	}
+	if s.Loaded.Samples != 10 || s.Loaded.P50.Value != 100.0 || s.Loaded.P90.Value != 180.0 {
+		t.Fatalf("unexpected loaded summary: %+v", s.Loaded)
+	}
+	if s.RPM.Value != 600.0 || s.RPM.Unit != "RPM" {
+		t.Fatalf("unexpected RPM: %+v", s.RPM)
+	}
+	s = makeResponsivenessSummary(samples[:2])
+	if s.Loaded != nil || s.RPM.Value != 0 {
+		t.Fatal("expected no loaded summary without loaded samples")
+	}
+}
+
+type latencyEmitter struct {
+	mockedEmitter
+	samples int
+}
+
+func (le *latencyEmitter) OnDownloadEvent(m *spec.Measurement) error {
+	if m.Latency != nil {
+		return errors.New("latency sample emitted as a download event")
+	}
+	return nil
+}
+
+func (le *latencyEmitter) OnLatencyEvent(m *spec.Measurement) error {
+	le.samples++
+	return nil
+}
+
+func TestRunTestLatencyEvents(t *testing.T) {
+	e := &latencyEmitter{}
+	runner := Runner{
+		client:  ndt7.NewClient(ClientName, ClientVersion),
+		emitter: e,
+	}
+	err := runner.runTest(
+		context.Background(),
+		spec.TestDownload,
+		func(context.Context) (<-chan spec.Measurement, error) {
+			out := make(chan spec.Measurement, 2)
+			out <- spec.Measurement{Latency: &spec.LatencyInfo{RTT: 1000}}
+			out <- spec.Measurement{AppInfo: &spec.AppInfo{}}
+			close(out)
+			return out, nil
+		},
+		e.OnDownloadEvent,
+	)
+	testingx.Must(t, err, "failed to run test")
+	if e.samples != 1 {
+		t.Fatal("expected exactly one latency event")
+	}
+}
+
+func TestMakeSummaryClientTCPInfo(t *testing.T) {
+	clientTCPInfo := &spec.TCPInfo{}
+	clientTCPInfo.BytesSent = 1000
+	clientTCPInfo.BytesRetrans = 20
+	clientTCPInfo.MinRTT = 5000
+	results := map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestDownload: {
+			Client: spec.Measurement{TCPInfo: clientTCPInfo},
+		},
+		spec.TestUpload: {
+			Client: spec.Measurement{TCPInfo: clientTCPInfo},
+		},
+	}
+	s := makeSummary("test", results)
+	if s.Upload.Retransmission.Value != 2.0 || s.Upload.Retransmission.Unit != "%" {
+		t.Fatalf("unexpected upload retransmission: %+v", s.Upload.Retransmission)
+	}
+	for _, ss := range []*emitter.SubtestSummary{s.Download, s.Upload} {
+		if ss.ClientLatency == nil || ss.ClientLatency.Value != 5.0 {
+			t.Fatalf("unexpected client latency: %+v", ss.ClientLatency)
+		}
+	}
+	// The download retransmission is measured by the server.
+	if s.Download.Retransmission.Unit != "" {
+		t.Fatal("unexpected download retransmission")
+	}
+	s = makeSummary("test", map[spec.TestKind]*ndt7.LatestMeasurements{
+		spec.TestUpload: {},
+	})
+	if s.Upload.ClientLatency != nil || s.Upload.Retransmission.Unit != "" {
+		t.Fatal("expected no client side values without client TCPInfo")
+	}
+}
+
+// selectionEmitter records the server selection events.
+type selectionEmitter struct {
+	mockedEmitter
+	probes []spec.ServerProbe
+	err    error
+}
+
+func (se *selectionEmitter) OnServerSelection(probes []spec.ServerProbe) error {
+	se.probes = probes
+	return se.err
+}
+
+// selectionLocator is a Locator returning two unreachable servers.
+type selectionLocator struct{}
+
+func (selectionLocator) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	var targets []v2.Target
+	for _, host := range []string{"a.example", "b.example"} {
+		targets = append(targets, v2.Target{
+			Machine: host,
+			URLs: map[string]string{
+				"ws:///ndt/v7/download": "ws://" + host + "/ndt/v7/download",
+			},
+		})
+	}
+	return targets, nil
+}
+
+func TestSelectServer(t *testing.T) {
+	newClient := func(selection ndt7.ServerSelection) *ndt7.Client {
+		client := ndt7.NewClient(ClientName, ClientVersion)
+		client.Scheme = "ws"
+		client.ServerSelection = selection
+		client.Locate = selectionLocator{}
+		client.Dialer.NetDialContext = func(
+			ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		}
+		return client
+	}
+	e := &selectionEmitter{}
+	runner := Runner{client: newClient(ndt7.SelectLocateOrder), emitter: e}
+	testingx.Must(t, runner.selectServer(context.Background()), "failed to select server")
+	if e.probes != nil {
+		t.Fatal("expected no server selection event")
+	}
+	runner.client = newClient(ndt7.SelectLowestRTT)
+	testingx.Must(t, runner.selectServer(context.Background()), "failed to select server")
+	if len(e.probes) != 2 || e.probes[0].Server != "a.example" {
+		t.Fatalf("unexpected probes: %+v", e.probes)
+	}
+	e.err = errors.New("mocked error")
+	runner.client = newClient(ndt7.SelectLowestRTT)
+	if runner.selectServer(context.Background()) == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestMakeServerSelectionSummary(t *testing.T) {
+	s := makeServerSelectionSummary([]spec.ServerProbe{{
+		Server: "near.example",
+		RTT:    12500,
+	}, {
+		Server:  "down.example",
+		Failure: "connection refused",
+	}})
+	if len(s) != 2 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+	if s[0].RTT == nil || s[0].RTT.Value != 12.5 || s[0].RTT.Unit != "ms" {
+		t.Fatalf("unexpected RTT: %+v", s[0].RTT)
+	}
+	if s[1].RTT != nil || s[1].Failure != "connection refused" {
+		t.Fatalf("unexpected failure: %+v", s[1])
+	}
+	if makeServerSelectionSummary(nil) != nil {
+		t.Fatal("expected no summary")
+	}
+}
+
+func TestSameServerLocator(t *testing.T) {
+	l := &sameServerLocator{
+		Locator: &targetsLocator{targets: []v2.Target{
+			{Hostname: "a.example"}, {Hostname: "b.example"},
+		}},
+		fqdn: "b.example",
+	}
+	targets, err := l.Nearest(context.Background(), "ndt/ndt7")
+	testingx.Must(t, err, "failed to locate")
+	if len(targets) != 1 || targets[0].Hostname != "b.example" {
+		t.Fatalf("unexpected targets: %+v", targets)
+	}
+	l.fqdn = "c.example"
+	if _, err := l.Nearest(context.Background(), "ndt/ndt7"); !errors.Is(err, errServerNotLocated) {
+		t.Fatalf("not the error we expected: %v", err)
+	}
+	mockedErr := errors.New("mocked error")
+	l.Locator = &targetsLocator{err: mockedErr}
+	if _, err := l.Nearest(context.Background(), "ndt/ndt7"); err != mockedErr {
+		t.Fatalf("not the error we expected: %v", err)
+	}
+}
+
+// targetsLocator is a Locator returning the given targets.
+type targetsLocator struct {
+	targets []v2.Target
+	err     error
+}
+
+func (l *targetsLocator) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	return l.targets, l.err
+}
+
+func TestRunTestsOnceDualStack(t *testing.T) {
+	// The server only listens on 127.0.0.1, thus IPv6 fails.
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	e := &summaryEmitter{tests: map[spec.TestKind]int{}}
+	runner := New(RunnerOptions{
+		Download:  true,
+		DualStack: true,
+		Timeout:   10 * time.Second,
+		ClientFactory: func() *ndt7.Client {
+			client := ndt7.NewClient(ClientName, ClientVersion)
+			client.Server = s.Host()
+			client.Scheme = s.Scheme()
+			client.TestOptions.DownloadDuration = 500 * time.Millisecond
+			return client
+		},
+	}, e, nil)
+	errs := runner.RunTestsOnce()
+	if len(errs) != 1 {
+		t.Fatalf("expected the IPv6 download to fail: %v", errs)
+	}
+	summary := e.summary
+	if summary.IPv4 == nil || summary.IPv6 == nil {
+		t.Fatalf("missing address family summaries: %+v", summary)
+	}
+	if summary.Download != nil || summary.ServerFQDN != "127.0.0.1" {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	if summary.IPv4.Download == nil || summary.IPv4.ClientIP != "127.0.0.1" {
+		t.Fatalf("unexpected IPv4 summary: %+v", summary.IPv4)
+	}
+	if summary.Options == nil {
+		t.Fatal("missing options")
+	}
+}
+
+func TestRunTestsOnceDualStackSameServer(t *testing.T) {
+	// The server only listens on 127.0.0.1, thus IPv6 fails.
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	other := testserver.NewServer(testserver.Config{})
+	defer other.Close()
+	l := testserver.NewLocator(s, other)
+	e := &summaryEmitter{tests: map[spec.TestKind]int{}}
+	runner := New(RunnerOptions{
+		Download:  true,
+		DualStack: true,
+		Timeout:   10 * time.Second,
+		ClientFactory: func() *ndt7.Client {
+			client := ndt7.NewClient(ClientName, ClientVersion)
+			client.Locate = l
+			client.Scheme = s.Scheme()
+			client.TestOptions.DownloadDuration = 500 * time.Millisecond
+			return client
+		},
+	}, e, nil)
+	errs := runner.RunTestsOnce()
+	if len(errs) != 1 || errors.Is(errs[0], errServerNotLocated) {
+		t.Fatalf("expected the IPv6 download to fail connecting to the IPv4 server: %v", errs)
+	}
+	if l.Calls.Load() != 2 {
+		t.Fatalf("expected a Locate query for each address family, got %d", l.Calls.Load())
+	}
+	if e.summary == nil || e.summary.IPv4 == nil || e.summary.IPv4.Download == nil {
+		t.Fatalf("expected the IPv4 download to succeed: %+v", e.summary)
+	}
+}
+
+// dialLocator is a ndt7.DialLocator returning the given target and
+// recording whether we called Nearest or NearestDial.
+type dialLocator struct {
+	target v2.Target
+	mu     sync.Mutex
+	calls  []string
+}
+
+func (l *dialLocator) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, "Nearest")
+	return []v2.Target{l.target}, nil
+}
+
+func (l *dialLocator) NearestDial(ctx context.Context, service string,
+	dial func(ctx context.Context, network, address string) (net.Conn, error)) ([]v2.Target, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, "NearestDial")
+	return []v2.Target{l.target}, nil
+}
+
+func TestRunTestsOnceDualStackLocator(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	l := &dialLocator{target: v2.Target{
+		Machine: "127.0.0.1",
+		URLs: map[string]string{
+			s.Scheme() + ":///ndt/v7/download": s.Scheme() + "://" + s.Host() + "/ndt/v7/download",
+		},
+	}}
+	e := &summaryEmitter{tests: map[spec.TestKind]int{}}
+	runner := New(RunnerOptions{
+		Download:  true,
+		DualStack: true,
+		Timeout:   10 * time.Second,
+		ClientFactory: func() *ndt7.Client {
+			client := ndt7.NewClient(ClientName, ClientVersion)
+			client.Locate = l
+			client.Scheme = s.Scheme()
+			client.TestOptions.DownloadDuration = 500 * time.Millisecond
+			return client
+		},
+	}, e, nil)
+	runner.RunTestsOnce()
+	if e.summary == nil || e.summary.IPv4 == nil || e.summary.IPv4.Download == nil {
+		t.Fatalf("expected the IPv4 download to succeed: %+v", e.summary)
+	}
+	// Both passes must query the locator using the custom dialer, i.e.,
+	// the IPv6 pass must see through the sameServerLocator.
+	if len(l.calls) != 2 || l.calls[0] != "NearestDial" || l.calls[1] != "NearestDial" {
+		t.Fatalf("unexpected locator calls: %v", l.calls)
+	}
+}
+
+func TestRunTestsOnceSourceAddress(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	e := &summaryEmitter{tests: map[spec.TestKind]int{}}
+	runner := New(RunnerOptions{
+		Download: true,
+		Timeout:  10 * time.Second,
+		ClientFactory: func() *ndt7.Client {
+			client := ndt7.NewClient(ClientName, ClientVersion)
+			client.Server = s.Host()
+			client.Scheme = s.Scheme()
+			client.SourceAddress = "127.0.0.1"
+			client.TestOptions.DownloadDuration = 500 * time.Millisecond
+			return client
+		},
+	}, e, nil)
+	if errs := runner.RunTestsOnce(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if e.summary.SourceAddress != "127.0.0.1" || e.summary.Interface != "" {
+		t.Fatalf("unexpected summary: %+v", e.summary)
+	}
+}
+
+// fakeBudget is a Budget with the given number of bytes left.
+type fakeBudget struct {
+	remaining int64
+	added     []int64
+}
+
+func (b *fakeBudget) Remaining() int64 {
+	return b.remaining
+}
+
+func (b *fakeBudget) Add(n int64) error {
+	b.added = append(b.added, n)
+	b.remaining = max(b.remaining-n, 0)
+	return nil
+}
+
+func TestRunTestsOnceBudget(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{Duration: 5 * time.Second})
+	defer s.Close()
+	b := &fakeBudget{remaining: 10 << 20}
+	e := &summaryEmitter{tests: map[spec.TestKind]int{}}
+	runner := New(RunnerOptions{
+		Download: true,
+		Upload:   true,
+		Timeout:  10 * time.Second,
+		Budget:   b,
+		ClientFactory: func() *ndt7.Client {
+			client := ndt7.NewClient(ClientName, ClientVersion)
+			client.Server = s.Host()
+			client.Scheme = s.Scheme()
+			return client
+		},
+	}, e, nil)
+	if errs := runner.RunTestsOnce(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	// Each subtest gets half of the budget, minus the headroom.
+	const share = 9 << 20 / 2
+	if e.summary.Options.MaxBytes == nil || e.summary.Options.MaxBytes.Value != share {
+		t.Fatalf("unexpected options: %+v", e.summary.Options)
+	}
+	if !e.summary.Download.BudgetLimited || !e.summary.Upload.BudgetLimited {
+		t.Fatal("expected the subtests to be budget limited")
+	}
+	if len(b.added) != 1 || b.added[0] < 2*share {
+		t.Fatalf("unexpected bytes accounted: %v", b.added)
+	}
+
+	// Without enough bytes left, we skip the subtests.
+	e.summary = nil
+	errs := runner.RunTestsOnce()
+	if len(errs) != 1 || errs[0] != errBudgetExhausted || e.summary != nil {
+		t.Fatalf("expected the subtests to be skipped: %v", errs)
+	}
+	if len(e.errors) != 2 || e.errors[spec.TestDownload] != errBudgetExhausted ||
+		e.errors[spec.TestUpload] != errBudgetExhausted {
+		t.Fatalf("expected the skipped subtests to be reported: %v", e.errors)
+	}
+	if len(b.added) != 1 {
+		t.Fatalf("unexpected bytes accounted: %v", b.added)
+	}
+}
+
+func TestLimitBytes(t *testing.T) {
+	tests := []struct {
+		opts      RunnerOptions
+		remaining int64
+		suites    int
+		maxBytes  int64
+		want      int64
+		ok        bool
+	}{
+		{RunnerOptions{Download: true}, 100e6, 1, 0, 90e6, true},
+		{RunnerOptions{Download: true, Upload: true}, 100e6, 2, 0, 22.5e6, true},
+		{RunnerOptions{Bidirectional: true}, 100e6, 1, 10e6, 10e6, true},
+		{RunnerOptions{Download: true, Upload: true}, 4e6, 1, 0, 0, false},
+		{RunnerOptions{Download: true}, 0, 1, 0, 0, false},
+		{RunnerOptions{}, 0, 1, 0, 0, true},
+	}
+	for _, tt := range tests {
+		r := Runner{opt: tt.opts, client: ndt7.NewClient(ClientName, ClientVersion)}
+		r.opt.Budget = &fakeBudget{remaining: tt.remaining}
+		r.client.TestOptions.MaxBytes = tt.maxBytes
+		if ok := r.limitBytes(tt.suites); ok != tt.ok || r.client.TestOptions.MaxBytes != tt.want {
+			t.Errorf("%+v: got %v %d", tt, ok, r.client.TestOptions.MaxBytes)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Package tcpinfox reads TCP_INFO from the client side of a connection.
+//
+// Reading TCP_INFO is only supported on Linux. On other systems,
+// GetTCPInfo always fails with ErrNoSupport.
+package tcpinfox
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/m-lab/ndt7-client-go/spec"
+	"github.com/m-lab/tcp-info/tcp"
+)
+
+// ErrNoSupport is returned on systems where we cannot read TCP_INFO.
+var ErrNoSupport = errors.New("TCP_INFO not supported")
+
+// ErrNoTCPConn is returned when the connection is not a TCP connection.
+var ErrNoTCPConn = errors.New("not a TCP connection")
+
+// GetTCPInfo returns the TCP_INFO of the TCP connection underlying conn,
+// which may be wrapped by other connections, e.g., a *tls.Conn.
+func GetTCPInfo(conn net.Conn) (*tcp.LinuxTCPInfo, error) {
+	// Unwrap connections such as *tls.Conn.
+	for {
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapper.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, ErrNoTCPConn
+	}
+	return getTCPInfo(tcpConn)
+}
+
+// Sample returns a TCPInfo measurement containing the TCP_INFO of conn and
+// the given elapsed time, or nil if we cannot read TCP_INFO.
+func Sample(conn net.Conn, elapsed time.Duration) *spec.TCPInfo {
+	if conn == nil {
+		return nil
+	}
+	info, err := GetTCPInfo(conn)
+	if err != nil {
+		return nil
+	}
+	return &spec.TCPInfo{
+		LinuxTCPInfo: *info,
+		ElapsedTime:  elapsed.Microseconds(),
+	}
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package tcpinfox
+
+import (
+	"net"
+	"unsafe"
+
+	"github.com/m-lab/tcp-info/tcp"
+	"golang.org/x/sys/unix"
+)
+
+// getTCPInfo reads TCP_INFO using getsockopt. The layout of LinuxTCPInfo
+// is the one of the kernel's struct tcp_info. Older kernels return fewer
+// bytes, in which case the fields they do not know about are zero, while
+// newer kernels truncate the struct to the size of LinuxTCPInfo.
+func getTCPInfo(conn *net.TCPConn) (*tcp.LinuxTCPInfo, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	info := &tcp.LinuxTCPInfo{}
+	var errno unix.Errno
+	err = rawConn.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(*info))
+		_, _, errno = unix.Syscall6(unix.SYS_GETSOCKOPT, fd,
+			unix.IPPROTO_TCP, unix.TCP_INFO, uintptr(unsafe.Pointer(info)),
+			uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if errno != 0 {
+		return nil, errno
+	}
+	return info, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package tcpinfox
+
+import (
+	"net"
+
+	"github.com/m-lab/tcp-info/tcp"
+)
+
+// getTCPInfo always fails with ErrNoSupport on this system.
+func getTCPInfo(conn *net.TCPConn) (*tcp.LinuxTCPInfo, error) {
+	return nil, ErrNoSupport
+}
@@ -0,0 +1,87 @@
+package tcpinfox
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// dial returns a connected loopback TCP connection.
+func dial(t *testing.T) net.Conn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGetTCPInfo(t *testing.T) {
+	conn := dial(t)
+	info, err := GetTCPInfo(conn)
+	if runtime.GOOS != "linux" {
+		if !errors.Is(err, ErrNoSupport) {
+			t.Fatalf("expected ErrNoSupport, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	const established = 1
+	if info.State != established {
+		t.Fatalf("unexpected state: %d", info.State)
+	}
+	if info.SndMSS == 0 {
+		t.Fatal("expected a nonzero MSS")
+	}
+}
+
+func TestGetTCPInfoUnwrap(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("TCP_INFO is only supported on Linux")
+	}
+	conn := tls.Client(dial(t), &tls.Config{})
+	if _, err := GetTCPInfo(conn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetTCPInfoNoTCPConn(t *testing.T) {
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+	if _, err := GetTCPInfo(conn); err != ErrNoTCPConn {
+		t.Fatalf("expected ErrNoTCPConn, got %v", err)
+	}
+}
+
+func TestSample(t *testing.T) {
+	if Sample(nil, time.Second) != nil {
+		t.Fatal("expected nil without a connection")
+	}
+	info := Sample(dial(t), time.Second)
+	if runtime.GOOS != "linux" {
+		if info != nil {
+			t.Fatal("expected nil on this system")
+		}
+		return
+	}
+	if info == nil || info.ElapsedTime != 1000000 {
+		t.Fatalf("unexpected sample: %+v", info)
+	}
+}
@@ -0,0 +1,143 @@
+// Package trace writes the measurements received during the tests into a
+// trace, which contains a spec.TraceRecord per line, and replays them, so
+// that we can regenerate the output of a previous run without the network.
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// ErrNoRecords indicates that the trace contains no more runs of a test.
+var ErrNoRecords = errors.New("no more recorded runs of the test")
+
+// Writer writes the records of a trace. It implements ndt7.Recorder and
+// it is safe to use from multiple goroutines.
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewWriter returns a Writer writing the trace into w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+// Record writes r. After a failure, Record does nothing and Err returns
+// the error that occurred.
+func (w *Writer) Record(r spec.TraceRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = w.enc.Encode(r)
+	}
+}
+
+// Err returns the first error that occurred while writing, if any.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Read reads all the records of the trace read from r.
+func Read(r io.Reader) ([]spec.TraceRecord, error) {
+	dec := json.NewDecoder(r)
+	var records []spec.TraceRecord
+	for {
+		var record spec.TraceRecord
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid trace record %d: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+}
+
+// ReadFile is like Read but reads the trace from the given file.
+func ReadFile(path string) ([]spec.TraceRecord, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	return Read(fp)
+}
+
+// run identifies the records of a run of a test. We use the start time in
+// nanoseconds, since the decoded times may have different locations.
+type run struct {
+	test    spec.TestKind
+	started int64
+}
+
+// Replayer returns the runs of each test contained in a trace, in the
+// order in which they started. It implements ndt7.Replayer and it is safe
+// to use from multiple goroutines.
+type Replayer struct {
+	mu     sync.Mutex
+	runs   map[spec.TestKind][][]spec.TraceRecord
+	probes []spec.ServerProbe
+}
+
+// NewReplayer returns a Replayer for the given records.
+func NewReplayer(records []spec.TraceRecord) *Replayer {
+	r := &Replayer{runs: make(map[spec.TestKind][][]spec.TraceRecord)}
+	index := make(map[run]int)
+	for _, record := range records {
+		key := run{test: record.Test, started: record.Started.UnixNano()}
+		i, found := index[key]
+		if !found {
+			i = len(r.runs[record.Test])
+			index[key] = i
+			r.runs[record.Test] = append(r.runs[record.Test], nil)
+		}
+		r.runs[record.Test][i] = append(r.runs[record.Test][i], record)
+		if r.probes == nil {
+			r.probes = record.ServerProbes
+		}
+	}
+	return r
+}
+
+// Next returns the records of the next run of the given test, or
+// ErrNoRecords if there is none.
+func (r *Replayer) Next(test spec.TestKind) ([]spec.TraceRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	runs := r.runs[test]
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoRecords, test)
+	}
+	r.runs[test] = runs[1:]
+	return runs[0], nil
+}
+
+// ServerProbes returns the results of probing the servers contained in
+// the first record containing them, if any.
+func (r *Replayer) ServerProbes() []spec.ServerProbe {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]spec.ServerProbe(nil), r.probes...)
+}
+
+// Len returns the number of runs left to replay.
+func (r *Replayer) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int
+	for _, runs := range r.runs {
+		n += len(runs)
+	}
+	return n
+}
@@ -0,0 +1,128 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/ndt7-client-go/internal/mocks"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+func TestWriterRead(t *testing.T) {
+	started := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	records := []spec.TraceRecord{{
+		Time:    started.Add(time.Second),
+		Test:    spec.TestDownload,
+		Started: started,
+		Server:  "ndt.example",
+		Measurement: spec.Measurement{
+			AppInfo: &spec.AppInfo{ElapsedTime: 1000, NumBytes: 100},
+			Origin:  spec.OriginClient,
+			Test:    spec.TestDownload,
+		},
+	}, {
+		Time:    started.Add(2 * time.Second),
+		Test:    spec.TestDownload,
+		Started: started,
+		Measurement: spec.Measurement{
+			Origin: spec.OriginServer,
+			Test:   spec.TestDownload,
+		},
+	}}
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	for _, r := range records {
+		w.Record(r)
+	}
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Fatalf("expected a record per line, got %d lines", lines)
+	}
+	got, err := Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !got[0].Time.Equal(records[0].Time) ||
+		got[0].Server != "ndt.example" || got[0].Measurement.AppInfo.NumBytes != 100 ||
+		got[1].Measurement.Origin != spec.OriginServer {
+		t.Fatalf("unexpected records: %+v", got)
+	}
+}
+
+func TestWriterFailure(t *testing.T) {
+	w := NewWriter(&mocks.FailingWriter{})
+	w.Record(spec.TraceRecord{})
+	w.Record(spec.TraceRecord{})
+	if err := w.Err(); err != mocks.ErrMocked {
+		t.Fatalf("expected the writer error, got %v", err)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	_, err := Read(strings.NewReader("{\"Test\":\"download\"}\n{invalid}\n"))
+	if err == nil || !strings.Contains(err.Error(), "record 2") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	first := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	second := first.Add(time.Minute)
+	record := func(test spec.TestKind, started time.Time, stream int) spec.TraceRecord {
+		return spec.TraceRecord{
+			Test:        test,
+			Started:     started,
+			Measurement: spec.Measurement{Stream: stream},
+		}
+	}
+	r := NewReplayer([]spec.TraceRecord{
+		record(spec.TestDownload, first, 1),
+		record(spec.TestUpload, first, 1),
+		record(spec.TestDownload, first, 2),
+		// The same time in another location is the same run.
+		record(spec.TestUpload, first.In(time.FixedZone("CEST", 7200)), 2),
+		record(spec.TestDownload, second, 3),
+	})
+	records, err := r.Next(spec.TestDownload)
+	if err != nil || len(records) != 2 || records[1].Measurement.Stream != 2 {
+		t.Fatalf("unexpected first download: %+v, %v", records, err)
+	}
+	records, err = r.Next(spec.TestUpload)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("unexpected upload: %+v, %v", records, err)
+	}
+	if r.Len() != 1 {
+		t.Fatalf("expected a download left, got %d runs", r.Len())
+	}
+	records, err = r.Next(spec.TestDownload)
+	if err != nil || len(records) != 1 || records[0].Measurement.Stream != 3 {
+		t.Fatalf("unexpected second download: %+v, %v", records, err)
+	}
+	if r.Len() != 0 {
+		t.Fatalf("expected no runs left, got %d", r.Len())
+	}
+	if _, err := r.Next(spec.TestDownload); !errors.Is(err, ErrNoRecords) {
+		t.Fatalf("expected ErrNoRecords, got %v", err)
+	}
+}
+
+func TestReplayerServerProbes(t *testing.T) {
+	if probes := NewReplayer(nil).ServerProbes(); probes != nil {
+		t.Fatalf("expected no probes, got %+v", probes)
+	}
+	probes := []spec.ServerProbe{{Server: "a.example", RTT: 1000}}
+	r := NewReplayer([]spec.TraceRecord{
+		{Test: spec.TestDownload},
+		{Test: spec.TestDownload, ServerProbes: probes},
+		{Test: spec.TestUpload, ServerProbes: []spec.ServerProbe{{Server: "b.example"}}},
+	})
+	if got := r.ServerProbes(); !reflect.DeepEqual(got, probes) {
+		t.Fatalf("unexpected probes: %+v", got)
+	}
+}
@@ -0,0 +1,76 @@
+package upload
+
+import (
+	"crypto/rand"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+)
+
+// payloadPool contains a pre-generated random payload and the prepared
+// messages built from it. Random bytes are incompressible, so middleboxes
+// compressing the traffic cannot inflate the measured throughput. The
+// pool is shared by all the uploads, i.e., across runs and streams, so
+// that we generate the payload and prepare each message size only once,
+// which matters on slow CPUs that would otherwise not saturate the link.
+type payloadPool struct {
+	mu       sync.Mutex
+	data     []byte
+	messages map[int]*websocket.PreparedMessage
+}
+
+// sharedPool is the pool used by all the uploads.
+var sharedPool = &payloadPool{}
+
+// message returns a prepared binary message with size bytes of payload.
+// The messages whose size is InitialMessageSize times a power of two, i.e.,
+// the ones used while scaling, are cached. The others, e.g., the last
+// message of an upload limited by opts.MaxBytes, are built on demand.
+func (p *payloadPool) message(size int) (*websocket.PreparedMessage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pm, found := p.messages[size]; found {
+		return pm, nil
+	}
+	if err := p.grow(size); err != nil {
+		return nil, err
+	}
+	pm, err := websocket.NewPreparedMessage(websocket.BinaryMessage, p.data[:size])
+	if err != nil {
+		return nil, err
+	}
+	if cacheable(size) {
+		if p.messages == nil {
+			p.messages = make(map[int]*websocket.PreparedMessage)
+		}
+		p.messages[size] = pm
+	}
+	return pm, nil
+}
+
+// grow makes sure the payload contains at least size bytes. The caller
+// must hold p.mu.
+func (p *payloadPool) grow(size int) error {
+	if len(p.data) >= size {
+		return nil
+	}
+	// Generate at least MaxMessageSize bytes at once, so that the default
+	// options only ever generate the payload once.
+	data := make([]byte, max(size, params.MaxMessageSize))
+	copy(data, p.data)
+	if _, err := rand.Read(data[len(p.data):]); err != nil {
+		return err
+	}
+	p.data = data
+	return nil
+}
+
+// cacheable returns whether size is InitialMessageSize times a power of two.
+func cacheable(size int) bool {
+	if size < params.InitialMessageSize || size%params.InitialMessageSize != 0 {
+		return false
+	}
+	n := size / params.InitialMessageSize
+	return n&(n-1) == 0
+}
@@ -0,0 +1,165 @@
+package upload
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+)
+
+func TestPayloadPoolMessage(t *testing.T) {
+	pool := &payloadPool{}
+	first, err := pool.message(params.InitialMessageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.message(params.InitialMessageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("expected the same message for the same scaling size")
+	}
+	if len(pool.data) != params.MaxMessageSize {
+		t.Fatalf("unexpected payload size: %d", len(pool.data))
+	}
+	// Sizes not used while scaling are not cached.
+	if _, err := pool.message(params.InitialMessageSize + 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.messages) != 1 {
+		t.Fatalf("unexpected number of cached messages: %d", len(pool.messages))
+	}
+	// Larger sizes grow the payload and keep the existing bytes.
+	prefix := append([]byte(nil), pool.data[:64]...)
+	if _, err := pool.message(2 * params.MaxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.data) != 2*params.MaxMessageSize || !bytes.Equal(prefix, pool.data[:64]) {
+		t.Fatal("the payload did not grow as expected")
+	}
+}
+
+func TestPayloadIncompressible(t *testing.T) {
+	pool := &payloadPool{}
+	if _, err := pool.message(params.MaxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	w, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(pool.data)
+	w.Close()
+	if compressed.Len() < len(pool.data) {
+		t.Fatalf("the payload is compressible: %d < %d", compressed.Len(), len(pool.data))
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	cases := []struct {
+		size int
+		want bool
+	}{
+		{params.InitialMessageSize, true},
+		{2 * params.InitialMessageSize, true},
+		{params.MaxMessageSize, true},
+		{3 * params.InitialMessageSize, false},
+		{params.InitialMessageSize / 2, false},
+		{params.InitialMessageSize + 1, false},
+	}
+	for _, tc := range cases {
+		if got := cacheable(tc.size); got != tc.want {
+			t.Errorf("cacheable(%d) = %v, want %v", tc.size, got, tc.want)
+		}
+	}
+}
+
+// newLoopbackServer returns a WebSocket server discarding the messages
+// it receives, to measure how fast the client can upload.
+func newLoopbackServer(b *testing.B) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, reader, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+			if _, err := io.Copy(io.Discard, reader); err != nil {
+				return
+			}
+		}
+	}))
+	b.Cleanup(s.Close)
+	return s
+}
+
+// BenchmarkUpload measures the maximum upload rate the client sustains
+// with a loopback server, i.e., when the network is not the bottleneck.
+// Each operation uploads a maximum size message on each stream.
+func BenchmarkUpload(b *testing.B) {
+	for _, streams := range []int{1, 4} {
+		b.Run(fmt.Sprintf("streams=%d", streams), func(b *testing.B) {
+			s := newLoopbackServer(b)
+			url := "ws" + strings.TrimPrefix(s.URL, "http")
+			opts := params.DefaultTestOptions()
+			opts.MaxBytes = int64(b.N) * int64(opts.MaxMessageSize)
+			var conns []*websocket.Conn
+			for range streams {
+				conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer conn.Close()
+				conns = append(conns, conn)
+			}
+			b.SetBytes(int64(streams) * int64(opts.MaxMessageSize))
+			b.ReportAllocs()
+			b.ResetTimer()
+			wg := &sync.WaitGroup{}
+			for _, conn := range conns {
+				wg.Add(1)
+				go func(conn *websocket.Conn) {
+					defer wg.Done()
+					out := make(chan int64)
+					go func() {
+						for range out {
+						}
+					}()
+					if err := upload(context.Background(), conn, out, opts); err != nil {
+						b.Error(err)
+					}
+				}(conn)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// BenchmarkMakePreparedMessage measures the cost of getting the messages
+// used while scaling from the initial to the maximum message size.
+func BenchmarkMakePreparedMessage(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		for size := params.InitialMessageSize; size <= params.MaxMessageSize; size *= 2 {
+			if _, err := makePreparedMessage(size); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
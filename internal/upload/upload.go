@@ -5,49 +5,36 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"math/rand"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt7-client-go/internal/convergence"
+	"github.com/m-lab/ndt7-client-go/internal/cpux"
 	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/internal/tcpinfox"
 	"github.com/m-lab/ndt7-client-go/internal/websocketx"
 	"github.com/m-lab/ndt7-client-go/spec"
 )
 
-// makePreparedMessage generates a prepared message that should be sent
-// over the network for generating network load.
-var makePreparedMessage = func(size int) (*websocket.PreparedMessage, error) {
-	const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	data := make([]byte, size)
-	// This is not the fastest algorithm to generate a random string, yet it
-	// is most likely good enough for our purposes. See [1] for a comprehensive
-	// discussion regarding how to generate a random string in Golang.
-	//
-	// .. [1] https://stackoverflow.com/a/31832326/4354461
-	//
-	// Also, the ndt7 specification does not require us to use this algorithm
-	// and we could send purely random data as well. We're sending textual data
-	// here just because in some debugging cases it's easier to read.
-	for i := range data {
-		data[i] = letterBytes[rand.Intn(len(letterBytes))]
-	}
-	return websocket.NewPreparedMessage(websocket.BinaryMessage, data)
-}
+// makePreparedMessage returns a prepared message that should be sent
+// over the network for generating network load. The messages come from
+// the payload pool shared by all the uploads.
+var makePreparedMessage = sharedPool.message
 
 // errNonTextMessage indicates we've got a non textual message
 var errNonTextMessage = errors.New("Received non textual message")
 
 // readcounterflow reads counter flow message. Errors are reported via errCh.
 func readcounterflow(ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
-	errCh chan<- error) {
-	conn.SetReadLimit(params.MaxMessageSize)
+	errCh chan<- error, opts params.TestOptions) {
+	conn.SetReadLimit(int64(opts.MaxMessageSize))
 	for ctx.Err() == nil {
 		// Implementation note: this guarantees that the websocket engine
 		// is processing messages. Here we're using as timeout the timeout
 		// for the whole upload, so that we know that this goroutine is
 		// active for most of the time we care about, even in the case in
 		// which the server is not sending us any messages.
-		err := conn.SetReadDeadline(time.Now().Add(params.UploadTimeout))
+		err := conn.SetReadDeadline(time.Now().Add(opts.UploadDuration))
 		if err != nil {
 			errCh <- err
 			return
@@ -74,26 +61,36 @@ func readcounterflow(ctx context.Context, conn websocketx.Conn, ch chan<- spec.M
 	errCh <- nil
 }
 
-// emit emits an event during the upload.
-func emit(ch chan<- spec.Measurement, elapsed time.Duration, numBytes int64) {
+// emit emits an event during the upload, marking whether the throughput
+// converged. When possible, the event also includes the client side
+// TCP_INFO of conn and the CPU time measured by meter.
+func emit(ch chan<- spec.Measurement, conn websocketx.Conn, meter *cpux.Meter,
+	elapsed time.Duration, numBytes int64, converged bool) {
 	ch <- spec.Measurement{
 		AppInfo: &spec.AppInfo{
 			ElapsedTime: int64(elapsed) / int64(time.Microsecond),
 			NumBytes:    numBytes,
 		},
-		Test:   spec.TestUpload,
-		Origin: spec.OriginClient,
+		CPUInfo:   meter.Sample(elapsed),
+		Converged: converged,
+		TCPInfo:   tcpinfox.Sample(conn.NetConn(), elapsed),
+		Test:      spec.TestUpload,
+		Origin:    spec.OriginClient,
 	}
 }
 
-// upload runs the upload until the context is done or the upload
-// timeout expires. It uses the provided websocket conn. It wil emit
-// the amount of bytes written on the provided chan. The returned
-// error is mainly useful for testing, as this code is meant to run
-// in its own goroutine setup by the caller.
+// upload runs the upload until the context is done or, if opts.MaxBytes
+// is positive, until it has sent opts.MaxBytes bytes. To stay within such
+// budget, we stop scaling the messages when they would exceed the bytes
+// left, and the last message only contains the bytes left. Note that Run
+// bounds the context to opts.UploadDuration. It uses the provided
+// websocket conn. It will emit the amount of bytes written on the provided
+// chan. The returned error is mainly useful for testing, as this code is
+// meant to run in its own goroutine setup by the caller.
 //
 // Note that upload closes the out channel.
-func upload(ctx context.Context, conn websocketx.Conn, out chan<- int64) error {
+func upload(ctx context.Context, conn websocketx.Conn, out chan<- int64,
+	opts params.TestOptions) error {
 	defer close(out)
 	bulkMessageSize := params.InitialMessageSize
 	preparedMessage, err := makePreparedMessage(bulkMessageSize)
@@ -102,7 +99,20 @@ func upload(ctx context.Context, conn websocketx.Conn, out chan<- int64) error {
 	}
 	var total int64
 	for ctx.Err() == nil {
-		err := conn.SetWriteDeadline(time.Now().Add(params.IOTimeout))
+		if opts.MaxBytes > 0 {
+			left := opts.MaxBytes - total
+			if left <= 0 {
+				return nil
+			}
+			if int64(bulkMessageSize) > left {
+				bulkMessageSize = int(left)
+				preparedMessage, err = makePreparedMessage(bulkMessageSize)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err := conn.SetWriteDeadline(time.Now().Add(opts.IOTimeout))
 		if err != nil {
 			return err
 		}
@@ -113,13 +123,17 @@ func upload(ctx context.Context, conn websocketx.Conn, out chan<- int64) error {
 		// are ignoring the WebSocket overhead et al.
 		total += int64(bulkMessageSize)
 		out <- total
-		if bulkMessageSize >= params.MaxMessageSize {
+		if bulkMessageSize >= opts.MaxMessageSize {
 			continue // No further scaling is required.
 		}
-		if int64(bulkMessageSize) > total/params.ScalingFraction {
+		if int64(bulkMessageSize) > total/opts.ScalingFraction {
 			continue // message size still too big compared to sent data
 		}
-		bulkMessageSize *= 2
+		next := min(2*bulkMessageSize, opts.MaxMessageSize)
+		if opts.MaxBytes > 0 && int64(next) > opts.MaxBytes-total {
+			continue // a bigger message would exceed the budget
+		}
+		bulkMessageSize = next
 		preparedMessage, err = makePreparedMessage(bulkMessageSize)
 		if err != nil {
 			return err
@@ -130,35 +144,69 @@ func upload(ctx context.Context, conn websocketx.Conn, out chan<- int64) error {
 
 // uploadAsync runs the upload and returns a channel where progress is
 // emitted. The channel will be close when done.
-func uploadAsync(ctx context.Context, conn websocketx.Conn) <-chan int64 {
+func uploadAsync(ctx context.Context, conn websocketx.Conn, opts params.TestOptions) <-chan int64 {
 	out := make(chan int64)
-	go upload(ctx, conn, out)
+	go upload(ctx, conn, out, opts)
 	return out
 }
 
-// Run runs the upload test. It runs until the ctx is expired or the
-// upload timeout is expired. It uses the provided conn. It emits on the
-// provided channel upload measurements. The returned error is mainly
-// useful for making this function have the same API of download.Run, for
-// which it makes more sense to return an error.
+// Run runs the upload test. It runs until the ctx is expired, the
+// opts.UploadDuration is expired or, if opts.MaxBytes is positive, we have
+// sent opts.MaxBytes bytes or, if opts.ConvergenceTolerance is positive,
+// the throughput converged. In the latter cases, we emit a final
+// measurement and ask the server to close the connection. It uses the
+// provided conn. It emits on the provided channel upload measurements. The
+// returned error is mainly useful for making this function have the same
+// API of download.Run, for which it makes more sense to return an error.
+//
+// The client measurements also contain the CPU time used by the process
+// since the beginning of the test, where supported.
 //
 // Note that run closes both ch and conn.
-func Run(ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement) error {
+func Run(ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+	opts params.TestOptions) error {
 	defer close(ch)
 	defer conn.Close()
-	ctx, cancel := context.WithTimeout(ctx, params.UploadTimeout)
+	ctx, cancel := context.WithTimeout(ctx, opts.UploadDuration)
 	defer cancel()
 	errCh := make(chan error)
 	defer close(errCh)
-	go readcounterflow(ctx, conn, ch, errCh)
+	go readcounterflow(ctx, conn, ch, errCh, opts)
 	start := time.Now()
+	meter := cpux.NewMeter()
 	prev := start
-	for tot := range uploadAsync(ctx, conn) {
+	detector := &convergence.Detector{
+		Tolerance:   opts.ConvergenceTolerance,
+		MinDuration: opts.ConvergenceMinDuration,
+		Window:      params.ConvergenceWindow,
+	}
+	converged := false
+	for tot := range uploadAsync(ctx, conn, opts) {
+		if converged {
+			continue // Wait for upload to notice the canceled context.
+		}
 		now := time.Now()
-		if now.Sub(prev) > params.UpdateInterval {
-			emit(ch, now.Sub(start), tot)
+		limited := opts.MaxBytes > 0 && tot >= opts.MaxBytes
+		if limited || now.Sub(prev) > opts.UpdateInterval {
+			converged = detector.Add(now.Sub(start), tot)
+			emit(ch, conn, meter, now.Sub(start), tot, converged)
 			prev = now
 		}
+		if converged {
+			// Stop uploading and reading the counterflow messages.
+			cancel()
+		}
+		if limited || converged {
+			// Without the close message, we would wait for the server
+			// to end the upload. If we cannot write it, closing the
+			// connection stops reading the counterflow messages.
+			err := conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(opts.IOTimeout))
+			if err != nil {
+				conn.Close()
+			}
+		}
 	}
 
 	err := <-errCh
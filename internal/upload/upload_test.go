@@ -9,6 +9,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/m-lab/ndt7-client-go/internal/mocks"
+	"github.com/m-lab/ndt7-client-go/internal/params"
 	"github.com/m-lab/ndt7-client-go/spec"
 )
 
@@ -23,7 +24,7 @@ func TestNormal(t *testing.T) {
 		ReadMessageType:  websocket.TextMessage,
 	}
 	go func() {
-		err := Run(ctx, &conn, outch)
+		err := Run(ctx, &conn, outch, params.DefaultTestOptions())
 		if err != nil {
 			t.Errorf("error: %v", err)
 		}
@@ -45,7 +46,7 @@ func TestSetReadDeadlineError(t *testing.T) {
 	}
 	ch := make(chan spec.Measurement, 128)
 	errCh := make(chan error)
-	go readcounterflow(context.Background(), &conn, ch, errCh)
+	go readcounterflow(context.Background(), &conn, ch, errCh, params.DefaultTestOptions())
 	if err := <-errCh; err != mockedErr {
 		t.Fatal("Not the error we expected")
 	}
@@ -59,7 +60,7 @@ func TestReadMessageError(t *testing.T) {
 	ch := make(chan spec.Measurement, 128)
 	errCh := make(chan error)
 	defer close(errCh)
-	go readcounterflow(context.Background(), &conn, ch, errCh)
+	go readcounterflow(context.Background(), &conn, ch, errCh, params.DefaultTestOptions())
 	if err := <-errCh; err != mockedErr {
 		t.Fatal("Not the error we expected")
 	}
@@ -73,7 +74,7 @@ func TestReadNonTextMessageError(t *testing.T) {
 	ch := make(chan spec.Measurement, 128)
 	errCh := make(chan error)
 	defer close(errCh)
-	go readcounterflow(context.Background(), &conn, ch, errCh)
+	go readcounterflow(context.Background(), &conn, ch, errCh, params.DefaultTestOptions())
 	if err := <-errCh; err != errNonTextMessage {
 		t.Fatal("Not the error we expected")
 	}
@@ -87,7 +88,7 @@ func TestReadNonJSONError(t *testing.T) {
 	ch := make(chan spec.Measurement, 128)
 	errCh := make(chan error)
 	defer close(errCh)
-	go readcounterflow(context.Background(), &conn, ch, errCh)
+	go readcounterflow(context.Background(), &conn, ch, errCh, params.DefaultTestOptions())
 	err := <-errCh
 	var syntaxError *json.SyntaxError
 	if !errors.As(err, &syntaxError) {
@@ -111,7 +112,7 @@ func TestReadGoodMessage(t *testing.T) {
 	}()
 	errCh := make(chan error)
 	defer close(errCh)
-	go readcounterflow(ctx, &conn, ch, errCh)
+	go readcounterflow(ctx, &conn, ch, errCh, params.DefaultTestOptions())
 	if err := <-errCh; err != nil {
 		t.Fatal(err)
 	}
@@ -134,7 +135,7 @@ func TestMakePreparedMessageError(t *testing.T) {
 			t.Error("Did not expect messages here")
 		}
 	}()
-	err := upload(ctx, &conn, outch)
+	err := upload(ctx, &conn, outch, params.DefaultTestOptions())
 	makePreparedMessage = savedFunc
 	if err != mockedErr {
 		t.Fatal("Not the error we expected")
@@ -156,7 +157,7 @@ func TestSetWriteDeadlineError(t *testing.T) {
 			t.Error("Did not expect messages here")
 		}
 	}()
-	err := upload(ctx, &conn, outch)
+	err := upload(ctx, &conn, outch, params.DefaultTestOptions())
 	if err != mockedErr {
 		t.Fatal("Not the error we expected")
 	}
@@ -177,8 +178,114 @@ func TestWritePreparedMessageError(t *testing.T) {
 			t.Error("Did not expect messages here")
 		}
 	}()
-	err := upload(ctx, &conn, outch)
+	err := upload(ctx, &conn, outch, params.DefaultTestOptions())
 	if err != mockedErr {
 		t.Fatal("Not the error we expected")
 	}
 }
+
+func TestUploadMaxBytes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	out := make(chan int64)
+	conn := mocks.Conn{}
+	opts := params.DefaultTestOptions()
+	opts.MaxBytes = 3*params.InitialMessageSize + 1000
+	go func() {
+		err := upload(ctx, &conn, out, opts)
+		if err != nil {
+			t.Errorf("error: %v", err)
+		}
+	}()
+	var last int64
+	for tot := range out {
+		last = tot
+	}
+	if ctx.Err() != nil {
+		t.Fatal("the upload did not stop at the budget")
+	}
+	if last != opts.MaxBytes {
+		t.Fatalf("expected %d bytes, got %d", opts.MaxBytes, last)
+	}
+}
+
+func TestUploadMaxMessageSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan int64)
+	conn := mocks.Conn{}
+	opts := params.DefaultTestOptions()
+	opts.MaxMessageSize = 10000
+	go func() {
+		err := upload(ctx, &conn, out, opts)
+		if err != nil {
+			t.Errorf("error: %v", err)
+		}
+	}()
+	var prev, largest int64
+	for i := 0; i < 64; i++ {
+		tot := <-out
+		if size := tot - prev; size > largest {
+			largest = size
+		}
+		prev = tot
+	}
+	cancel()
+	for range out {
+		// drain
+	}
+	if largest != int64(opts.MaxMessageSize) {
+		t.Fatalf("expected messages of at most %d bytes, got %d",
+			opts.MaxMessageSize, largest)
+	}
+}
+
+func TestRunMaxBytes(t *testing.T) {
+	outch := make(chan spec.Measurement)
+	conn := mocks.Conn{
+		ReadMessageResult:  &websocket.CloseError{Code: websocket.CloseNormalClosure},
+		WriteControlResult: errors.New("mocked error"),
+	}
+	opts := params.DefaultTestOptions()
+	opts.MaxBytes = params.InitialMessageSize
+	errch := make(chan error, 1)
+	go func() {
+		errch <- Run(context.Background(), &conn, outch, opts)
+	}()
+	var last spec.Measurement
+	for m := range outch {
+		last = m
+	}
+	<-errch
+	if last.AppInfo == nil || last.AppInfo.NumBytes != opts.MaxBytes {
+		t.Fatalf("expected a final measurement with %d bytes, got %+v",
+			opts.MaxBytes, last)
+	}
+}
+
+func TestRunConvergence(t *testing.T) {
+	outch := make(chan spec.Measurement)
+	conn := mocks.Conn{
+		ReadMessageResult: &websocket.CloseError{Code: websocket.CloseNormalClosure},
+	}
+	opts := params.DefaultTestOptions()
+	opts.UploadDuration = 4 * params.ConvergenceWindow
+	opts.ConvergenceTolerance = 0.5
+	opts.ConvergenceMinDuration = 0
+	errch := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		errch <- Run(context.Background(), &conn, outch, opts)
+	}()
+	var last spec.Measurement
+	for m := range outch {
+		last = m
+	}
+	<-errch
+	if !last.Converged {
+		t.Fatalf("expected the final measurement to be converged, got %+v", last)
+	}
+	if elapsed := time.Since(start); elapsed >= opts.UploadDuration {
+		t.Fatalf("the upload did not stop early: %v", elapsed)
+	}
+}
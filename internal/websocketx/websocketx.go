@@ -3,6 +3,7 @@ package websocketx
 
 import (
 	"io"
+	"net"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -17,4 +18,6 @@ type Conn interface {
 	SetReadDeadline(t time.Time) error
 	SetWriteDeadline(t time.Time) error
 	WritePreparedMessage(pm *websocket.PreparedMessage) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	NetConn() net.Conn
 }
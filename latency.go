@@ -0,0 +1,147 @@
+package ndt7
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/internal/probe"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// idleSamples contains the latency samples taken before a test.
+type idleSamples struct {
+	// address is the address of the server we measured.
+	address string
+
+	// samples contains the samples.
+	samples []spec.LatencyInfo
+}
+
+// probeIdle returns the latency samples of the server that dial is going
+// to try first for the URL path p, taken before starting the test. It
+// returns no samples if latency probing is disabled or fails.
+func (c *Client) probeIdle(ctx context.Context, p string, opts params.TestOptions) idleSamples {
+	if opts.ProbeInterval <= 0 {
+		return idleSamples{}
+	}
+	address, err := c.nextAddress(ctx, p)
+	if err != nil {
+		// Let dial report the error, if any.
+		return idleSamples{}
+	}
+	var samples []spec.LatencyInfo
+	for i := 0; i < params.ProbeIdleSamples; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return idleSamples{address: address, samples: samples}
+			case <-time.After(opts.ProbeInterval):
+			}
+		}
+		rtt, err := probe.Once(ctx, c.netDial(), address, opts.IOTimeout)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, spec.LatencyInfo{RTT: rtt.Microseconds()})
+	}
+	return idleSamples{address: address, samples: samples}
+}
+
+// probeLoaded returns a channel emitting the measurements of the test
+// read from inch and, if latency probing is enabled, the given idle samples
+// followed by loaded samples of the server at URL u, taken every
+// opts.ProbeInterval while the test is running. We discard the idle samples
+// when dial did not use the server we measured. The samples are stored
+// into lm. The returned channel is closed when inch is closed.
+func (c *Client) probeLoaded(ctx context.Context, u string, test spec.TestKind,
+	idle idleSamples, lm *LatestMeasurements, inch <-chan spec.Measurement,
+	opts params.TestOptions) <-chan spec.Measurement {
+	if opts.ProbeInterval <= 0 {
+		return inch
+	}
+	address, err := urlAddress(u)
+	if err != nil {
+		return inch
+	}
+	outch := make(chan spec.Measurement)
+	go func() {
+		defer close(outch)
+		emit := func(li spec.LatencyInfo) {
+			m := spec.Measurement{
+				Latency: &li,
+				Origin:  spec.OriginClient,
+				Test:    test,
+			}
+			c.mu.Lock()
+			lm.update(&m)
+			r := c.traceRecord(lm, &m)
+			c.mu.Unlock()
+			c.record(r)
+			outch <- m
+		}
+		if idle.address == address {
+			for _, li := range idle.samples {
+				emit(li)
+			}
+		}
+		probectx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		samples := make(chan time.Duration)
+		go probe.Run(probectx, c.netDial(), address, opts.ProbeInterval,
+			opts.IOTimeout, samples)
+		for {
+			select {
+			case m, ok := <-inch:
+				if !ok {
+					return
+				}
+				outch <- m
+			case rtt, ok := <-samples:
+				if !ok {
+					// The probe stops when ctx is done, while the test
+					// may still be emitting its last measurements.
+					samples = nil
+					continue
+				}
+				emit(spec.LatencyInfo{RTT: rtt.Microseconds(), Loaded: true})
+			}
+		}
+	}()
+	return outch
+}
+
+// nextAddress returns the address of the server that dial is going to
+// try first for the URL path p.
+func (c *Client) nextAddress(ctx context.Context, p string) (string, error) {
+	customURL, err := c.customURL(p)
+	if err != nil {
+		return "", err
+	}
+	if customURL != nil {
+		return urlAddress(customURL.String())
+	}
+	s, err := c.peekURLFromLocate(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	return urlAddress(s)
+}
+
+// urlAddress returns the host:port address of the given websocket URL.
+func urlAddress(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "ws" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
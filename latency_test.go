@@ -0,0 +1,180 @@
+package ndt7
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/internal/websocketx"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+func TestURLAddress(t *testing.T) {
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"wss://a.example/ndt/v7/download", "a.example:443"},
+		{"ws://a.example/ndt/v7/download", "a.example:80"},
+		{"ws://127.0.0.1:8080/ndt/v7/upload", "127.0.0.1:8080"},
+		{"wss://[::1]/ndt/v7/upload", "[::1]:443"},
+	}
+	for _, tc := range cases {
+		got, err := urlAddress(tc.url)
+		testingx.Must(t, err, "failed to parse URL")
+		if got != tc.want {
+			t.Fatalf("urlAddress(%q): got %q, want %q", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestLatestMeasurementsLatency(t *testing.T) {
+	lm := &LatestMeasurements{}
+	lm.update(&spec.Measurement{
+		AppInfo: &spec.AppInfo{NumBytes: 100},
+		Origin:  spec.OriginClient,
+	})
+	lm.update(&spec.Measurement{
+		Latency: &spec.LatencyInfo{RTT: 1000, Loaded: true},
+		Origin:  spec.OriginClient,
+	})
+	if lm.Client.AppInfo == nil {
+		t.Fatal("a latency sample replaced the client measurement")
+	}
+	if len(lm.Latency) != 1 || lm.Latency[0].RTT != 1000 {
+		t.Fatalf("unexpected latency samples: %+v", lm.Latency)
+	}
+}
+
+func TestProbeLoadedDisabled(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	inch := make(chan spec.Measurement)
+	outch := client.probeLoaded(context.Background(), "ws://127.0.0.1/",
+		spec.TestDownload, idleSamples{}, &LatestMeasurements{}, inch,
+		params.DefaultTestOptions())
+	if outch != (<-chan spec.Measurement)(inch) {
+		t.Fatal("expected the test channel when probing is disabled")
+	}
+}
+
+func TestProbeIdleDiscarded(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testingx.Must(t, err, "failed to listen")
+	defer ln.Close()
+	client := NewClient(clientName, clientVersion)
+	opts := params.DefaultTestOptions()
+	opts.ProbeInterval = time.Hour
+	inch := make(chan spec.Measurement)
+	close(inch)
+	lm := &LatestMeasurements{}
+	idle := idleSamples{
+		address: "127.0.0.2:80",
+		samples: []spec.LatencyInfo{{RTT: 1000}},
+	}
+	outch := client.probeLoaded(context.Background(), "ws://"+ln.Addr().String(),
+		spec.TestDownload, idle, lm, inch, opts)
+	for range outch {
+		t.Fatal("did not expect the samples of another server")
+	}
+	if len(lm.Latency) != 0 {
+		t.Fatal("did not expect to store the samples of another server")
+	}
+}
+
+func TestProbeLoadedCanceled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testingx.Must(t, err, "failed to listen")
+	defer ln.Close()
+	client := NewClient(clientName, clientVersion)
+	opts := params.DefaultTestOptions()
+	opts.ProbeInterval = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	inch := make(chan spec.Measurement)
+	lm := &LatestMeasurements{}
+	outch := client.probeLoaded(ctx, "ws://"+ln.Addr().String(),
+		spec.TestDownload, idleSamples{}, lm, inch, opts)
+	go func() {
+		cancel()
+		// Let the test outlive the probe, as it does when it emits its
+		// last measurements after ctx is done.
+		time.Sleep(100 * time.Millisecond)
+		close(inch)
+	}()
+	for m := range outch {
+		if m.Latency != nil {
+			t.Fatalf("unexpected sample after cancel: %+v", m.Latency)
+		}
+	}
+	if len(lm.Latency) != 0 {
+		t.Fatalf("unexpected %d samples stored after cancel", len(lm.Latency))
+	}
+}
+
+func TestLatencyProbe(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{
+		Duration: 500 * time.Millisecond,
+	})
+	defer s.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	client.TestOptions.ProbeInterval = 20 * time.Millisecond
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	var idle, loaded int
+	for m := range ch {
+		if m.Latency == nil {
+			continue
+		}
+		if m.Origin != spec.OriginClient || m.Test != spec.TestDownload {
+			t.Fatalf("unexpected latency measurement: %+v", m)
+		}
+		if m.Latency.Loaded {
+			loaded++
+		} else if loaded > 0 {
+			t.Fatal("idle samples must come before the loaded ones")
+		} else {
+			idle++
+		}
+	}
+	if idle != params.ProbeIdleSamples || loaded == 0 {
+		t.Fatalf("unexpected samples: idle %d, loaded %d", idle, loaded)
+	}
+	dl := client.Results()[spec.TestDownload]
+	if len(dl.Latency) != idle+loaded {
+		t.Fatal("the samples have not been stored")
+	}
+	if dl.Client.AppInfo == nil {
+		t.Fatal("missing application level measurements")
+	}
+}
+
+func TestLatencyProbeLocate(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{
+		Duration: 250 * time.Millisecond,
+	})
+	defer s.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Locate = testserver.NewLocator(s)
+	client.TestOptions.ProbeInterval = 10 * time.Millisecond
+	client.download = func(
+		ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+		opts params.TestOptions,
+	) error {
+		close(ch)
+		return conn.Close()
+	}
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	for range ch {
+		// Just drain the channel.
+	}
+	if n := len(client.Results()[spec.TestDownload].Latency); n != params.ProbeIdleSamples {
+		t.Fatalf("expected the idle samples of the located server, got %d", n)
+	}
+}
@@ -0,0 +1,116 @@
+package locator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	"go.yaml.in/yaml/v2"
+)
+
+// Inventory is the content of the file read by File, e.g., in YAML:
+//
+//	servers:
+//	  - url: wss://ndt1.example.com
+//	    weight: 2
+//	  - url: wss://ndt2.example.com
+//	  - url: wss://backup.example.com
+//	    priority: 1
+//
+// The format is JSON if the name of the file ends with ".json" and YAML
+// otherwise. Unknown keys are an error in both formats.
+type Inventory struct {
+	Servers []Server `json:"servers" yaml:"servers"`
+}
+
+// File is a locator returning the servers listed in an inventory file. It
+// reloads the file when its modification time or size changes, so that
+// the list of servers can change without restarting the client. It
+// implements ndt7.Locator and it is safe to use from multiple goroutines.
+type File struct {
+	path   string
+	policy Policy
+
+	mu      sync.Mutex
+	servers []Server
+	modTime time.Time
+	size    int64
+}
+
+// NewFile returns a File locator reading the inventory at path and ordering
+// its servers according to policy. It fails if it cannot load the inventory.
+func NewFile(path string, policy Policy) (*File, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	f := &File{path: path, policy: policy}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Nearest reloads the inventory, if it changed, and returns its servers
+// ordered according to the policy. If the inventory changed but it is not
+// valid, e.g., while it is being written, Nearest returns the error.
+func (f *File) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return targets(f.policy, f.servers, Server.weight)
+}
+
+// reloadLocked loads the inventory, unless it did not change since the
+// last time. The caller must hold f.mu.
+func (f *File) reloadLocked() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if f.servers != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+	inventory, err := ReadInventory(f.path)
+	if err != nil {
+		return err
+	}
+	f.servers, f.modTime, f.size = inventory.Servers, info.ModTime(), info.Size()
+	return nil
+}
+
+// ReadInventory reads and validates the inventory at path.
+func ReadInventory(path string) (*Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	inventory := &Inventory{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(inventory)
+	} else {
+		err = yaml.UnmarshalStrict(data, inventory)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(inventory.Servers) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, ErrNoServers)
+	}
+	if err := validateServers(inventory.Servers); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return inventory, nil
+}
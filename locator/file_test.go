@@ -0,0 +1,99 @@
+package locator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeInventory writes data to the file at path, making sure that its
+// modification time changes even on file systems with a coarse clock.
+func writeInventory(t *testing.T, path, data string) {
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(time.Duration(len(data)) * time.Second)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadInventory(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "servers.yaml")
+	writeInventory(t, yamlPath, "servers:\n  - url: wss://a.example\n    weight: 2\n  - url: b.example\n    priority: 1\n")
+	jsonPath := filepath.Join(dir, "servers.json")
+	writeInventory(t, jsonPath, `{"servers": [{"url": "wss://a.example", "weight": 2}, {"url": "b.example", "priority": 1}]}`)
+	for _, path := range []string{yamlPath, jsonPath} {
+		inventory, err := ReadInventory(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(inventory.Servers) != 2 || inventory.Servers[0].Weight != 2 ||
+			inventory.Servers[1].URL != "b.example" || inventory.Servers[1].Priority != 1 {
+			t.Fatalf("%s: unexpected inventory: %+v", path, inventory)
+		}
+	}
+}
+
+func TestReadInventoryInvalid(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "servers.yaml")
+	for data, want := range map[string]error{
+		"servers: []\n":                          ErrNoServers,
+		"servers:\n  - url: https://a.example\n": ErrInvalidServer,
+	} {
+		writeInventory(t, path, data)
+		if _, err := ReadInventory(path); !errors.Is(err, want) {
+			t.Errorf("%q: expected %v, got %v", data, want, err)
+		}
+	}
+	writeInventory(t, path, "servers:\n  - address: a.example\n")
+	if _, err := ReadInventory(path); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+	jsonPath := filepath.Join(dir, "servers.json")
+	writeInventory(t, jsonPath, `{"servers": [{"url": "a.example", "wieght": 2}]}`)
+	if _, err := ReadInventory(jsonPath); err == nil {
+		t.Fatal("expected an error for an unknown JSON field")
+	}
+	if _, err := ReadInventory(filepath.Join(dir, "missing.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.yaml")
+	writeInventory(t, path, "servers:\n  - url: wss://a.example\n")
+	f, err := NewFile(path, PolicyOrdered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := f.Nearest(context.Background(), "ndt/ndt7")
+	if err != nil || len(targets) != 1 || targets[0].Machine != "a.example" {
+		t.Fatalf("unexpected targets: %+v, %v", targets, err)
+	}
+	writeInventory(t, path, "servers:\n  - url: wss://b.example\n  - url: wss://c.example\n")
+	targets, err = f.Nearest(context.Background(), "ndt/ndt7")
+	if err != nil || len(targets) != 2 || targets[0].Machine != "b.example" {
+		t.Fatalf("expected the reloaded servers, got %+v, %v", targets, err)
+	}
+	writeInventory(t, path, "servers: [\n")
+	if _, err := f.Nearest(context.Background(), "ndt/ndt7"); err == nil {
+		t.Fatal("expected the error of the invalid inventory")
+	}
+}
+
+func TestNewFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.yaml")
+	if _, err := NewFile(path, PolicyOrdered); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected os.ErrNotExist, got %v", err)
+	}
+	writeInventory(t, path, "servers:\n  - url: wss://a.example\n")
+	if _, err := NewFile(path, Policy("closest")); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("expected ErrInvalidPolicy, got %v", err)
+	}
+}
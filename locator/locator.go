@@ -0,0 +1,291 @@
+// Package locator contains ndt7.Locator implementations for private fleets
+// of ndt-server instances, which do not register with the M-Lab Locate API.
+//
+// Static returns a fixed list of servers, File reads them from a JSON or
+// YAML inventory reloaded when it changes, and SRV discovers them using
+// DNS SRV records. All of them order the servers according to a Policy,
+// so that the clients of a fleet do not all pick the same server.
+package locator
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+)
+
+var (
+	// ErrInvalidServer indicates that a server is not valid, e.g., because
+	// its URL uses a scheme other than ws and wss.
+	ErrInvalidServer = errors.New("invalid server")
+
+	// ErrInvalidPolicy indicates that a Policy is not valid.
+	ErrInvalidPolicy = errors.New("invalid policy")
+
+	// ErrNoServers indicates that a locator knows no servers.
+	ErrNoServers = errors.New("no servers available")
+
+	// ErrInvalidLocator indicates that the string passed to Parse does
+	// not describe a locator.
+	ErrInvalidLocator = errors.New("invalid locator")
+)
+
+// Server is an ndt-server instance.
+type Server struct {
+	// URL is the base URL of the server, e.g., "wss://ndt.example.com"
+	// or "ws://192.0.2.1:8080". The path is ignored and the query, e.g.,
+	// an access token, is appended to the URLs of both tests. Without a
+	// scheme, e.g., "ndt.example.com:4443", the server can be used with
+	// both ws and wss. Otherwise, the clients using the other scheme skip
+	// the server.
+	URL string `json:"url" yaml:"url"`
+
+	// Weight is the relative probability of trying the server first with
+	// PolicyWeighted. Zero means the default weight, one, except for the
+	// servers discovered by SRV, where it is the record weight.
+	Weight int `json:"weight,omitempty" yaml:"weight,omitempty"`
+
+	// Priority is the priority of the server, where lower values come
+	// first, e.g., to only use backup servers when the primary ones fail.
+	// The policy orders the servers within each priority. For the servers
+	// discovered by SRV, it is the record priority.
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty"`
+}
+
+// target returns the Locate API target corresponding to s.
+func (s Server) target() (v2.Target, error) {
+	raw := s.URL
+	if !strings.Contains(raw, "://") {
+		raw = "//" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return v2.Target{}, fmt.Errorf("%w: %w", ErrInvalidServer, err)
+	}
+	if u.Host == "" {
+		return v2.Target{}, fmt.Errorf("%w: no host in %q", ErrInvalidServer, s.URL)
+	}
+	if s.Weight < 0 {
+		return v2.Target{}, fmt.Errorf("%w: negative weight for %q", ErrInvalidServer, s.URL)
+	}
+	if s.Priority < 0 {
+		return v2.Target{}, fmt.Errorf("%w: negative priority for %q", ErrInvalidServer, s.URL)
+	}
+	schemes := []string{"wss", "ws"}
+	switch u.Scheme {
+	case "":
+	case "ws", "wss":
+		schemes = []string{u.Scheme}
+	default:
+		return v2.Target{}, fmt.Errorf("%w: unsupported scheme in %q", ErrInvalidServer, s.URL)
+	}
+	t := v2.Target{Machine: u.Hostname(), URLs: map[string]string{}}
+	for _, scheme := range schemes {
+		for _, path := range []string{params.DownloadURLPath, params.UploadURLPath} {
+			testURL := url.URL{Scheme: scheme, Host: u.Host, Path: path, RawQuery: u.RawQuery}
+			t.URLs[scheme+"://"+path] = testURL.String()
+		}
+	}
+	return t, nil
+}
+
+// weight returns the weight of s used by PolicyWeighted.
+func (s Server) weight() float64 {
+	if s.Weight == 0 {
+		return 1
+	}
+	return float64(s.Weight)
+}
+
+// recordWeight is like weight but for the servers discovered by SRV, whose
+// weight is the record weight, including zero.
+func (s Server) recordWeight() float64 {
+	return float64(s.Weight)
+}
+
+// Policy is the strategy used to order the servers returned by a locator.
+// As in RFC 2782, the servers always come by increasing priority, and the
+// policy orders the servers within each priority.
+type Policy string
+
+const (
+	// PolicyOrdered returns the servers in the configured order, e.g., the
+	// order of the inventory file. With SRV, it is the order defined by
+	// RFC 2782, i.e., by priority and then randomly by weight.
+	PolicyOrdered = Policy("")
+
+	// PolicyRandom returns the servers in random order.
+	PolicyRandom = Policy("random")
+
+	// PolicyWeighted returns the servers in random order, where the
+	// probability of each server to come before the others is
+	// proportional to its weight. As in RFC 2782, the servers with
+	// weight zero, if any, come after the others, in random order.
+	PolicyWeighted = Policy("weighted")
+)
+
+// validate returns an error if p is not valid.
+func (p Policy) validate() error {
+	switch p {
+	case PolicyOrdered, PolicyRandom, PolicyWeighted:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidPolicy, string(p))
+}
+
+// order returns a copy of servers sorted by priority and ordered within
+// each priority according to p, using the given function to obtain the
+// weight of each server.
+func (p Policy) order(servers []Server, weight func(Server) float64) []Server {
+	servers = slices.Clone(servers)
+	slices.SortStableFunc(servers, func(a, b Server) int {
+		return cmp.Compare(a.Priority, b.Priority)
+	})
+	for start := 0; start < len(servers); {
+		end := start + 1
+		for end < len(servers) && servers[end].Priority == servers[start].Priority {
+			end++
+		}
+		p.orderGroup(servers[start:end], weight)
+		start = end
+	}
+	return servers
+}
+
+// orderGroup orders in place servers, which have the same priority,
+// according to p, using the given function to obtain their weight.
+func (p Policy) orderGroup(servers []Server, weight func(Server) float64) {
+	switch p {
+	case PolicyRandom:
+		rand.Shuffle(len(servers), func(i, j int) {
+			servers[i], servers[j] = servers[j], servers[i]
+		})
+	case PolicyWeighted:
+		// Weighted random sampling without replacement: sorting by the
+		// key u^(1/w), where u is uniform in (0, 1), puts each server
+		// first with probability proportional to its weight. Such keys
+		// are positive, while we give the servers with weight zero a
+		// negative random key.
+		keys := make([]float64, len(servers))
+		for i, s := range servers {
+			if w := weight(s); w > 0 {
+				keys[i] = math.Pow(1-rand.Float64(), 1/w)
+			} else {
+				keys[i] = rand.Float64() - 1
+			}
+		}
+		sort.Sort(byKey{servers, keys})
+	}
+}
+
+// byKey sorts servers by decreasing key.
+type byKey struct {
+	servers []Server
+	keys    []float64
+}
+
+func (b byKey) Len() int           { return len(b.servers) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] > b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.servers[i], b.servers[j] = b.servers[j], b.servers[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
+
+// targets returns the targets of servers ordered according to policy,
+// using the given function to obtain the weight of each server.
+func targets(policy Policy, servers []Server, weight func(Server) float64) ([]v2.Target, error) {
+	if len(servers) == 0 {
+		return nil, ErrNoServers
+	}
+	var result []v2.Target
+	for _, s := range policy.order(servers, weight) {
+		t, err := s.target()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// validateServers returns an error if any of servers is not valid.
+func validateServers(servers []Server) error {
+	for _, s := range servers {
+		if _, err := s.target(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Static is a locator returning a fixed list of servers. It implements
+// ndt7.Locator and it is safe to use from multiple goroutines.
+type Static struct {
+	servers []Server
+	policy  Policy
+}
+
+// NewStatic returns a Static locator returning the given servers ordered
+// according to policy.
+func NewStatic(policy Policy, servers ...Server) (*Static, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, ErrNoServers
+	}
+	if err := validateServers(servers); err != nil {
+		return nil, err
+	}
+	return &Static{servers: slices.Clone(servers), policy: policy}, nil
+}
+
+// Nearest returns the servers ordered according to the policy. It ignores
+// the service, since all the servers provide ndt7.
+func (s *Static) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	return targets(s.policy, s.servers, Server.weight)
+}
+
+// Locator is the interface implemented by all the locators of this
+// package. It is the same as ndt7.Locator.
+type Locator interface {
+	Nearest(ctx context.Context, service string) ([]v2.Target, error)
+}
+
+// Parse returns the locator described by s, which is one of:
+//
+//   - "static:<url>[,<url>...]", a Static locator for the servers with the
+//     given comma separated URLs, all with the default weight;
+//   - "file:<path>", a File locator reading the inventory at path;
+//   - "srv:<name>", a SRV locator looking up the SRV records of the given
+//     name, e.g., "_ndt7._tcp.example.com".
+//
+// The locator orders the servers according to policy.
+func Parse(s string, policy Policy) (Locator, error) {
+	kind, value, found := strings.Cut(s, ":")
+	if !found || value == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidLocator, s)
+	}
+	switch kind {
+	case "static":
+		var servers []Server
+		for _, u := range strings.Split(value, ",") {
+			servers = append(servers, Server{URL: u})
+		}
+		return NewStatic(policy, servers...)
+	case "file":
+		return NewFile(value, policy)
+	case "srv":
+		return NewSRV(value, policy)
+	}
+	return nil, fmt.Errorf("%w: unknown kind %q", ErrInvalidLocator, kind)
+}
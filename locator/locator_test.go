@@ -0,0 +1,175 @@
+package locator
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/m-lab/ndt7-client-go"
+)
+
+// The locators must be usable as ndt7.Client.Locate.
+var (
+	_ ndt7.Locator = &Static{}
+	_ ndt7.Locator = &File{}
+	_ ndt7.Locator = &SRV{}
+)
+
+func TestServerTarget(t *testing.T) {
+	target, err := Server{URL: "wss://ndt.example.com:4443/ignored?access_token=x"}.target()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.Machine != "ndt.example.com" || len(target.URLs) != 2 ||
+		target.URLs["wss:///ndt/v7/download"] != "wss://ndt.example.com:4443/ndt/v7/download?access_token=x" ||
+		target.URLs["wss:///ndt/v7/upload"] != "wss://ndt.example.com:4443/ndt/v7/upload?access_token=x" {
+		t.Fatalf("unexpected target: %+v", target)
+	}
+	target, err = Server{URL: "192.0.2.1:8080"}.target()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.Machine != "192.0.2.1" || len(target.URLs) != 4 ||
+		target.URLs["ws:///ndt/v7/download"] != "ws://192.0.2.1:8080/ndt/v7/download" ||
+		target.URLs["wss:///ndt/v7/upload"] != "wss://192.0.2.1:8080/ndt/v7/upload" {
+		t.Fatalf("unexpected target: %+v", target)
+	}
+}
+
+func TestServerTargetInvalid(t *testing.T) {
+	for _, s := range []Server{
+		{URL: ""},
+		{URL: "https://ndt.example.com"},
+		{URL: "wss://"},
+		{URL: "wss://ndt.example.com/%zz"},
+		{URL: "ndt.example.com", Weight: -1},
+		{URL: "ndt.example.com", Priority: -1},
+	} {
+		if _, err := s.target(); !errors.Is(err, ErrInvalidServer) {
+			t.Errorf("%+v: expected ErrInvalidServer, got %v", s, err)
+		}
+	}
+}
+
+func TestPolicyOrder(t *testing.T) {
+	servers := []Server{{URL: "a"}, {URL: "b"}, {URL: "c"}}
+	ordered := PolicyOrdered.order(servers, Server.weight)
+	if ordered[0].URL != "a" || ordered[1].URL != "b" || ordered[2].URL != "c" {
+		t.Fatalf("unexpected order: %+v", ordered)
+	}
+	first := map[string]int{}
+	for i := 0; i < 300; i++ {
+		random := PolicyRandom.order(servers, Server.weight)
+		if len(random) != len(servers) {
+			t.Fatalf("unexpected servers: %+v", random)
+		}
+		first[random[0].URL]++
+	}
+	if len(first) != 3 {
+		t.Fatalf("expected every server to come first sometimes: %v", first)
+	}
+	if servers[0].URL != "a" {
+		t.Fatal("expected the servers to be unchanged")
+	}
+}
+
+func TestPolicyWeighted(t *testing.T) {
+	servers := []Server{{URL: "light"}, {URL: "heavy", Weight: 9}}
+	heavy := 0
+	const runs = 2000
+	for i := 0; i < runs; i++ {
+		if PolicyWeighted.order(servers, Server.weight)[0].URL == "heavy" {
+			heavy++
+		}
+	}
+	// The expected fraction is 0.9. The bounds are loose enough that the
+	// test practically never fails by chance.
+	if fraction := float64(heavy) / runs; fraction < 0.85 || fraction > 0.95 {
+		t.Fatalf("unexpected fraction of runs with the heavy server first: %f", fraction)
+	}
+}
+
+func TestPolicyWeightedZero(t *testing.T) {
+	servers := []Server{{URL: "zero"}, {URL: "one", Weight: 1}, {URL: "other"}}
+	first := map[string]int{}
+	for i := 0; i < 100; i++ {
+		ordered := PolicyWeighted.order(servers, Server.recordWeight)
+		if ordered[0].URL != "one" {
+			t.Fatalf("the servers with weight zero should come last: %+v", ordered)
+		}
+		first[ordered[1].URL]++
+	}
+	if first["zero"] == 0 || first["other"] == 0 {
+		t.Fatalf("expected the servers with weight zero in random order: %v", first)
+	}
+}
+
+func TestPolicyPriority(t *testing.T) {
+	servers := []Server{
+		{URL: "backup1", Priority: 2, Weight: 100},
+		{URL: "primary1"},
+		{URL: "backup2", Priority: 2, Weight: 100},
+		{URL: "primary2"},
+	}
+	for _, policy := range []Policy{PolicyOrdered, PolicyRandom, PolicyWeighted} {
+		for i := 0; i < 100; i++ {
+			ordered := policy.order(servers, Server.weight)
+			if ordered[0].Priority != 0 || ordered[1].Priority != 0 ||
+				ordered[2].Priority != 2 || ordered[3].Priority != 2 {
+				t.Fatalf("%q: the backup servers should come last: %+v", policy, ordered)
+			}
+		}
+	}
+	ordered := PolicyOrdered.order(servers, Server.weight)
+	if ordered[0].URL != "primary1" || ordered[2].URL != "backup1" {
+		t.Fatalf("expected the configured order within each priority: %+v", ordered)
+	}
+}
+
+func TestNewStatic(t *testing.T) {
+	s, err := NewStatic(PolicyOrdered, Server{URL: "wss://a.example"}, Server{URL: "wss://b.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := s.Nearest(context.Background(), "ndt/ndt7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0].Machine != "a.example" || targets[1].Machine != "b.example" {
+		t.Fatalf("unexpected targets: %+v", targets)
+	}
+	if _, err := NewStatic(PolicyOrdered); err != ErrNoServers {
+		t.Fatalf("expected ErrNoServers, got %v", err)
+	}
+	if _, err := NewStatic(Policy("closest"), Server{URL: "wss://a.example"}); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("expected ErrInvalidPolicy, got %v", err)
+	}
+	if _, err := NewStatic(PolicyOrdered, Server{URL: "http://a.example"}); !errors.Is(err, ErrInvalidServer) {
+		t.Fatalf("expected ErrInvalidServer, got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	loc, err := Parse("static:wss://a.example,b.example:4443", PolicyRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := loc.(*Static); !ok || len(s.servers) != 2 || s.policy != PolicyRandom {
+		t.Fatalf("unexpected locator: %+v", loc)
+	}
+	loc, err = Parse("srv:_ndt7._tcp.example.com", PolicyOrdered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := loc.(*SRV); !ok || s.name != "_ndt7._tcp.example.com" {
+		t.Fatalf("unexpected locator: %+v", loc)
+	}
+	for _, s := range []string{"", "static", "static:", "mlab:x"} {
+		if _, err := Parse(s, PolicyOrdered); !errors.Is(err, ErrInvalidLocator) {
+			t.Errorf("%q: expected ErrInvalidLocator, got %v", s, err)
+		}
+	}
+	if _, err := Parse("file:/nonexistent/servers.yaml", PolicyOrdered); err == nil {
+		t.Fatal("expected an error")
+	}
+}
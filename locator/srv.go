@@ -0,0 +1,86 @@
+package locator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// Resolver looks up DNS SRV records. *net.Resolver implements it.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// SRV is a locator discovering the servers using the SRV records of a
+// DNS name, e.g., "_ndt7._tcp.example.com". Each record is a server that
+// can be used with both ws and wss, whose priority and weight are the ones
+// of the record. It
+// implements ndt7.Locator and ndt7.DialLocator, and it is safe to use from
+// multiple goroutines.
+type SRV struct {
+	// Resolver is the resolver used to look up the records. NewSRV sets
+	// it to net.DefaultResolver. NearestDial ignores it when it is a
+	// *net.Resolver.
+	Resolver Resolver
+
+	name   string
+	policy Policy
+}
+
+// NewSRV returns a SRV locator looking up the SRV records of name and
+// ordering the servers according to policy.
+func NewSRV(name string, policy Policy) (*SRV, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return &SRV{Resolver: net.DefaultResolver, name: name, policy: policy}, nil
+}
+
+// Nearest looks up the SRV records and returns the servers ordered
+// according to the policy.
+func (s *SRV) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	return s.lookup(ctx, s.Resolver)
+}
+
+// NearestDial is like Nearest but, unless s.Resolver is a custom Resolver,
+// it looks up the records using the Go resolver, which connects to the DNS
+// servers using dial, e.g., to bind to the network interface used by the
+// tests.
+func (s *SRV) NearestDial(ctx context.Context, service string,
+	dial func(ctx context.Context, network, address string) (net.Conn, error)) ([]v2.Target, error) {
+	resolver := s.Resolver
+	if r, ok := resolver.(*net.Resolver); ok {
+		resolver = &net.Resolver{PreferGo: true, StrictErrors: r.StrictErrors, Dial: dial}
+	}
+	return s.lookup(ctx, resolver)
+}
+
+// lookup looks up the SRV records using resolver and returns the servers
+// ordered according to the policy.
+func (s *SRV) lookup(ctx context.Context, resolver Resolver) ([]v2.Target, error) {
+	_, records, err := resolver.LookupSRV(ctx, "", "", s.name)
+	if err != nil {
+		return nil, err
+	}
+	var servers []Server
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Target, ".")
+		if host == "" {
+			// A "." target means that the service is not available.
+			continue
+		}
+		servers = append(servers, Server{
+			URL:      net.JoinHostPort(host, strconv.Itoa(int(r.Port))),
+			Weight:   int(r.Weight),
+			Priority: int(r.Priority),
+		})
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%s: %w", s.name, ErrNoServers)
+	}
+	return targets(s.policy, servers, Server.recordWeight)
+}
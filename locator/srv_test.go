@@ -0,0 +1,122 @@
+package locator
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// mockedResolver is a Resolver returning fixed records.
+type mockedResolver struct {
+	name    string
+	records []*net.SRV
+	err     error
+}
+
+func (r *mockedResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.name = name
+	return name, r.records, r.err
+}
+
+func TestSRV(t *testing.T) {
+	s, err := NewSRV("_ndt7._tcp.example.com", PolicyOrdered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := &mockedResolver{records: []*net.SRV{
+		{Target: "ndt1.example.com.", Port: 443, Priority: 10, Weight: 5},
+		{Target: "ndt2.example.com.", Port: 4443, Priority: 20},
+	}}
+	s.Resolver = resolver
+	targets, err := s.Nearest(context.Background(), "ndt/ndt7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolver.name != "_ndt7._tcp.example.com" {
+		t.Fatalf("unexpected name: %s", resolver.name)
+	}
+	if len(targets) != 2 || targets[0].Machine != "ndt1.example.com" ||
+		targets[1].URLs["wss:///ndt/v7/download"] != "wss://ndt2.example.com:4443/ndt/v7/download" {
+		t.Fatalf("unexpected targets: %+v", targets)
+	}
+}
+
+func TestSRVPriority(t *testing.T) {
+	s, err := NewSRV("_ndt7._tcp.example.com", PolicyWeighted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Resolver = &mockedResolver{records: []*net.SRV{
+		{Target: "backup.example.com.", Port: 443, Priority: 20, Weight: 100},
+		{Target: "ndt.example.com.", Port: 443, Priority: 10, Weight: 1},
+	}}
+	for i := 0; i < 100; i++ {
+		targets, err := s.Nearest(context.Background(), "ndt/ndt7")
+		if err != nil || targets[0].Machine != "ndt.example.com" {
+			t.Fatalf("the server with the lowest priority should come first: %+v, %v", targets, err)
+		}
+	}
+}
+
+func TestSRVWeightZero(t *testing.T) {
+	s, err := NewSRV("_ndt7._tcp.example.com", PolicyWeighted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Resolver = &mockedResolver{records: []*net.SRV{
+		{Target: "backup.example.com.", Port: 443},
+		{Target: "ndt.example.com.", Port: 443, Weight: 1},
+	}}
+	for i := 0; i < 100; i++ {
+		targets, err := s.Nearest(context.Background(), "ndt/ndt7")
+		if err != nil || targets[0].Machine != "ndt.example.com" {
+			t.Fatalf("the server with weight zero should come last: %+v, %v", targets, err)
+		}
+	}
+}
+
+func TestSRVNearestDial(t *testing.T) {
+	s, err := NewSRV("_ndt7._tcp.example.com", PolicyOrdered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockedErr := errors.New("mocked error")
+	var dialed atomic.Bool
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed.Store(true)
+		return nil, mockedErr
+	}
+	if _, err := s.NearestDial(context.Background(), "ndt/ndt7", dial); err == nil || !dialed.Load() {
+		t.Fatalf("expected to query DNS using dial, got %v", err)
+	}
+	// A custom Resolver is used as is.
+	resolver := &mockedResolver{records: []*net.SRV{{Target: "ndt.example.com.", Port: 443}}}
+	s.Resolver = resolver
+	dialed.Store(false)
+	targets, err := s.NearestDial(context.Background(), "ndt/ndt7", dial)
+	if err != nil || len(targets) != 1 || dialed.Load() {
+		t.Fatalf("expected to use the custom resolver, got %+v, %v", targets, err)
+	}
+}
+
+func TestSRVFailure(t *testing.T) {
+	s, err := NewSRV("_ndt7._tcp.example.com", PolicyRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockedErr := errors.New("mocked error")
+	s.Resolver = &mockedResolver{err: mockedErr}
+	if _, err := s.Nearest(context.Background(), "ndt/ndt7"); err != mockedErr {
+		t.Fatalf("expected the resolver error, got %v", err)
+	}
+	// A single "." target means that the service is not available.
+	s.Resolver = &mockedResolver{records: []*net.SRV{{Target: "."}}}
+	if _, err := s.Nearest(context.Background(), "ndt/ndt7"); !errors.Is(err, ErrNoServers) {
+		t.Fatalf("expected ErrNoServers, got %v", err)
+	}
+	if _, err := NewSRV("_ndt7._tcp.example.com", Policy("closest")); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("expected ErrInvalidPolicy, got %v", err)
+	}
+}
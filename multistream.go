@@ -0,0 +1,314 @@
+package ndt7
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/internal/websocketx"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// startStreams is like start but runs the test using n concurrent
+// connections. The results of each stream are stored into the Streams
+// field of lm, which contains the results of the given test.
+//
+// Only failing to connect the first stream is fatal. A stream that fails
+// to connect, or that fails during the test, has its Error field set and
+// the test continues using the remaining streams (i.e., it is degraded).
+func (c *Client) startStreams(ctx context.Context, f testFn, p string,
+	test spec.TestKind, lm *LatestMeasurements, n int) (<-chan spec.Measurement, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	c.refreshTargets(p)
+	idle := c.probeIdle(ctx, p, c.TestOptions)
+	streamOpts := streamOptions(c.TestOptions, n)
+	first, u, err := c.dial(ctx, p, lm, streamOpts)
+	if err != nil {
+		return nil, err
+	}
+	// Remember the FQDN of the first stream, since connecting the other
+	// streams may change the FQDN when they use different targets.
+	c.mu.Lock()
+	fqdn := c.FQDN
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.FQDN = fqdn
+		c.mu.Unlock()
+	}()
+	streams := make([]*LatestMeasurements, n)
+	for i := range streams {
+		streams[i] = &LatestMeasurements{}
+	}
+	c.mu.Lock()
+	streams[0].ConnectionTiming = lm.ConnectionTiming
+	streams[0].TLS = lm.TLS
+	c.mu.Unlock()
+	acrossTargets := c.StreamsAcrossTargets && c.Server == "" && c.ServiceURL == nil
+	conns := make([]websocketx.Conn, n)
+	conns[0] = first
+	for i := 1; i < n; i++ {
+		conn, err := c.dialStream(ctx, p, u, streams[i], streamOpts, acrossTargets)
+		if err != nil {
+			streams[i].Error = err
+			continue
+		}
+		conns[i] = conn
+	}
+	c.mu.Lock()
+	lm.Streams = streams
+	c.mu.Unlock()
+	ch := make(chan spec.Measurement)
+	go c.collectStreams(ctx, f, conns, lm, test, ch, c.TestOptions)
+	return c.probeLoaded(ctx, u, test, idle, lm, ch, c.TestOptions), nil
+}
+
+// dialStream establishes the connection of a stream other than the first
+// one, whose results are lm, for the URL path p. With acrossTargets, it
+// uses the next target, if any, and otherwise the server at u, which the
+// first stream connected to. Like dial, it stores into lm how long it took,
+// including trying the other targets.
+func (c *Client) dialStream(ctx context.Context, p, u string, lm *LatestMeasurements,
+	opts params.TestOptions, acrossTargets bool) (*websocket.Conn, error) {
+	start := time.Now()
+	if acrossTargets {
+		conn, _, err := c.dial(ctx, p, lm, opts)
+		if !errors.Is(err, ErrNoTargets) {
+			return conn, err
+		}
+		// Fewer targets than streams: reuse the first server.
+	}
+	conn, err := c.tryConnect(ctx, u, lm, opts)
+	c.finishTiming(lm, start)
+	return conn, err
+}
+
+// collectStreams is like collectData but for multi-stream tests. It tags
+// the measurements of each stream with the stream number and, every
+// opts.UpdateInterval, emits measurements aggregating all the streams.
+// A nil entry in conns indicates a stream that failed to connect. Each
+// stream gets an even share of opts.MaxBytes.
+func (c *Client) collectStreams(ctx context.Context, f testFn, conns []websocketx.Conn,
+	lm *LatestMeasurements, test spec.TestKind, outch chan<- spec.Measurement,
+	opts params.TestOptions) {
+	defer close(outch)
+	streamOpts := streamOptions(opts, len(conns))
+	inch := make(chan spec.Measurement)
+	wg := &sync.WaitGroup{}
+	for i, conn := range conns {
+		if conn == nil {
+			continue
+		}
+		streamch := make(chan spec.Measurement)
+		errch := make(chan error, 1)
+		go func(conn websocketx.Conn) {
+			errch <- f(ctx, conn, streamch, streamOpts)
+		}(conn)
+		wg.Add(1)
+		go func(stream int) {
+			defer wg.Done()
+			for m := range streamch {
+				m.Stream = stream
+				inch <- m
+			}
+			err := classify(streamError(ctx, <-errch), "")
+			c.mu.Lock()
+			lm.Streams[stream-1].Error = err
+			c.mu.Unlock()
+		}(i + 1)
+	}
+	go func() {
+		wg.Wait()
+		close(inch)
+	}()
+
+	var prevClient, prevServer time.Time
+	for m := range inch {
+		c.mu.Lock()
+		lm.updateStream(&m)
+		r := c.traceRecord(lm, &m)
+		c.mu.Unlock()
+		c.record(r)
+		outch <- m
+		now := time.Now()
+		switch {
+		case m.Origin == spec.OriginClient && now.Sub(prevClient) > opts.UpdateInterval:
+			prevClient = now
+			outch <- c.clientAggregate(lm, test)
+		case m.Origin == spec.OriginServer && m.TCPInfo != nil &&
+			now.Sub(prevServer) > opts.UpdateInterval:
+			prevServer = now
+			outch <- c.serverAggregate(lm, test)
+		}
+	}
+	// Make sure the final aggregates account for all the streams.
+	if !prevClient.IsZero() {
+		outch <- c.clientAggregate(lm, test)
+	}
+	if !prevServer.IsZero() {
+		outch <- c.serverAggregate(lm, test)
+	}
+	c.mu.Lock()
+	lm.finishStreams(streamOpts)
+	if allFailed(lm.Streams) {
+		lm.Error = lm.Streams[0].Error
+	}
+	c.mu.Unlock()
+}
+
+// finishStreams sets the fields of lm and of its streams that depend on
+// the final measurements of the streams, given the options of each stream.
+// We can only call it once we stored all such measurements.
+func (lm *LatestMeasurements) finishStreams(streamOpts params.TestOptions) {
+	for _, s := range lm.Streams {
+		s.BudgetLimited = budgetLimited(s, streamOpts)
+		lm.BudgetLimited = lm.BudgetLimited || s.BudgetLimited
+		lm.Converged = lm.Converged || s.Converged
+	}
+	lm.ClientLimited = clientLimited(lm, len(lm.Streams))
+}
+
+// updateStream stores m, which belongs to the stream of lm whose number
+// is m.Stream, as the latest measurement of such stream.
+func (lm *LatestMeasurements) updateStream(m *spec.Measurement) {
+	lm.Streams[m.Stream-1].update(m)
+	if m.ConnectionInfo != nil && lm.ConnectionInfo == nil {
+		// The aggregate has no UUID: each stream has its own.
+		lm.ConnectionInfo = &spec.ConnectionInfo{
+			Client:    m.ConnectionInfo.Client,
+			Server:    m.ConnectionInfo.Server,
+			StartTime: m.ConnectionInfo.StartTime,
+		}
+	}
+}
+
+// streamOptions returns the options of each of n streams, which get an
+// even share of opts.MaxBytes.
+func streamOptions(opts params.TestOptions, n int) params.TestOptions {
+	if n > 1 && opts.MaxBytes > 0 {
+		opts.MaxBytes = max(opts.MaxBytes/int64(n), 1)
+	}
+	return opts
+}
+
+// allFailed returns whether all the given streams failed.
+func allFailed(streams []*LatestMeasurements) bool {
+	for _, s := range streams {
+		if s.Error == nil {
+			return false
+		}
+	}
+	return len(streams) > 0
+}
+
+// streamError returns the error that caused a stream to fail, if any. A
+// stream ended by the server with a normal closure did not fail. Since the
+// test functions return nil when ctx expires, we return the error of ctx,
+// if any, as the stream was aborted before the end of the test.
+func streamError(ctx context.Context, err error) error {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		err = nil
+	}
+	if err == nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// clientAggregate stores and returns the aggregate client measurement.
+func (c *Client) clientAggregate(lm *LatestMeasurements, test spec.TestKind) spec.Measurement {
+	c.mu.Lock()
+	m := spec.Measurement{
+		AppInfo: aggregateAppInfo(lm.Streams),
+		CPUInfo: aggregateCPUInfo(lm.Streams),
+		Origin:  spec.OriginClient,
+		Test:    test,
+	}
+	// The client side TCPInfo is only available on some systems.
+	if ti := aggregateTCPInfo(lm.Streams, spec.OriginClient); ti.ElapsedTime > 0 {
+		m.TCPInfo = ti
+	}
+	lm.setLatest(&m)
+	r := c.traceRecord(lm, &m)
+	c.mu.Unlock()
+	c.record(r)
+	return m
+}
+
+// serverAggregate stores and returns the aggregate server measurement.
+func (c *Client) serverAggregate(lm *LatestMeasurements, test spec.TestKind) spec.Measurement {
+	c.mu.Lock()
+	m := spec.Measurement{
+		Origin:  spec.OriginServer,
+		TCPInfo: aggregateTCPInfo(lm.Streams, spec.OriginServer),
+		Test:    test,
+	}
+	lm.setLatest(&m)
+	r := c.traceRecord(lm, &m)
+	c.mu.Unlock()
+	c.record(r)
+	return m
+}
+
+// aggregateAppInfo sums the bytes of the latest client measurement of each
+// stream. The elapsed time is the one of the longest running stream.
+func aggregateAppInfo(streams []*LatestMeasurements) *spec.AppInfo {
+	ai := &spec.AppInfo{}
+	for _, s := range streams {
+		if s.Client.AppInfo == nil {
+			continue
+		}
+		ai.NumBytes += s.Client.AppInfo.NumBytes
+		if s.Client.AppInfo.ElapsedTime > ai.ElapsedTime {
+			ai.ElapsedTime = s.Client.AppInfo.ElapsedTime
+		}
+	}
+	return ai
+}
+
+// aggregateCPUInfo returns the latest CPUInfo of the streams, i.e., the one
+// with the longest elapsed time, or nil if there is none. Each stream
+// measures the CPU time of the whole process, so we do not sum them.
+func aggregateCPUInfo(streams []*LatestMeasurements) *spec.CPUInfo {
+	var info *spec.CPUInfo
+	for _, s := range streams {
+		cur := s.Client.CPUInfo
+		if cur != nil && (info == nil || cur.ElapsedTime > info.ElapsedTime) {
+			info = cur
+		}
+	}
+	return info
+}
+
+// aggregateTCPInfo combines the latest TCPInfo of each stream measured by
+// the given origin. The byte counters are summed, MinRTT is the minimum
+// across the streams and the elapsed time is the one of the longest
+// running stream.
+func aggregateTCPInfo(streams []*LatestMeasurements, origin spec.OriginKind) *spec.TCPInfo {
+	ti := &spec.TCPInfo{}
+	for _, s := range streams {
+		cur := s.Server.TCPInfo
+		if origin == spec.OriginClient {
+			cur = s.Client.TCPInfo
+		}
+		if cur == nil {
+			continue
+		}
+		ti.BytesAcked += cur.BytesAcked
+		ti.BytesReceived += cur.BytesReceived
+		ti.BytesSent += cur.BytesSent
+		ti.BytesRetrans += cur.BytesRetrans
+		if ti.MinRTT == 0 || (cur.MinRTT != 0 && cur.MinRTT < ti.MinRTT) {
+			ti.MinRTT = cur.MinRTT
+		}
+		if cur.ElapsedTime > ti.ElapsedTime {
+			ti.ElapsedTime = cur.ElapsedTime
+		}
+	}
+	return ti
+}
@@ -0,0 +1,388 @@
+package ndt7
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/go/testingx"
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/ndt-server/ndt7/ndt7test"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/internal/websocketx"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+func TestDownloadStreamsCase(t *testing.T) {
+	client := newMockedClient()
+	client.DownloadStreams = 3
+	client.download = func(
+		ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+		opts params.TestOptions,
+	) error {
+		defer close(ch)
+		ch <- spec.Measurement{
+			ConnectionInfo: &spec.ConnectionInfo{
+				Client: "127.0.0.1:1234",
+				Server: "127.0.0.2:443",
+				UUID:   "uuid",
+			},
+			Origin: spec.OriginServer,
+			Test:   spec.TestDownload,
+			TCPInfo: &spec.TCPInfo{
+				ElapsedTime: 1000,
+			},
+		}
+		ch <- spec.Measurement{
+			AppInfo: &spec.AppInfo{
+				ElapsedTime: 1000,
+				NumBytes:    100,
+			},
+			Origin: spec.OriginClient,
+			Test:   spec.TestDownload,
+		}
+		return nil
+	}
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	streams := map[int]int{}
+	for m := range ch {
+		streams[m.Stream]++
+	}
+	for i := 0; i <= 3; i++ {
+		if streams[i] == 0 {
+			t.Fatalf("no measurements for stream %d", i)
+		}
+	}
+	dl := client.Results()[spec.TestDownload]
+	if len(dl.Streams) != 3 {
+		t.Fatalf("expected three streams, got %d", len(dl.Streams))
+	}
+	for _, s := range dl.Streams {
+		if s.ConnectionInfo == nil || s.ConnectionInfo.UUID != "uuid" {
+			t.Fatal("missing per-stream ConnectionInfo")
+		}
+	}
+	if dl.ConnectionInfo == nil || dl.ConnectionInfo.UUID != "" {
+		t.Fatal("unexpected aggregate ConnectionInfo")
+	}
+	if dl.Client.AppInfo == nil || dl.Client.AppInfo.NumBytes != 300 {
+		t.Fatalf("unexpected aggregate AppInfo: %+v", dl.Client.AppInfo)
+	}
+}
+
+func TestUploadStreamsDegraded(t *testing.T) {
+	client := newMockedClient()
+	client.UploadStreams = 3
+	mockedErr := errors.New("mocked error")
+	connect := client.connect
+	count := 0
+	client.connect = func(
+		dialer websocket.Dialer, ctx context.Context, urlStr string,
+		requestHeader http.Header) (*websocket.Conn, *http.Response, error,
+	) {
+		count++
+		if count == 2 {
+			return nil, nil, mockedErr
+		}
+		return connect(dialer, ctx, urlStr, requestHeader)
+	}
+	client.upload = func(
+		ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+		opts params.TestOptions,
+	) error {
+		defer close(ch)
+		tcpInfo := &spec.TCPInfo{ElapsedTime: 1000000}
+		tcpInfo.BytesReceived = 1000
+		ch <- spec.Measurement{
+			Origin:  spec.OriginServer,
+			Test:    spec.TestUpload,
+			TCPInfo: tcpInfo,
+		}
+		return mockedErr
+	}
+	ch, err := client.StartUpload(context.Background())
+	testingx.Must(t, err, "failed to start upload")
+	for range ch {
+	}
+	ul := client.Results()[spec.TestUpload]
+	if len(ul.Streams) != 3 {
+		t.Fatalf("expected three streams, got %d", len(ul.Streams))
+	}
+	for i, s := range ul.Streams {
+		if !errors.Is(s.Error, mockedErr) {
+			t.Fatalf("stream %d: unexpected error %v", i+1, s.Error)
+		}
+	}
+	if ul.Streams[1].Server.TCPInfo != nil {
+		t.Fatal("the stream that failed to connect has measurements")
+	}
+	if ul.Server.TCPInfo == nil || ul.Server.TCPInfo.BytesReceived != 2000 {
+		t.Fatalf("unexpected aggregate TCPInfo: %+v", ul.Server.TCPInfo)
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	normal := &websocket.CloseError{Code: websocket.CloseNormalClosure}
+	if streamError(context.Background(), normal) != nil {
+		t.Fatal("a normal closure is not a failure")
+	}
+	abnormal := &websocket.CloseError{Code: websocket.CloseAbnormalClosure}
+	if streamError(context.Background(), abnormal) != abnormal {
+		t.Fatal("an abnormal closure is a failure")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if err := streamError(ctx, nil); err != context.DeadlineExceeded {
+		t.Fatalf("an expired context is a failure, got %v", err)
+	}
+	if err := streamError(ctx, normal); err != context.DeadlineExceeded {
+		t.Fatalf("an expired context is a failure, got %v", err)
+	}
+}
+
+func TestAggregateAppInfo(t *testing.T) {
+	streams := []*LatestMeasurements{{
+		Client: spec.Measurement{
+			AppInfo: &spec.AppInfo{ElapsedTime: 10, NumBytes: 100},
+		},
+	}, {
+		Client: spec.Measurement{
+			AppInfo: &spec.AppInfo{ElapsedTime: 20, NumBytes: 50},
+		},
+	}, {}}
+	ai := aggregateAppInfo(streams)
+	if ai.NumBytes != 150 || ai.ElapsedTime != 20 {
+		t.Fatalf("unexpected aggregate: %+v", ai)
+	}
+}
+
+func TestAggregateTCPInfo(t *testing.T) {
+	first := &spec.TCPInfo{ElapsedTime: 10}
+	first.BytesReceived = 100
+	first.MinRTT = 2000
+	second := &spec.TCPInfo{ElapsedTime: 20}
+	second.BytesReceived = 200
+	second.MinRTT = 1000
+	streams := []*LatestMeasurements{
+		{Server: spec.Measurement{TCPInfo: first}},
+		{Server: spec.Measurement{TCPInfo: second}},
+		{},
+	}
+	ti := aggregateTCPInfo(streams, spec.OriginServer)
+	if ti.BytesReceived != 300 || ti.MinRTT != 1000 || ti.ElapsedTime != 20 {
+		t.Fatalf("unexpected aggregate: %+v", ti)
+	}
+	if ti := aggregateTCPInfo(streams, spec.OriginClient); ti.ElapsedTime != 0 {
+		t.Fatalf("unexpected client aggregate: %+v", ti)
+	}
+}
+
+func TestAggregateCPUInfo(t *testing.T) {
+	streams := []*LatestMeasurements{{
+		Client: spec.Measurement{
+			CPUInfo: &spec.CPUInfo{ElapsedTime: 20, CPUTime: 15},
+		},
+	}, {
+		Client: spec.Measurement{
+			CPUInfo: &spec.CPUInfo{ElapsedTime: 10, CPUTime: 5},
+		},
+	}, {}}
+	info := aggregateCPUInfo(streams)
+	if info == nil || info.ElapsedTime != 20 || info.CPUTime != 15 {
+		t.Fatalf("unexpected aggregate: %+v", info)
+	}
+	if info := aggregateCPUInfo([]*LatestMeasurements{{}}); info != nil {
+		t.Fatalf("unexpected aggregate without CPU info: %+v", info)
+	}
+}
+
+func TestClientLimited(t *testing.T) {
+	measurements := func(elapsed, cpu time.Duration) *LatestMeasurements {
+		return &LatestMeasurements{
+			Client: spec.Measurement{
+				CPUInfo: &spec.CPUInfo{
+					ElapsedTime: elapsed.Microseconds(),
+					CPUTime:     cpu.Microseconds(),
+				},
+			},
+		}
+	}
+	if clientLimited(&LatestMeasurements{}, 1) {
+		t.Fatal("expected not client-limited without CPU info")
+	}
+	if clientLimited(measurements(500*time.Millisecond, 500*time.Millisecond), 1) {
+		t.Fatal("expected not client-limited before the minimum duration")
+	}
+	if !clientLimited(measurements(2*time.Second, 1900*time.Millisecond), 1) {
+		t.Fatal("expected client-limited with one saturated CPU")
+	}
+	if clientLimited(measurements(2*time.Second, time.Second), 1) {
+		t.Fatal("expected not client-limited with half a CPU")
+	}
+	// With multiple streams, the client can use multiple CPUs.
+	if runtime.NumCPU() > 1 && clientLimited(measurements(2*time.Second, 1900*time.Millisecond), 2) {
+		t.Fatal("expected not client-limited with one of two CPUs")
+	}
+}
+
+func TestClientLimitedBidirectional(t *testing.T) {
+	if runtime.NumCPU() < 2 {
+		t.Skip("Skipping test with a single CPU")
+	}
+	run := func(cpu time.Duration) map[spec.TestKind]*LatestMeasurements {
+		client := newMockedClient()
+		// The CPU time covers the whole process, i.e., both directions.
+		measure := func(test spec.TestKind) testFn {
+			return func(
+				ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+				opts params.TestOptions,
+			) error {
+				defer close(ch)
+				ch <- spec.Measurement{
+					CPUInfo: &spec.CPUInfo{
+						ElapsedTime: (2 * time.Second).Microseconds(),
+						CPUTime:     cpu.Microseconds(),
+					},
+					Origin: spec.OriginClient,
+					Test:   test,
+				}
+				return nil
+			}
+		}
+		client.download = measure(spec.TestDownload)
+		client.upload = measure(spec.TestUpload)
+		ch, err := client.StartBidirectional(context.Background())
+		testingx.Must(t, err, "failed to start bidirectional test")
+		for range ch {
+		}
+		return client.BidirectionalResults()
+	}
+	for test, lm := range run(1900 * time.Millisecond) {
+		if lm.ClientLimited {
+			t.Fatalf("expected the %s not to be client-limited with one of two CPUs", test)
+		}
+	}
+	for test, lm := range run(3900 * time.Millisecond) {
+		if !lm.ClientLimited {
+			t.Fatalf("expected the %s to be client-limited with two saturated CPUs", test)
+		}
+	}
+}
+
+func TestIntegrationDownloadStreams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping test in short mode")
+	}
+	h, fs := ndt7test.NewNDT7Server(t)
+	defer os.RemoveAll(h.DataDir)
+	defer fs.Close()
+	u, err := url.Parse(fs.URL)
+	testingx.Must(t, err, "failed to parse ndt7test server url")
+
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	client.Server = u.Host
+	client.DownloadStreams = 2
+
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "download failed to start")
+	for range ch {
+		// We already test that measurements follow our expectations
+		// in internal/download/download_test.go.
+	}
+	dl := client.Results()[spec.TestDownload]
+	if len(dl.Streams) != 2 {
+		t.Fatalf("expected two streams, got %d", len(dl.Streams))
+	}
+	uuids := map[string]bool{}
+	for _, s := range dl.Streams {
+		if s.ConnectionInfo == nil || s.ConnectionInfo.UUID == "" {
+			t.Fatal("missing per-stream UUID")
+		}
+		uuids[s.ConnectionInfo.UUID] = true
+	}
+	if len(uuids) != 2 {
+		t.Fatal("expected distinct per-stream UUIDs")
+	}
+}
+
+func TestIntegrationUploadStreams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping test in short mode")
+	}
+	h, fs := ndt7test.NewNDT7Server(t)
+	defer os.RemoveAll(h.DataDir)
+	defer fs.Close()
+	u, err := url.Parse(fs.URL)
+	testingx.Must(t, err, "failed to parse ndt7test server url")
+
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	client.Server = u.Host
+	client.UploadStreams = 2
+
+	ch, err := client.StartUpload(context.Background())
+	testingx.Must(t, err, "upload failed to start")
+	for range ch {
+		// We already test that measurements follow our expectations
+		// in internal/upload/upload_test.go.
+	}
+	ul := client.Results()[spec.TestUpload]
+	if len(ul.Streams) != 2 {
+		t.Fatalf("expected two streams, got %d", len(ul.Streams))
+	}
+	var sum int64
+	for i, s := range ul.Streams {
+		if s.Error != nil {
+			t.Fatalf("stream %d failed: %v", i+1, s.Error)
+		}
+		if s.Server.TCPInfo != nil {
+			sum += s.Server.TCPInfo.BytesReceived
+		}
+	}
+	if ul.Server.TCPInfo == nil || ul.Server.TCPInfo.BytesReceived != sum {
+		t.Fatal("the aggregate does not sum the bytes received on each stream")
+	}
+}
+
+func TestStreamsConnectionTiming(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{Duration: time.Second})
+	defer s.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	client.DownloadStreams = 2
+	client.StreamsAcrossTargets = true
+	client.Locate = &targetsLocator{targets: []v2.Target{
+		newTarget(s.Host()), newTarget("down.example"),
+	}}
+	const delay = 50 * time.Millisecond
+	client.Dialer.NetDialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if address == "down.example:80" {
+			time.Sleep(delay)
+			return nil, errors.New("connection refused")
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	drain(ch)
+	streams := client.Results()[spec.TestDownload].Streams
+	if len(streams) != 2 || streams[1].Error != nil {
+		t.Fatalf("unexpected streams: %+v", streams)
+	}
+	// The second stream failed to connect to the second target and then
+	// connected to the first server: its total accounts for both.
+	timing := streams[1].ConnectionTiming
+	if timing == nil || timing.Total < delay.Microseconds() {
+		t.Fatalf("expected the total to include the failed attempt: %+v", timing)
+	}
+}
@@ -4,6 +4,10 @@
 // by default. However, you can also manually discover a server and
 // configure the client accordingly.
 //
+// A Client may be used by multiple goroutines at once, e.g., to run
+// the download and the upload at the same time. StartBidirectional
+// does that for you using the same server for both directions.
+//
 // The code configures reasonable I/O timeouts. We recommend to also
 // provide contexts with whole-operation timeouts attached, as we
 // do in the code example provided as part of this package.
@@ -12,9 +16,13 @@ package ndt7
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -39,8 +47,12 @@ var (
 	// ErrServiceUnsupported is returned if an unknown service URL is provided.
 	ErrServiceUnsupported = errors.New("unsupported service url")
 
-	// ErrNoTargets is returned if all Locate targets have been tried.
+	// ErrNoTargets is returned if all Locate targets have been tried or
+	// their access tokens are about to expire.
 	ErrNoTargets = errors.New("no targets available")
+
+	// ErrInvalidTestOptions is returned if Client.TestOptions is not valid.
+	ErrInvalidTestOptions = params.ErrInvalidTestOptions
 )
 
 // Locator is an interface used to locate a server.
@@ -48,6 +60,28 @@ type Locator interface {
 	Nearest(ctx context.Context, service string) ([]v2.Target, error)
 }
 
+// DialLocator is a Locator that connects to the network without using the
+// Locate API, e.g., to look up DNS records. When the Client options require
+// customizing how we establish connections, i.e., AddressFamily, Interface
+// or SourceAddress are set, we call NearestDial, with the function that
+// establishes connections honouring such options, instead of Nearest.
+type DialLocator interface {
+	Locator
+	NearestDial(ctx context.Context, service string,
+		dial func(ctx context.Context, network, address string) (net.Conn, error)) ([]v2.Target, error)
+}
+
+// WrappingLocator is a Locator that wraps another Locator, e.g., to filter
+// the targets it returns. Since the Client customizes how the wrapped
+// Locator establishes connections, when the Client options require so, it
+// calls Unwrap to obtain the wrapped Locator and Wrap to obtain a copy of
+// the WrappingLocator wrapping the customized one.
+type WrappingLocator interface {
+	Locator
+	Unwrap() Locator
+	Wrap(l Locator) Locator
+}
+
 // connectFn is the type of the function used to create
 // a new *websocket.Conn connection.
 type connectFn = func(
@@ -59,18 +93,127 @@ type connectFn = func(
 // testFn is the type of the function running a test.
 type testFn = func(
 	ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+	opts params.TestOptions,
 ) error
 
+// TestOptions contains the tunable parameters of the download and upload
+// tests, i.e., their duration, the I/O timeout, the interval between client
+// side measurements, the maximum message size, and the upload scaling
+// fraction. Use DefaultTestOptions to obtain the default values.
+type TestOptions = params.TestOptions
+
+// DefaultTestOptions returns the TestOptions configured by NewClient.
+func DefaultTestOptions() TestOptions {
+	return params.DefaultTestOptions()
+}
+
 // DefaultWebSocketHandshakeTimeout is the default timeout configured
 // by NewClient in the Client.Dialer.HandshakeTimeout field.
 const DefaultWebSocketHandshakeTimeout = 7 * time.Second
 
 // LatestMeasurements contains the latest Measurement sent by the server and the client,
 // plus the latest ConnectionInfo sent by the server.
+//
+// In multi-stream tests, Server and Client aggregate all the streams and
+// ConnectionInfo has no UUID, while Streams contains the latest measurements
+// of each stream, including its UUID. The Nth element of Streams contains the
+// measurements whose Stream field is N+1. The Error field of a stream is set
+// when such stream failed to connect or failed during the test.
+//
+// Error is the error that caused the test to stop, if any, which is an
+// *Error when we can classify it. A test ended by the server with a normal
+// closure did not fail, while a test aborted because its context expired or
+// was canceled fails with the error of the context. In multi-stream tests, it
+// is the Error of the first stream and it is only set if all the streams
+// failed.
+//
+// When latency probing is enabled, Latency contains all the samples taken
+// by the latency probe, both before (idle) and during (loaded) the test.
+//
+// BudgetLimited is true when the test stopped because it transferred the
+// TestOptions.MaxBytes bytes. In multi-stream tests, it is true when any
+// of the streams did.
+//
+// Converged is true when the test stopped because its throughput converged.
+// See TestOptions.ConvergenceTolerance. In multi-stream tests, it is true
+// when any of the streams did.
+//
+// ClientLimited is true when the client process used most of the CPUs
+// available to the test, i.e., one per stream, in which case the client,
+// rather than the network, likely limited the throughput. The CPU time is
+// only available on some systems. See spec.CPUInfo.
+//
+// ConnectionTiming contains how long the client took to connect to the
+// server, even when it failed to. TLS contains the parameters negotiated
+// with the server, when using wss. In multi-stream tests, both refer to
+// the first stream, while each stream has its own.
+//
+// ClientThroughput and ServerThroughput contain the throughput measured
+// during each update interval according to the client and to the server
+// measurements, respectively. See spec.ThroughputSample for details.
 type LatestMeasurements struct {
-	Server         spec.Measurement
-	Client         spec.Measurement
-	ConnectionInfo *spec.ConnectionInfo
+	Server           spec.Measurement
+	Client           spec.Measurement
+	ConnectionInfo   *spec.ConnectionInfo
+	ConnectionTiming *spec.ConnectionTiming
+	TLS              *spec.TLSInfo
+	Streams          []*LatestMeasurements
+	Latency          []spec.LatencyInfo
+	Error            error
+	BudgetLimited    bool
+	Converged        bool
+	ClientLimited    bool
+	ClientThroughput []spec.ThroughputSample
+	ServerThroughput []spec.ThroughputSample
+
+	// serverTCPInfo is the latest TCPInfo sent by the server, since
+	// not all the server measurements contain TCPInfo.
+	serverTCPInfo *spec.TCPInfo
+
+	// test and started identify the test in the records written by
+	// Client.Recorder. See spec.TraceRecord.
+	test    spec.TestKind
+	started time.Time
+
+	// traced indicates that we recorded how connecting to the server
+	// went, which only the first record contains.
+	traced bool
+}
+
+// clone returns a copy of lm that does not share the Streams.
+func (lm *LatestMeasurements) clone() *LatestMeasurements {
+	out := *lm
+	out.Latency = append([]spec.LatencyInfo(nil), lm.Latency...)
+	out.ClientThroughput = append([]spec.ThroughputSample(nil), lm.ClientThroughput...)
+	out.ServerThroughput = append([]spec.ThroughputSample(nil), lm.ServerThroughput...)
+	if lm.Streams != nil {
+		out.Streams = make([]*LatestMeasurements, len(lm.Streams))
+		for i, s := range lm.Streams {
+			out.Streams[i] = s.clone()
+		}
+	}
+	return &out
+}
+
+// update stores m as the latest measurement for its origin. It also sets
+// the throughput of m since the previous measurement, if any.
+func (lm *LatestMeasurements) update(m *spec.Measurement) {
+	// Latency samples are not the latest measurement of the client,
+	// which contains the application level measurements.
+	if m.Latency != nil {
+		lm.Latency = append(lm.Latency, *m.Latency)
+		return
+	}
+	if m.Origin == spec.OriginServer && m.ConnectionInfo != nil {
+		// The server only sends ConnectionInfo once at the beginning of
+		// the test, thus if we want to know the client IP and test UUID
+		// we need to store it separately.
+		lm.ConnectionInfo = m.ConnectionInfo
+	}
+	if m.Origin == spec.OriginClient && m.Converged {
+		lm.Converged = true
+	}
+	lm.setLatest(m)
 }
 
 // Client is a ndt7 client.
@@ -88,9 +231,43 @@ type Client struct {
 	Dialer websocket.Dialer
 
 	// FQDN is the server FQDN currently used by the Client. The FQDN is set
-	// by Client at runtime. (read-only)
+	// by Client at runtime, thus you should not read it while another
+	// goroutine is starting a test. (read-only)
 	FQDN string
 
+	// AddressFamily forces the IP address family used to connect to the
+	// server, e.g., to measure IPv4 and IPv6 separately. The default is
+	// AddressFamilyAny. It also applies to a custom Dialer.NetDialContext,
+	// which is called with the "tcp4" or "tcp6" network.
+	AddressFamily AddressFamily
+
+	// SourceAddress is the optional local IP address used to connect to
+	// the server and to the Locate API, e.g., to use a specific uplink.
+	SourceAddress string
+
+	// Interface is the optional network interface used to connect to the
+	// server and to the Locate API. It is only supported on Linux. Like
+	// SourceAddress, it has no effect if Dialer.NetDialContext is set.
+	// Starting a test fails if either of them is not valid.
+	Interface string
+
+	// Proxy is the optional URL of the proxy used to connect to the server
+	// and to the Locate API. The http scheme uses HTTP CONNECT and the
+	// socks5 and socks5h schemes use SOCKS5, both with optional
+	// user:password credentials. The https scheme is not supported (see
+	// ErrUnsupportedProxy). When nil, the proxy is chosen using the
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables. It has
+	// no effect if Dialer.Proxy is set. The latency probes always connect
+	// directly to the server.
+	Proxy *url.URL
+
+	// TLS contains the options to verify the certificates of the server
+	// and of the Locate API, and to authenticate the client to them. For
+	// the server, they customize a copy of Dialer.TLSClientConfig, if
+	// set. Starting a test fails with ErrInvalidTLSOptions if they are
+	// not valid.
+	TLS TLSOptions
+
 	// Server is an optional server name. Client will use this target server if
 	// not empty. Takes precedence over ServiceURL and Locate API.
 	Server string
@@ -101,12 +278,47 @@ type Client struct {
 
 	// Locate is used to discover nearby healthy servers using the Locate API.
 	// NewClient defaults to the public Locate API URL. You may override it.
+	// The options about connecting, e.g., SourceAddress, Interface, Proxy
+	// and TLS, also apply to the Locate queries when Locate is a
+	// *locate.Client or a DialLocator, even if wrapped by WrappingLocators.
 	Locate Locator
 
 	// Scheme is the scheme to use with Server and Locate modes. It's set to
 	// "wss" by NewClient, change it to "ws" for unencrypted ndt7.
 	Scheme string
 
+	// DownloadStreams is the number of concurrent connections used by
+	// StartDownload. Values lower than two mean a single connection. All
+	// the streams use the same server, unless StreamsAcrossTargets is set.
+	// A failure of a single stream does not abort the test.
+	DownloadStreams int
+
+	// UploadStreams is like DownloadStreams but for StartUpload.
+	UploadStreams int
+
+	// StreamsAcrossTargets causes multi-stream tests to connect each stream
+	// to a different server returned by the Locate API. It has no effect
+	// when using Server or ServiceURL.
+	StreamsAcrossTargets bool
+
+	// ServerSelection is the strategy used to choose among the servers
+	// returned by the Locate API. The default is SelectLocateOrder.
+	ServerSelection ServerSelection
+
+	// TestOptions contains the options passed to the download and upload
+	// tests. It's set to DefaultTestOptions by NewClient; you may override
+	// it. Starting a test fails with ErrInvalidTestOptions if these
+	// options are not valid.
+	TestOptions TestOptions
+
+	// Recorder is the optional Recorder of the measurements received
+	// during the tests.
+	Recorder Recorder
+
+	// Replayer, if not nil, makes the tests replay the measurements it
+	// returns instead of connecting to a server. See Replayer.
+	Replayer Replayer
+
 	// connect is the function for connecting a specific
 	// websocket cnnection. It's set to its default value by
 	// NewClient, but you may override it.
@@ -119,11 +331,37 @@ type Client struct {
 	// upload is like download but for the upload test.
 	upload testFn
 
-	// targets and tIndex cache the results from the Locate API.
+	// recordMu serializes the calls to Recorder.Record, which we make
+	// without holding mu.
+	recordMu sync.Mutex
+
+	// locateMu serializes the queries to the Locate API, which we run
+	// without holding mu. It must be acquired before mu, if both.
+	locateMu sync.Mutex
+
+	// mu protects the fields below, as well as FQDN and Scheme, which
+	// are modified at runtime.
+	mu sync.Mutex
+
+	// targets and tIndex cache the results from the Locate API, and
+	// tExpiry contains the expiry of the access tokens of each target.
 	targets []v2.Target
 	tIndex  map[string]int
+	tExpiry []time.Time
+
+	// serverProbes contains the results of probing the targets.
+	serverProbes []spec.ServerProbe
+
+	// proxyUsed is the redacted URL of the proxy used by the last
+	// connection to the server, if any.
+	proxyUsed string
+
+	// locateTime is the duration of the latest query to the Locate API,
+	// which the next connection accounts for in its ConnectionTiming.
+	locateTime time.Duration
 
-	results map[spec.TestKind]*LatestMeasurements
+	results       map[spec.TestKind]*LatestMeasurements
+	bidirectional map[spec.TestKind]*LatestMeasurements
 }
 
 // MakeUserAgent creates the user agent string.
@@ -135,7 +373,6 @@ func MakeUserAgent(clientName, clientVersion string) string {
 // clientName and clientVersion. M-Lab services may reject requests coming
 // from clients that do not identify themselves properly.
 func NewClient(clientName, clientVersion string) *Client {
-	results := map[spec.TestKind]*LatestMeasurements{}
 	return &Client{
 		ClientName:    clientName,
 		ClientVersion: clientVersion,
@@ -152,15 +389,19 @@ func NewClient(clientName, clientVersion string) *Client {
 		Locate: locate.NewClient(
 			MakeUserAgent(clientName, clientVersion),
 		),
-		tIndex:  map[string]int{},
-		upload:  upload.Run,
-		Scheme:  "wss",
-		results: results,
+		tIndex:        map[string]int{},
+		upload:        upload.Run,
+		Scheme:        "wss",
+		TestOptions:   DefaultTestOptions(),
+		results:       map[spec.TestKind]*LatestMeasurements{},
+		bidirectional: map[spec.TestKind]*LatestMeasurements{},
 	}
 }
 
-// doConnect establishes a websocket connection.
-func (c *Client) doConnect(ctx context.Context, serviceURL string) (*websocket.Conn, error) {
+// doConnect establishes a websocket connection, whose test runs using the
+// given options.
+func (c *Client) doConnect(ctx context.Context, serviceURL string,
+	opts params.TestOptions) (*websocket.Conn, error) {
 	URL, _ := url.Parse(serviceURL)
 	q := URL.Query()
 	q.Set("client_arch", runtime.GOARCH)
@@ -169,12 +410,34 @@ func (c *Client) doConnect(ctx context.Context, serviceURL string) (*websocket.C
 	q.Set("client_name", c.ClientName)
 	q.Set("client_os", runtime.GOOS)
 	q.Set("client_version", c.ClientVersion)
+	if URL.Path == params.DownloadURLPath {
+		// Let the server stop sending when the budget of this connection
+		// allows it, rather than relying on the client closing it.
+		if v := params.EarlyExit(opts.MaxBytes); v != "" {
+			q.Set(params.EarlyExitParameterName, v)
+		}
+	}
 	URL.RawQuery = q.Encode()
 	headers := http.Header{}
 	headers.Add("Sec-WebSocket-Protocol", params.SecWebSocketProtocol)
 	headers.Add("User-Agent", MakeUserAgent(c.ClientName, c.ClientVersion))
-	conn, _, err := c.connect(c.Dialer, ctx, URL.String(), headers)
-	return conn, err
+	dialer, err := c.dialer()
+	if err != nil {
+		return nil, err
+	}
+	var proxyURL *url.URL
+	proxy := dialer.Proxy
+	dialer.Proxy = func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		proxyURL = u
+		return u, err
+	}
+	conn, resp, err := c.connect(dialer, ctx, URL.String(), headers)
+	if err != nil {
+		return nil, classifyConnect(err, resp)
+	}
+	c.setProxyUsed(proxyURL)
+	return conn, nil
 }
 
 // nextURLFromLocate returns the next URL to try from the Locate API.
@@ -182,42 +445,139 @@ func (c *Client) doConnect(ctx context.Context, serviceURL string) (*websocket.C
 // API. Subsequently, it returns the next URL from the cache.
 // If there are no more URLs to try, it returns an error.
 func (c *Client) nextURLFromLocate(ctx context.Context, p string) (string, error) {
-	if len(c.targets) == 0 {
-		targets, err := c.Locate.Nearest(ctx, "ndt/ndt7")
-		if err != nil {
-			return "", err
-		}
-		// cache targets on success.
-		c.targets = targets
+	if err := c.locate(ctx); err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.peekURLLocked(p)
+	if err != nil {
+		return "", err
+	}
+	c.tIndex[c.Scheme+"://"+p]++
+	return r, nil
+}
+
+// peekURLFromLocate is like nextURLFromLocate but does not consume the URL.
+func (c *Client) peekURLFromLocate(ctx context.Context, p string) (string, error) {
+	if err := c.locate(ctx); err != nil {
+		return "", err
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peekURLLocked(p)
+}
+
+// peekURLLocked returns the next URL to try for the URL path p among the
+// cached targets. The caller must hold c.mu.
+func (c *Client) peekURLLocked(p string) (string, error) {
 	k := c.Scheme + "://" + p
-	if c.tIndex[k] < len(c.targets) {
-		r := c.targets[c.tIndex[k]].URLs[k]
+	// Skip the targets whose access tokens are about to expire, since
+	// the server would reject them, and the targets without a URL for
+	// c.Scheme, e.g., ws-only servers of a custom Locator.
+	now := time.Now()
+	for c.tIndex[k] < len(c.targets) && (c.staleLocked(c.tIndex[k], now) ||
+		c.targets[c.tIndex[k]].URLs[k] == "") {
 		c.tIndex[k]++
-		return r, nil
+	}
+	if c.tIndex[k] < len(c.targets) {
+		return c.targets[c.tIndex[k]].URLs[k], nil
+	}
+	for _, t := range c.targets {
+		if t.URLs[k] != "" {
+			return "", ErrNoTargets
+		}
+	}
+	if len(c.targets) > 0 {
+		return "", fmt.Errorf("%w: none of the %d servers supports the %s scheme",
+			ErrNoTargets, len(c.targets), c.Scheme)
 	}
 	return "", ErrNoTargets
 }
 
-// tryConnect tries to establish a websocket connection. If successful, returns
-// a channel where measurements are written.
-func (c *Client) tryConnect(ctx context.Context, f testFn, s string) (<-chan spec.Measurement, error) {
+// tryConnect tries to establish a websocket connection with the server
+// identified by the given URL, for a test using the given options, and stores
+// into lm how long it took and, on success, the negotiated TLS parameters,
+// if any.
+func (c *Client) tryConnect(ctx context.Context, s string,
+	lm *LatestMeasurements, opts params.TestOptions) (*websocket.Conn, error) {
 	u, err := url.Parse(s)
 	if err != nil {
 		return nil, err
 	}
+	c.mu.Lock()
 	c.FQDN = u.Hostname()
-	conn, err := c.doConnect(ctx, u.String())
+	c.mu.Unlock()
+	tracer := newConnectionTracer()
+	conn, err := c.doConnect(httptrace.WithClientTrace(ctx, tracer.clientTrace()), u.String(), opts)
+	timing := tracer.timing()
+	c.mu.Lock()
+	lm.ConnectionTiming = timing
+	if err == nil {
+		lm.TLS = tlsInfo(conn)
+	}
+	c.mu.Unlock()
+	return conn, err
+}
+
+// dial discovers a server (if needed) and establishes a websocket
+// connection for the given URL path, whose test runs using the given
+// options. On success, it returns the connection and the URL of the server
+// it connected to. In any case, it stores into lm how long it took,
+// including querying the Locate API and trying multiple servers.
+func (c *Client) dial(ctx context.Context, p string,
+	lm *LatestMeasurements, opts params.TestOptions) (*websocket.Conn, string, error) {
+	defer c.finishTiming(lm, time.Now())
+	customURL, err := c.customURL(p)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	// If a custom URL was provided, use it.
+	if customURL != nil {
+		conn, err := c.tryConnect(ctx, customURL.String(), lm, opts)
+		return conn, customURL.String(), err
+	}
+
+	// If we have no URLs, use the Locate API. In case of failure, try the next
+	// URL until there are no more URLs available, in which case we also return
+	// the error that occurred with the last one.
+	var lastErr error
+	for {
+		s, err := c.nextURLFromLocate(ctx, p)
+		if errors.Is(err, ErrNoTargets) && lastErr != nil {
+			return nil, "", fmt.Errorf("%w: %w", err, lastErr)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		conn, err := c.tryConnect(ctx, s, lm, opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return conn, s, nil
 	}
-	ch := make(chan spec.Measurement)
-	go c.collectData(ctx, f, conn, ch)
-	return ch, nil
 }
 
-// start is the function for starting a test.
-func (c *Client) start(ctx context.Context, f testFn, p string) (<-chan spec.Measurement, error) {
+// finishTiming sets the Locate and Total phases of the ConnectionTiming of
+// lm, once dial, which started at the given time, is over.
+func (c *Client) finishTiming(lm *LatestMeasurements, start time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if lm.ConnectionTiming == nil {
+		lm.ConnectionTiming = &spec.ConnectionTiming{}
+	}
+	lm.ConnectionTiming.Locate = c.locateTime.Microseconds()
+	lm.ConnectionTiming.Total = time.Since(start).Microseconds()
+	c.locateTime = 0
+}
+
+// customURL returns the URL for the given URL path when the user has
+// configured either Server or ServiceURL, and nil otherwise.
+func (c *Client) customURL(p string) (*url.URL, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	var customURL *url.URL
 	// Either the server or service url fields override the Locate API.
 	// First check for the server.
@@ -236,47 +596,100 @@ func (c *Client) start(ctx context.Context, f testFn, p string) (<-chan spec.Mea
 	} else if c.ServiceURL != nil {
 		return nil, ErrServiceUnsupported
 	}
+	return customURL, nil
+}
 
-	// If a custom URL was provided, use it.
-	if customURL != nil {
-		return c.tryConnect(ctx, f, customURL.String())
+// validate returns an error if the Client options are not valid.
+func (c *Client) validate() error {
+	if err := c.TestOptions.Validate(); err != nil {
+		return err
 	}
+	if err := c.validateProxy(); err != nil {
+		return err
+	}
+	if err := c.validateTLS(); err != nil {
+		return err
+	}
+	return c.validateDial()
+}
 
-	// If we have no URLs, use the Locate API. In case of failure, try the next
-	// URL until there are no more URLs available.
-	for {
-		s, err := c.nextURLFromLocate(ctx, p)
-		if err != nil {
-			return nil, err
-		}
-		ch, err := c.tryConnect(ctx, f, s)
-		if err != nil {
-			continue
-		}
-		return ch, nil
+// start is the function for starting a test. The measurements are
+// stored into lm as they arrive.
+func (c *Client) start(ctx context.Context, f testFn, p string,
+	test spec.TestKind, lm *LatestMeasurements) (<-chan spec.Measurement, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	c.refreshTargets(p)
+	idle := c.probeIdle(ctx, p, c.TestOptions)
+	conn, u, err := c.dial(ctx, p, lm, c.TestOptions)
+	if err != nil {
+		return nil, err
 	}
+	ch := make(chan spec.Measurement)
+	go c.collectData(ctx, f, conn, lm, ch, c.TestOptions, 1)
+	return c.probeLoaded(ctx, u, test, idle, lm, ch, c.TestOptions), nil
 }
 
-func (c *Client) collectData(ctx context.Context, f testFn, conn websocketx.Conn, outch chan<- spec.Measurement) {
+// collectData runs f and stores the measurements into lm, as well as the
+// error that caused f to stop, if any. The given number of streams, e.g.,
+// two for the bidirectional test, run at the same time in the process,
+// whose CPU time they share.
+func (c *Client) collectData(ctx context.Context, f testFn, conn websocketx.Conn,
+	lm *LatestMeasurements, outch chan<- spec.Measurement, opts params.TestOptions,
+	streams int) {
 	inch := make(chan spec.Measurement)
 	defer close(outch)
-	go f(ctx, conn, inch)
+	errch := make(chan error, 1)
+	go func() {
+		errch <- f(ctx, conn, inch, opts)
+	}()
 
 	for m := range inch {
-		switch m.Origin {
-		case spec.OriginClient:
-			c.results[m.Test].Client = m
-		case spec.OriginServer:
-			// The server only sends ConnectionInfo once at the beginning of
-			// the test, thus if we want to know the client IP and test UUID
-			// we need to store it separately.
-			if m.ConnectionInfo != nil {
-				c.results[m.Test].ConnectionInfo = m.ConnectionInfo
-			}
-			c.results[m.Test].Server = m
-		}
+		c.mu.Lock()
+		lm.update(&m)
+		r := c.traceRecord(lm, &m)
+		c.mu.Unlock()
+		c.record(r)
 		outch <- m
 	}
+	err := classify(streamError(ctx, <-errch), "")
+	c.mu.Lock()
+	lm.Error = err
+	lm.BudgetLimited = budgetLimited(lm, opts)
+	lm.ClientLimited = clientLimited(lm, streams)
+	c.mu.Unlock()
+}
+
+// budgetLimited returns whether the latest client measurement of lm shows
+// that the test transferred opts.MaxBytes bytes.
+func budgetLimited(lm *LatestMeasurements, opts params.TestOptions) bool {
+	return opts.MaxBytes > 0 && lm.Client.AppInfo != nil &&
+		lm.Client.AppInfo.NumBytes >= opts.MaxBytes
+}
+
+// clientLimited returns whether the latest client measurement of lm shows
+// that the client process used most of the CPUs available to the given
+// number of streams, each of which is mostly processed by a goroutine.
+func clientLimited(lm *LatestMeasurements, streams int) bool {
+	info := lm.Client.CPUInfo
+	if info == nil || info.ElapsedTime < params.ClientLimitedMinDuration.Microseconds() {
+		return false
+	}
+	cpus := min(max(streams, 1), runtime.NumCPU())
+	return float64(info.CPUTime) >= params.ClientLimitedUtilization*
+		float64(cpus)*float64(info.ElapsedTime)
+}
+
+// newResults replaces the results of the given test in the given map and
+// returns the new, empty, LatestMeasurements.
+func (c *Client) newResults(results map[spec.TestKind]*LatestMeasurements,
+	test spec.TestKind) *LatestMeasurements {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lm := &LatestMeasurements{test: test, started: time.Now()}
+	results[test] = lm
+	return lm
 }
 
 // StartDownload discovers a ndt7 server (if needed) and starts a download. On
@@ -285,18 +698,62 @@ func (c *Client) collectData(ctx context.Context, f testFn, conn websocketx.Conn
 // should not attempt using the channel. A side effect of starting the download
 // is that, if you did not specify a server FQDN, we will discover a server
 // for you and store that value into the c.FQDN field.
+//
+// If c.DownloadStreams is greater than one, the download uses as many
+// concurrent connections. In such case, the channel carries the measurements
+// of each stream, tagged with their Stream number, as well as measurements
+// aggregating all the streams, whose Stream number is zero.
+//
+// If c.TestOptions.ProbeInterval is positive, the channel also carries the
+// samples of the latency probe, which have a non-nil Latency field. The
+// probe takes some samples before the download starts, and then a sample
+// every ProbeInterval while the download is running.
+//
+// If c.Replayer is not nil, we do not connect to any server and replay the
+// next download it returns instead. See Replayer.
 func (c *Client) StartDownload(ctx context.Context) (<-chan spec.Measurement, error) {
-	c.results[spec.TestDownload] = &LatestMeasurements{}
-	return c.start(ctx, c.download, params.DownloadURLPath)
+	lm := c.newResults(c.results, spec.TestDownload)
+	if c.Replayer != nil {
+		return c.replay(spec.TestDownload,
+			map[spec.TestKind]*LatestMeasurements{spec.TestDownload: lm})
+	}
+	if c.DownloadStreams > 1 {
+		return c.startStreams(ctx, c.download, params.DownloadURLPath,
+			spec.TestDownload, lm, c.DownloadStreams)
+	}
+	return c.start(ctx, c.download, params.DownloadURLPath, spec.TestDownload, lm)
 }
 
-// StartUpload is like StartDownload but for the upload.
+// StartUpload is like StartDownload but for the upload. The number of
+// concurrent connections is controlled by c.UploadStreams. The aggregate
+// server measurements sum the bytes received by the server on each stream.
 func (c *Client) StartUpload(ctx context.Context) (<-chan spec.Measurement, error) {
-	c.results[spec.TestUpload] = &LatestMeasurements{}
-	return c.start(ctx, c.upload, params.UploadURLPath)
+	lm := c.newResults(c.results, spec.TestUpload)
+	if c.Replayer != nil {
+		return c.replay(spec.TestUpload,
+			map[spec.TestKind]*LatestMeasurements{spec.TestUpload: lm})
+	}
+	if c.UploadStreams > 1 {
+		return c.startStreams(ctx, c.upload, params.UploadURLPath,
+			spec.TestUpload, lm, c.UploadStreams)
+	}
+	return c.start(ctx, c.upload, params.UploadURLPath, spec.TestUpload, lm)
 }
 
-// Results returns the test results map.
+// Results returns the test results map. The map is a snapshot of the
+// results at the time of the call, so it's safe to call Results while
+// tests are running.
 func (c *Client) Results() map[spec.TestKind]*LatestMeasurements {
-	return c.results
+	return c.snapshot(c.results)
+}
+
+// snapshot returns a copy of the given results map.
+func (c *Client) snapshot(results map[spec.TestKind]*LatestMeasurements) map[spec.TestKind]*LatestMeasurements {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[spec.TestKind]*LatestMeasurements, len(results))
+	for k, lm := range results {
+		out[k] = lm.clone()
+	}
+	return out
 }
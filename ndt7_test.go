@@ -2,10 +2,13 @@ package ndt7
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/url"
 	"os"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/m-lab/go/testingx"
@@ -15,6 +18,7 @@ import (
 	"github.com/m-lab/ndt-server/ndt7/ndt7test"
 	"github.com/m-lab/ndt7-client-go/internal/params"
 	"github.com/m-lab/ndt7-client-go/internal/websocketx"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
 	"github.com/m-lab/ndt7-client-go/spec"
 )
 
@@ -29,7 +33,12 @@ func (x *f) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
 	return []v2.Target{
 		{
 			Machine: "127.0.0.1",
-			URLs:    map[string]string{},
+			URLs: map[string]string{
+				"ws:///ndt/v7/download":  "ws://127.0.0.1/ndt/v7/download",
+				"ws:///ndt/v7/upload":    "ws://127.0.0.1/ndt/v7/upload",
+				"wss:///ndt/v7/download": "wss://127.0.0.1/ndt/v7/download",
+				"wss:///ndt/v7/upload":   "wss://127.0.0.1/ndt/v7/upload",
+			},
 		},
 	}, nil
 }
@@ -48,6 +57,7 @@ func newMockedClient() *Client {
 	// Override the download function to basically do nothing
 	client.download = func(
 		ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+		opts params.TestOptions,
 	) error {
 		close(ch)
 		// Note that we cannot close the websocket connection because
@@ -78,6 +88,15 @@ func TestUploadCase(t *testing.T) {
 	}
 }
 
+func TestStartInvalidTestOptions(t *testing.T) {
+	client := newMockedClient()
+	client.TestOptions.DownloadDuration = 0
+	_, err := client.StartDownload(context.Background())
+	if !errors.Is(err, ErrInvalidTestOptions) {
+		t.Fatalf("expected ErrInvalidTestOptions, got %v", err)
+	}
+}
+
 func TestStartDiscoverServerError(t *testing.T) {
 	ctx := context.Background()
 	client := NewClient(clientName, clientVersion)
@@ -85,7 +104,7 @@ func TestStartDiscoverServerError(t *testing.T) {
 	l := locate.NewClient(MakeUserAgent(clientName, clientVersion))
 	l.BaseURL = badURL
 	client.Locate = l // cause URL parse to fail
-	_, err := client.start(ctx, nil, "")
+	_, err := client.start(ctx, nil, "", spec.TestDownload, &LatestMeasurements{})
 	if err == nil {
 		t.Fatal("We expected an error here")
 	}
@@ -95,7 +114,7 @@ func TestStartConnectError(t *testing.T) {
 	ctx := context.Background()
 	client := NewClient(clientName, clientVersion)
 	client.Server = "\t" // cause URL parse to fail
-	_, err := client.start(ctx, nil, params.DownloadURLPath)
+	_, err := client.start(ctx, nil, params.DownloadURLPath, spec.TestDownload, &LatestMeasurements{})
 	if err == nil {
 		t.Fatal("We expected an error here")
 	}
@@ -228,7 +247,7 @@ func TestIntegrationDownloadServiceURL(t *testing.T) {
 	})
 }
 
-func TestDownloadNoTargets(t *testing.T) {
+func TestDownloadExhaustedTargets(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping test in short mode")
 	}
@@ -256,11 +275,16 @@ func TestDownloadNoTargets(t *testing.T) {
 	if tot <= 0 {
 		t.Fatal("Expected at least a measurement")
 	}
-	// Second attempt should return ErrNoTargets since all the available
-	// servers have been tried.
-	_, err = client.StartDownload(context.Background())
-	if err != ErrNoTargets {
-		t.Fatalf("Expected no target error: %v", err)
+	// Since all the available servers have been tried, the second attempt
+	// should query Locate again and succeed again using the second URL.
+	ch, err = client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to download second attempt")
+	tot = 0
+	for range ch {
+		tot++
+	}
+	if tot <= 0 {
+		t.Fatal("Expected at least a measurement")
 	}
 }
 
@@ -288,3 +312,65 @@ func TestUploadBadConnection(t *testing.T) {
 		t.Fatal("expected error downloading from closed ndt7test server")
 	}
 }
+
+func TestClientTCPInfo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("TCP_INFO is only supported on Linux")
+	}
+	s := testserver.NewServer(testserver.Config{
+		Duration: 500 * time.Millisecond,
+	})
+	defer s.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	for _, start := range []func(context.Context) (<-chan spec.Measurement, error){
+		client.StartDownload, client.StartUpload,
+	} {
+		ch, err := start(context.Background())
+		testingx.Must(t, err, "failed to start test")
+		for m := range ch {
+			if m.Origin == spec.OriginClient && m.TCPInfo == nil {
+				t.Fatalf("missing client TCPInfo: %+v", m)
+			}
+		}
+	}
+	ul := client.Results()[spec.TestUpload]
+	if ul.Client.TCPInfo == nil || ul.Client.TCPInfo.BytesSent <= 0 {
+		t.Fatal("expected the client to have sent bytes")
+	}
+}
+
+func TestEarlyExitParameter(t *testing.T) {
+	tests := []struct {
+		maxBytes int64
+		streams  int
+		path     string
+		want     string
+	}{
+		{0, 1, params.DownloadURLPath, ""},
+		{250 * 1000 * 1000, 1, params.DownloadURLPath, "250"},
+		{250 * 1000 * 1000, 2, params.DownloadURLPath, ""},
+		{250 * 1000 * 1000, 1, params.UploadURLPath, ""},
+	}
+	for _, tt := range tests {
+		client := newMockedClient()
+		client.TestOptions.MaxBytes = tt.maxBytes
+		var got string
+		client.connect = func(
+			dialer websocket.Dialer, ctx context.Context, urlStr string,
+			requestHeader http.Header) (*websocket.Conn, *http.Response, error,
+		) {
+			u, err := url.Parse(urlStr)
+			testingx.Must(t, err, "failed to parse URL")
+			got = u.Query().Get(params.EarlyExitParameterName)
+			return &websocket.Conn{}, &http.Response{}, nil
+		}
+		opts := streamOptions(client.TestOptions, tt.streams)
+		_, err := client.doConnect(context.Background(), "ws://127.0.0.1"+tt.path, opts)
+		testingx.Must(t, err, "failed to connect")
+		if got != tt.want {
+			t.Errorf("%+v: got %q", tt, got)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+// Package ndt7test contains an in-process ndt7 server and a fake Locator
+// for testing code that uses the ndt7 client.
+//
+// The server listens on the loopback interface and speaks the ndt7 protocol
+// on the /ndt/v7/download and /ndt/v7/upload URL paths. Its behavior is
+// controlled by a Config, which allows to shape the download rate and the
+// test duration, and to inject failures such as rejecting the handshake,
+// closing the connection in the middle of a test and sending malformed
+// JSON measurements.
+package ndt7test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+const (
+	// DefaultDuration is the default duration of each test.
+	DefaultDuration = time.Second
+
+	// DefaultMessageSize is the default size of download messages.
+	DefaultMessageSize = 1 << 13
+
+	// DefaultMeasurementInterval is the default interval between
+	// counterflow measurements.
+	DefaultMeasurementInterval = 250 * time.Millisecond
+
+	// maxMessageSize is the maximum message size accepted when uploading.
+	maxMessageSize = 1 << 24
+)
+
+// errClientClosed indicates that the client closed the connection.
+var errClientClosed = errors.New("client closed the connection")
+
+// Config configures the behavior of a Server.
+type Config struct {
+	// Rate is the download rate in bytes per second. Zero means that the
+	// server sends as fast as possible.
+	Rate int64
+
+	// Duration is the duration of each test. Zero means DefaultDuration.
+	Duration time.Duration
+
+	// MessageSize is the size of the binary messages sent during the
+	// download. Zero means DefaultMessageSize.
+	MessageSize int
+
+	// MeasurementInterval is the interval between the counterflow
+	// measurements sent to the client. Zero means DefaultMeasurementInterval.
+	MeasurementInterval time.Duration
+
+	// MinRTT is the MinRTT reported by the counterflow TCPInfo.
+	MinRTT time.Duration
+
+	// RejectStatus, if not zero, is the HTTP status code the server uses
+	// to reject the WebSocket handshake.
+	RejectStatus int
+
+	// CloseAfter, if not zero, causes the server to abruptly close the
+	// connection, without sending a close frame, after this time.
+	CloseAfter time.Duration
+
+	// MalformedJSON causes the server to send invalid JSON instead of
+	// the counterflow measurements.
+	MalformedJSON bool
+}
+
+// Server is an in-process ndt7 server.
+type Server struct {
+	// URL is the base URL of the server, i.e., http://ipaddr:port with
+	// no trailing slash, or https://ipaddr:port for TLS servers.
+	URL string
+
+	config Config
+	conns  atomic.Int64
+	srv    *httptest.Server
+}
+
+// NewServer starts and returns a new Server. The caller should call
+// Close when finished, to shut it down.
+func NewServer(config Config) *Server {
+	s := newServer(config)
+	s.srv = httptest.NewServer(s.handler())
+	s.URL = s.srv.URL
+	return s
+}
+
+// NewTLSServer is like NewServer but starts a server using TLS. Use the
+// ClientTLSConfig method to obtain a configuration trusting the server.
+func NewTLSServer(config Config) *Server {
+	s := newServer(config)
+	s.srv = httptest.NewTLSServer(s.handler())
+	s.URL = s.srv.URL
+	return s
+}
+
+func newServer(config Config) *Server {
+	if config.Duration <= 0 {
+		config.Duration = DefaultDuration
+	}
+	if config.MessageSize <= 0 {
+		config.MessageSize = DefaultMessageSize
+	}
+	if config.MeasurementInterval <= 0 {
+		config.MeasurementInterval = DefaultMeasurementInterval
+	}
+	return &Server{config: config}
+}
+
+// Close shuts down the server and blocks until all outstanding requests
+// on this server have completed.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Host returns the host:port of the server.
+func (s *Server) Host() string {
+	return s.srv.Listener.Addr().String()
+}
+
+// Scheme returns the WebSocket scheme to use with the server, i.e., "ws"
+// or "wss" for TLS servers.
+func (s *Server) Scheme() string {
+	if s.srv.TLS != nil {
+		return "wss"
+	}
+	return "ws"
+}
+
+// DownloadURL returns the URL of the download service.
+func (s *Server) DownloadURL() *url.URL {
+	return &url.URL{Scheme: s.Scheme(), Host: s.Host(), Path: params.DownloadURLPath}
+}
+
+// UploadURL returns the URL of the upload service.
+func (s *Server) UploadURL() *url.URL {
+	return &url.URL{Scheme: s.Scheme(), Host: s.Host(), Path: params.UploadURLPath}
+}
+
+// Connections returns the number of WebSocket connections accepted so far.
+func (s *Server) Connections() int64 {
+	return s.conns.Load()
+}
+
+// ClientTLSConfig returns a TLS configuration trusting the certificate of
+// a server created using NewTLSServer. It returns nil for other servers.
+func (s *Server) ClientTLSConfig() *tls.Config {
+	cert := s.srv.Certificate()
+	if cert == nil {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{RootCAs: pool}
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(params.DownloadURLPath, s.serve(spec.TestDownload))
+	mux.HandleFunc(params.UploadURLPath, s.serve(spec.TestUpload))
+	return mux
+}
+
+func (s *Server) serve(test spec.TestKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.RejectStatus != 0 {
+			http.Error(w, http.StatusText(s.config.RejectStatus), s.config.RejectStatus)
+			return
+		}
+		if r.Header.Get("Sec-WebSocket-Protocol") != params.SecWebSocketProtocol {
+			http.Error(w, "missing or invalid Sec-WebSocket-Protocol", http.StatusBadRequest)
+			return
+		}
+		headers := http.Header{}
+		headers.Add("Sec-WebSocket-Protocol", params.SecWebSocketProtocol)
+		upgrader := websocket.Upgrader{
+			ReadBufferSize:  1 << 17,
+			WriteBufferSize: 1 << 17,
+		}
+		conn, err := upgrader.Upgrade(w, r, headers)
+		if err != nil {
+			return // the upgrader already replied to the client
+		}
+		defer conn.Close()
+		id := s.conns.Add(1)
+		ci := &spec.ConnectionInfo{
+			Client:    r.RemoteAddr,
+			Server:    conn.LocalAddr().String(),
+			UUID:      fmt.Sprintf("ndt7test-%d", id),
+			StartTime: time.Now(),
+		}
+		s.run(conn, test, ci)
+	}
+}
+
+// run runs the test over conn until the configured duration expires.
+func (s *Server) run(conn *websocket.Conn, test spec.TestKind, ci *spec.ConnectionInfo) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Duration)
+	defer cancel()
+	if s.config.CloseAfter > 0 {
+		timer := time.AfterFunc(s.config.CloseAfter, func() {
+			conn.NetConn().Close()
+		})
+		defer timer.Stop()
+	}
+	conn.SetReadLimit(maxMessageSize)
+	var received atomic.Int64
+	var err error
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			mtype, r, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+			n, err := io.Copy(io.Discard, r)
+			if err != nil {
+				return
+			}
+			if mtype == websocket.BinaryMessage {
+				received.Add(n)
+			}
+		}
+	}()
+	if err := s.writeMeasurement(conn, spec.Measurement{ConnectionInfo: ci}); err != nil {
+		return
+	}
+	ticker := time.NewTicker(s.config.MeasurementInterval)
+	defer ticker.Stop()
+	if test == spec.TestDownload {
+		err = s.download(ctx, conn, start, ticker, readDone)
+	} else {
+		err = s.upload(ctx, conn, start, ticker, readDone, &received)
+	}
+	if err != nil {
+		return
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(params.IOTimeout))
+	select {
+	case <-readDone:
+	case <-time.After(params.IOTimeout):
+	}
+}
+
+// download sends binary messages until ctx is done, interleaving them with
+// counterflow measurements.
+func (s *Server) download(ctx context.Context, conn *websocket.Conn, start time.Time,
+	ticker *time.Ticker, readDone <-chan struct{}) error {
+	message, err := websocket.NewPreparedMessage(
+		websocket.BinaryMessage, make([]byte, s.config.MessageSize))
+	if err != nil {
+		return err
+	}
+	var sent int64
+	for ctx.Err() == nil {
+		select {
+		case <-readDone:
+			return errClientClosed
+		case <-ticker.C:
+			m := s.measurement(spec.TestDownload, time.Since(start), sent)
+			if err := s.writeMeasurement(conn, m); err != nil {
+				return err
+			}
+		default:
+		}
+		conn.SetWriteDeadline(time.Now().Add(params.IOTimeout))
+		if err := conn.WritePreparedMessage(message); err != nil {
+			return err
+		}
+		sent += int64(s.config.MessageSize)
+		s.throttle(ctx, start, sent)
+	}
+	return nil
+}
+
+// upload sends counterflow measurements until ctx is done, while the
+// reader goroutine counts the received bytes.
+func (s *Server) upload(ctx context.Context, conn *websocket.Conn, start time.Time,
+	ticker *time.Ticker, readDone <-chan struct{}, received *atomic.Int64) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-readDone:
+			return errClientClosed
+		case <-ticker.C:
+			m := s.measurement(spec.TestUpload, time.Since(start), received.Load())
+			if err := s.writeMeasurement(conn, m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// throttle sleeps as needed to keep the download rate below the configured
+// rate, if any. It returns earlier if ctx is done.
+func (s *Server) throttle(ctx context.Context, start time.Time, sent int64) {
+	if s.config.Rate <= 0 {
+		return
+	}
+	expected := time.Duration(float64(sent) / float64(s.config.Rate) * float64(time.Second))
+	if wait := expected - time.Since(start); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// measurement returns a counterflow measurement. The TCPInfo is synthesized
+// from the bytes sent (download) or received (upload) by the server.
+func (s *Server) measurement(test spec.TestKind, elapsed time.Duration,
+	numBytes int64) spec.Measurement {
+	us := int64(elapsed / time.Microsecond)
+	tcpInfo := &spec.TCPInfo{ElapsedTime: us}
+	tcpInfo.MinRTT = uint32(s.config.MinRTT / time.Microsecond)
+	tcpInfo.RTT = tcpInfo.MinRTT
+	if test == spec.TestDownload {
+		tcpInfo.BytesSent = numBytes
+		tcpInfo.BytesAcked = numBytes
+	} else {
+		tcpInfo.BytesReceived = numBytes
+	}
+	return spec.Measurement{
+		AppInfo: &spec.AppInfo{
+			ElapsedTime: us,
+			NumBytes:    numBytes,
+		},
+		TCPInfo: tcpInfo,
+	}
+}
+
+// writeMeasurement sends m to the client as a textual message, unless the
+// server is configured to send malformed JSON.
+func (s *Server) writeMeasurement(conn *websocket.Conn, m spec.Measurement) error {
+	conn.SetWriteDeadline(time.Now().Add(params.IOTimeout))
+	if s.config.MalformedJSON {
+		return conn.WriteMessage(websocket.TextMessage, []byte("{"))
+	}
+	return conn.WriteJSON(m)
+}
+
+// Locator is a fake ndt7.Locator returning a fixed list of targets.
+type Locator struct {
+	// Targets is the list of targets returned by Nearest.
+	Targets []v2.Target
+
+	// Err, if not nil, is the error returned by Nearest.
+	Err error
+
+	// Calls is the number of times Nearest has been called.
+	Calls atomic.Int64
+}
+
+// NewLocator returns a Locator returning the given servers, in order. Like
+// the Locate API, the Machine and Hostname of each target do not contain
+// the port, which is only in the URLs.
+func NewLocator(servers ...*Server) *Locator {
+	l := &Locator{}
+	for _, s := range servers {
+		download, upload := s.DownloadURL(), s.UploadURL()
+		host := s.Host()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		l.Targets = append(l.Targets, v2.Target{
+			Machine:  host,
+			Hostname: host,
+			URLs: map[string]string{
+				s.Scheme() + "://" + params.DownloadURLPath: download.String(),
+				s.Scheme() + "://" + params.UploadURLPath:   upload.String(),
+			},
+		})
+	}
+	return l
+}
+
+// Nearest returns l.Targets, or l.Err if not nil.
+func (l *Locator) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	l.Calls.Add(1)
+	if l.Err != nil {
+		return nil, l.Err
+	}
+	return l.Targets, nil
+}
@@ -0,0 +1,187 @@
+package ndt7test_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/ndt7-client-go"
+	"github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+const (
+	clientName    = "ndt7test-tests"
+	clientVersion = "0.1.0"
+)
+
+// newClient returns a client using the given server through a fake Locator.
+func newClient(s *ndt7test.Server) *ndt7.Client {
+	c := ndt7.NewClient(clientName, clientVersion)
+	c.Locate = ndt7test.NewLocator(s)
+	c.Scheme = s.Scheme()
+	c.Dialer.TLSClientConfig = s.ClientTLSConfig()
+	return c
+}
+
+// drain reads all the measurements from ch and returns them.
+func drain(ch <-chan spec.Measurement) []spec.Measurement {
+	var all []spec.Measurement
+	for m := range ch {
+		all = append(all, m)
+	}
+	return all
+}
+
+func TestDownload(t *testing.T) {
+	s := ndt7test.NewServer(ndt7test.Config{
+		Duration: 500 * time.Millisecond,
+		MinRTT:   10 * time.Millisecond,
+	})
+	defer s.Close()
+	c := newClient(s)
+	ch, err := c.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	drain(ch)
+	dl := c.Results()[spec.TestDownload]
+	if dl.ConnectionInfo == nil || dl.ConnectionInfo.UUID != "ndt7test-1" {
+		t.Fatalf("unexpected ConnectionInfo: %+v", dl.ConnectionInfo)
+	}
+	if dl.Client.AppInfo == nil || dl.Client.AppInfo.NumBytes <= 0 {
+		t.Fatal("expected client measurements")
+	}
+	if dl.Server.TCPInfo == nil || dl.Server.TCPInfo.MinRTT != 10000 {
+		t.Fatalf("unexpected TCPInfo: %+v", dl.Server.TCPInfo)
+	}
+}
+
+func TestDownloadRate(t *testing.T) {
+	const rate = 1 << 20
+	s := ndt7test.NewServer(ndt7test.Config{
+		Rate:     rate,
+		Duration: time.Second,
+	})
+	defer s.Close()
+	c := newClient(s)
+	ch, err := c.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	drain(ch)
+	ai := c.Results()[spec.TestDownload].Client.AppInfo
+	if ai == nil {
+		t.Fatal("expected client measurements")
+	}
+	measured := float64(ai.NumBytes) / (float64(ai.ElapsedTime) / 1e06)
+	if measured > 1.5*rate {
+		t.Fatalf("the server did not honor the rate: %f bytes/s", measured)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	s := ndt7test.NewServer(ndt7test.Config{
+		Duration: 500 * time.Millisecond,
+	})
+	defer s.Close()
+	c := newClient(s)
+	ch, err := c.StartUpload(context.Background())
+	testingx.Must(t, err, "failed to start upload")
+	drain(ch)
+	ul := c.Results()[spec.TestUpload]
+	if ul.Server.TCPInfo == nil || ul.Server.TCPInfo.BytesReceived <= 0 {
+		t.Fatalf("unexpected TCPInfo: %+v", ul.Server.TCPInfo)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	s := ndt7test.NewTLSServer(ndt7test.Config{
+		Duration: 250 * time.Millisecond,
+	})
+	defer s.Close()
+	if s.Scheme() != "wss" {
+		t.Fatal("unexpected scheme")
+	}
+	c := newClient(s)
+	ch, err := c.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	drain(ch)
+	if s.Connections() != 1 {
+		t.Fatal("expected exactly one connection")
+	}
+}
+
+func TestRejectHandshake(t *testing.T) {
+	s := ndt7test.NewServer(ndt7test.Config{
+		RejectStatus: http.StatusForbidden,
+	})
+	defer s.Close()
+	c := ndt7.NewClient(clientName, clientVersion)
+	c.ServiceURL = s.DownloadURL()
+	_, err := c.StartDownload(context.Background())
+	if !errors.Is(err, websocket.ErrBadHandshake) {
+		t.Fatalf("expected a bad handshake, got %v", err)
+	}
+	if s.Connections() != 0 {
+		t.Fatal("the server accepted a connection")
+	}
+}
+
+func TestCloseAfter(t *testing.T) {
+	s := ndt7test.NewServer(ndt7test.Config{
+		Duration:   5 * time.Second,
+		CloseAfter: 250 * time.Millisecond,
+	})
+	defer s.Close()
+	c := newClient(s)
+	start := time.Now()
+	ch, err := c.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	drain(ch)
+	if time.Since(start) >= 5*time.Second {
+		t.Fatal("the server did not close the connection early")
+	}
+}
+
+func TestMalformedJSON(t *testing.T) {
+	s := ndt7test.NewServer(ndt7test.Config{
+		Duration:      5 * time.Second,
+		MalformedJSON: true,
+	})
+	defer s.Close()
+	c := newClient(s)
+	ch, err := c.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	for m := range ch {
+		if m.Origin == spec.OriginServer {
+			t.Fatal("did not expect server measurements")
+		}
+	}
+}
+
+func TestLocator(t *testing.T) {
+	first := ndt7test.NewServer(ndt7test.Config{})
+	defer first.Close()
+	second := ndt7test.NewServer(ndt7test.Config{})
+	defer second.Close()
+	l := ndt7test.NewLocator(first, second)
+	targets, err := l.Nearest(context.Background(), "ndt/ndt7")
+	testingx.Must(t, err, "failed to locate")
+	if len(targets) != 2 {
+		t.Fatal("expected two targets")
+	}
+	if targets[1].URLs["ws:///ndt/v7/upload"] != second.UploadURL().String() {
+		t.Fatalf("unexpected upload URL: %+v", targets[1].URLs)
+	}
+	if targets[0].Machine != "127.0.0.1" || targets[0].Hostname != "127.0.0.1" {
+		t.Fatalf("expected the host without the port: %+v", targets[0])
+	}
+	l.Err = errors.New("mocked error")
+	if _, err := l.Nearest(context.Background(), "ndt/ndt7"); err != l.Err {
+		t.Fatal("not the error we expected")
+	}
+	if l.Calls.Load() != 2 {
+		t.Fatal("unexpected number of calls")
+	}
+}
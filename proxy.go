@@ -0,0 +1,85 @@
+package ndt7
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ErrUnsupportedProxy is returned if the proxy uses a scheme other than
+// http, socks5 or socks5h. In particular, we do not support https proxies,
+// i.e., connecting to the proxy using TLS, since the websocket library
+// would send the CONNECT request to such proxies in cleartext.
+var ErrUnsupportedProxy = errors.New("unsupported proxy scheme")
+
+// proxyFunc is the type of the function used to choose the proxy
+// for a request, as used by websocket.Dialer and http.Transport.
+type proxyFunc = func(*http.Request) (*url.URL, error)
+
+// validateProxy returns an error if c.Proxy is not supported.
+func (c *Client) validateProxy() error {
+	if c.Proxy == nil {
+		return nil
+	}
+	return checkProxy(c.Proxy)
+}
+
+// checkProxy returns an error wrapping ErrUnsupportedProxy if we cannot
+// connect through the proxy at u.
+func checkProxy(u *url.URL) error {
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+		return nil
+	case "https":
+		return fmt.Errorf("%w: %s (connecting to the proxy using TLS is not "+
+			"supported, use the http scheme instead)", ErrUnsupportedProxy, u.Redacted())
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedProxy, u.Redacted())
+}
+
+// proxy returns the function used to choose the proxy for both the
+// Locate API and the ndt7 connections, which is the one used by c.Dialer,
+// if set, c.Proxy, if set, or the one reading the HTTPS_PROXY, HTTP_PROXY
+// and NO_PROXY environment variables. Unlike http.ProxyFromEnvironment, we
+// read the environment every time, so changes apply to the next test, and
+// fail with ErrUnsupportedProxy if it configures an unsupported proxy.
+func (c *Client) proxy() proxyFunc {
+	if c.Dialer.Proxy != nil {
+		return c.Dialer.Proxy
+	}
+	if c.Proxy != nil {
+		return http.ProxyURL(c.Proxy)
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := httpproxy.FromEnvironment().ProxyFunc()(req.URL)
+		if err != nil || u == nil {
+			return u, err
+		}
+		if err := checkProxy(u); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+}
+
+// ProxyUsed returns the URL of the proxy used by the last connection to
+// the server, without its credentials, or an empty string if the client
+// connected directly.
+func (c *Client) ProxyUsed() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.proxyUsed
+}
+
+// setProxyUsed records the proxy used by the last connection.
+func (c *Client) setProxyUsed(u *url.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.proxyUsed = ""
+	if u != nil {
+		c.proxyUsed = u.Redacted()
+	}
+}
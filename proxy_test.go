@@ -0,0 +1,291 @@
+package ndt7
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/locate/api/locate"
+	"github.com/m-lab/locate/locatetest"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+)
+
+// testProxy is an HTTP proxy supporting CONNECT and plain requests, and
+// requiring the "user:pass" credentials.
+type testProxy struct {
+	connects int32
+	requests int32
+}
+
+func (p *testProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	if r.Header.Get("Proxy-Authorization") != auth {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+	if r.Method != http.MethodConnect {
+		atomic.AddInt32(&p.requests, 1)
+		r.RequestURI = ""
+		r.Header.Del("Proxy-Authorization")
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	atomic.AddInt32(&p.connects, 1)
+	upstream, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	pipe(conn, upstream)
+}
+
+// pipe copies data between a and b until either of them is closed.
+func pipe(a, b net.Conn) {
+	defer a.Close()
+	defer b.Close()
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// serveSOCKS5 serves the SOCKS5 CONNECT command without authentication
+// on l until it is closed, and counts the connections into n.
+func serveSOCKS5(l net.Listener, n *int32) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			upstream, err := socks5Handshake(conn)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			atomic.AddInt32(n, 1)
+			pipe(conn, upstream)
+		}()
+	}
+}
+
+func socks5Handshake(conn net.Conn) (net.Conn, error) {
+	buf := make([]byte, 262)
+	// Greeting: version, number of methods, methods.
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return nil, err
+	}
+	// Request: version, command, reserved, address type.
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return nil, err
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return nil, err
+		}
+		host = net.IP(buf[:4]).String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return nil, err
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return nil, err
+		}
+		host = string(buf[:n])
+	default:
+		return nil, errors.New("unsupported address type")
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	port := binary.BigEndian.Uint16(buf[:2])
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		upstream.Close()
+		return nil, err
+	}
+	return upstream, nil
+}
+
+func TestUnsupportedProxy(t *testing.T) {
+	for _, scheme := range []string{"ftp", "https"} {
+		client := newMockedClient()
+		client.Proxy = &url.URL{Scheme: scheme, Host: "127.0.0.1:3128"}
+		_, err := client.StartDownload(context.Background())
+		if !errors.Is(err, ErrUnsupportedProxy) {
+			t.Fatalf("%s: expected ErrUnsupportedProxy, got %v", scheme, err)
+		}
+	}
+}
+
+func TestUnsupportedProxyFromEnvironment(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "https://proxy.example.com:3128")
+	client := NewClient(clientName, clientVersion)
+	req, err := http.NewRequest(http.MethodGet, "https://ndt.example.com/ndt/v7/download", nil)
+	testingx.Must(t, err, "failed to create request")
+	if _, err := client.proxy()(req); !errors.Is(err, ErrUnsupportedProxy) {
+		t.Fatalf("expected ErrUnsupportedProxy, got %v", err)
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+	t.Setenv("NO_PROXY", "direct.example.com")
+	client := NewClient(clientName, clientVersion)
+	proxy := client.proxy()
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://ndt.example.com/ndt/v7/download", "http://proxy.example.com:3128"},
+		{"https://direct.example.com/ndt/v7/download", ""},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		testingx.Must(t, err, "failed to create request")
+		u, err := proxy(req)
+		testingx.Must(t, err, "failed to choose proxy")
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != tt.want {
+			t.Errorf("proxy(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestLocatorProxy(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	client.Proxy = &url.URL{Scheme: "socks5", Host: "127.0.0.1:1080"}
+	l, done := client.locator()
+	defer done()
+	lc, ok := l.(*locate.Client)
+	if !ok || lc == client.Locate {
+		t.Fatal("expected a copy of c.Locate using a custom HTTP client")
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://locate.example.com/", nil)
+	testingx.Must(t, err, "failed to create request")
+	u, err := lc.HTTPClient.Transport.(*http.Transport).Proxy(req)
+	if err != nil || u != client.Proxy {
+		t.Fatalf("expected the client proxy, got %v, %v", u, err)
+	}
+}
+
+func TestIntegrationHTTPProxy(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	l := locatetest.NewLocateServerV2(newLocator(t, "ws://"+s.Host()))
+	defer l.Close()
+	p := &testProxy{}
+	ps := httptest.NewServer(p)
+	defer ps.Close()
+
+	u, err := url.Parse(l.URL + "/v2/nearest")
+	testingx.Must(t, err, "failed to parse locate URL")
+	loc := locate.NewClient(MakeUserAgent(clientName, clientVersion))
+	loc.BaseURL = u
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Locate = loc
+	client.Proxy, err = url.Parse(ps.URL)
+	testingx.Must(t, err, "failed to parse proxy URL")
+	client.Proxy.User = url.UserPassword("user", "pass")
+
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	for range ch {
+	}
+	if atomic.LoadInt32(&p.requests) != 1 || atomic.LoadInt32(&p.connects) != 1 {
+		t.Fatalf("expected the proxy to forward Locate and ndt7, got %d, %d",
+			p.requests, p.connects)
+	}
+	if got := client.ProxyUsed(); got != client.Proxy.Redacted() || got == client.Proxy.String() {
+		t.Fatalf("unexpected proxy used: %q", got)
+	}
+}
+
+func TestIntegrationHTTPProxyBadCredentials(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	ps := httptest.NewServer(&testProxy{})
+	defer ps.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	var err error
+	client.Proxy, err = url.Parse(ps.URL)
+	testingx.Must(t, err, "failed to parse proxy URL")
+	if _, err := client.StartDownload(context.Background()); err == nil {
+		t.Fatal("expected an error without credentials")
+	}
+	if client.ProxyUsed() != "" {
+		t.Fatal("expected no proxy to be recorded on failure")
+	}
+}
+
+func TestIntegrationSOCKS5Proxy(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	pl, err := net.Listen("tcp", "127.0.0.1:0")
+	testingx.Must(t, err, "failed to listen")
+	defer pl.Close()
+	var connects int32
+	go serveSOCKS5(pl, &connects)
+
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	client.Proxy = &url.URL{Scheme: "socks5", Host: pl.Addr().String()}
+	ch, err := client.StartUpload(context.Background())
+	testingx.Must(t, err, "failed to start upload")
+	for range ch {
+	}
+	if atomic.LoadInt32(&connects) != 1 {
+		t.Fatalf("expected one connection through the proxy, got %d", connects)
+	}
+	if client.ProxyUsed() != client.Proxy.String() {
+		t.Fatalf("unexpected proxy used: %q", client.ProxyUsed())
+	}
+}
@@ -0,0 +1,133 @@
+package ndt7
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// TerminationKind indicates how a test ended.
+type TerminationKind string
+
+const (
+	// TerminationClean indicates that the test ran until the end, i.e.,
+	// until its duration expired or the server closed the connection
+	// with a normal closure.
+	TerminationClean = TerminationKind("clean")
+
+	// TerminationTimeout indicates that the test was stopped by an I/O
+	// timeout or by the deadline of the context.
+	TerminationTimeout = TerminationKind("timeout")
+
+	// TerminationCanceled indicates that the context was canceled.
+	TerminationCanceled = TerminationKind("canceled")
+
+	// TerminationServerClose indicates that the server closed the
+	// connection before the end of the test.
+	TerminationServerClose = TerminationKind("server_close")
+
+	// TerminationIOError indicates that the test failed because of any
+	// other I/O error.
+	TerminationIOError = TerminationKind("io_error")
+
+	// TerminationBudget indicates that the test stopped because it
+	// transferred TestOptions.MaxBytes bytes.
+	TerminationBudget = TerminationKind("budget")
+
+	// TerminationConverged indicates that the test stopped because its
+	// throughput converged. See TestOptions.ConvergenceTolerance.
+	TerminationConverged = TerminationKind("converged")
+)
+
+// Result is the result of a test run by RunDownload or RunUpload.
+type Result struct {
+	// Test is the kind of test.
+	Test spec.TestKind
+
+	// Measurements contains the final measurements of the test.
+	Measurements *LatestMeasurements
+
+	// Duration is the time elapsed between connecting to the server
+	// and the end of the test.
+	Duration time.Duration
+
+	// Bytes is the number of bytes received, for the download, or sent,
+	// for the upload, according to the latest client measurement.
+	Bytes int64
+
+	// Termination indicates how the test ended.
+	Termination TerminationKind
+
+	// Err is the error that caused the test to stop, if any. It is nil
+	// when the test ended cleanly. In multi-stream tests, it is the error
+	// of the first stream, and it is only set when all the streams failed.
+	Err error
+}
+
+// RunDownload is like StartDownload but blocks until the download ends
+// and returns its Result. The returned error is only non nil when we
+// could not start the download. A download that started and then failed
+// returns a Result whose Err field is set.
+func (c *Client) RunDownload(ctx context.Context) (*Result, error) {
+	return c.run(ctx, spec.TestDownload, c.StartDownload)
+}
+
+// RunUpload is like RunDownload but for the upload.
+func (c *Client) RunUpload(ctx context.Context) (*Result, error) {
+	return c.run(ctx, spec.TestUpload, c.StartUpload)
+}
+
+// run starts a test using the start function, waits for the test to end
+// and returns its Result.
+func (c *Client) run(ctx context.Context, test spec.TestKind,
+	start func(context.Context) (<-chan spec.Measurement, error)) (*Result, error) {
+	ch, err := start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	begin := time.Now()
+	for range ch {
+		// Just drain the channel: the measurements are stored into
+		// the results as they arrive.
+	}
+	r := &Result{
+		Test:     test,
+		Duration: time.Since(begin),
+	}
+	r.Measurements = c.Results()[test]
+	if r.Measurements.Client.AppInfo != nil {
+		r.Bytes = r.Measurements.Client.AppInfo.NumBytes
+	}
+	r.Err = r.Measurements.Error
+	r.Termination = terminationOf(r.Err)
+	if r.Err == nil && r.Measurements.BudgetLimited {
+		r.Termination = TerminationBudget
+	} else if r.Err == nil && r.Measurements.Converged {
+		r.Termination = TerminationConverged
+	}
+	return r, nil
+}
+
+// terminationOf classifies the error that caused a test to stop.
+func terminationOf(err error) TerminationKind {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return TerminationClean
+	case errors.Is(err, context.Canceled):
+		return TerminationCanceled
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return TerminationTimeout
+	case errors.As(err, new(*websocket.CloseError)),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return TerminationServerClose
+	default:
+		return TerminationIOError
+	}
+}
@@ -0,0 +1,237 @@
+package ndt7
+
+import (
+	"context"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/internal/websocketx"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestTerminationOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want TerminationKind
+	}{
+		{nil, TerminationClean},
+		{context.Canceled, TerminationCanceled},
+		{context.DeadlineExceeded, TerminationTimeout},
+		{timeoutError{}, TerminationTimeout},
+		{&websocket.CloseError{Code: websocket.CloseAbnormalClosure}, TerminationServerClose},
+		{io.ErrUnexpectedEOF, TerminationServerClose},
+		{syscall.ECONNRESET, TerminationIOError},
+		{errors.New("mocked error"), TerminationIOError},
+	}
+	for _, tt := range tests {
+		if got := terminationOf(tt.err); got != tt.want {
+			t.Errorf("terminationOf(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRunDownloadError(t *testing.T) {
+	client := newMockedClient()
+	mockedErr := errors.New("mocked error")
+	client.download = func(
+		ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+		opts params.TestOptions,
+	) error {
+		defer close(ch)
+		ch <- spec.Measurement{
+			AppInfo: &spec.AppInfo{NumBytes: 1234},
+			Origin:  spec.OriginClient,
+			Test:    spec.TestDownload,
+		}
+		return mockedErr
+	}
+	r, err := client.RunDownload(context.Background())
+	testingx.Must(t, err, "failed to run download")
+	if r.Test != spec.TestDownload || r.Bytes != 1234 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if r.Err != mockedErr || r.Termination != TerminationIOError {
+		t.Fatalf("unexpected termination: %s %v", r.Termination, r.Err)
+	}
+	if client.Results()[spec.TestDownload].Error != mockedErr {
+		t.Fatal("the error should also be in the results")
+	}
+}
+
+func TestRunUploadCanceled(t *testing.T) {
+	client := newMockedClient()
+	client.upload = func(
+		ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+		opts params.TestOptions,
+	) error {
+		defer close(ch)
+		<-ctx.Done()
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	r, err := client.RunUpload(ctx)
+	testingx.Must(t, err, "failed to run upload")
+	if !errors.Is(r.Err, context.Canceled) || r.Termination != TerminationCanceled {
+		t.Fatalf("unexpected termination: %s %v", r.Termination, r.Err)
+	}
+	if r.Duration < 100*time.Millisecond {
+		t.Fatalf("unexpected duration: %v", r.Duration)
+	}
+}
+
+func TestRunDownloadStartError(t *testing.T) {
+	client := newMockedClient()
+	client.TestOptions.IOTimeout = 0
+	r, err := client.RunDownload(context.Background())
+	if !errors.Is(err, ErrInvalidTestOptions) || r != nil {
+		t.Fatalf("not the error we expected: %v", err)
+	}
+}
+
+func TestRunDownloadStreamsFailed(t *testing.T) {
+	client := newMockedClient()
+	client.DownloadStreams = 2
+	mockedErr := errors.New("mocked error")
+	client.download = func(
+		ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+		opts params.TestOptions,
+	) error {
+		close(ch)
+		return mockedErr
+	}
+	r, err := client.RunDownload(context.Background())
+	testingx.Must(t, err, "failed to run download")
+	if r.Err != mockedErr || r.Termination != TerminationIOError {
+		t.Fatalf("unexpected termination: %s %v", r.Termination, r.Err)
+	}
+}
+
+func TestRunWithServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		config testserver.Config
+		run    func(*Client, context.Context) (*Result, error)
+		want   TerminationKind
+	}{{
+		name:   "download",
+		config: testserver.Config{Duration: 500 * time.Millisecond},
+		run:    (*Client).RunDownload,
+		want:   TerminationClean,
+	}, {
+		name:   "upload",
+		config: testserver.Config{Duration: 500 * time.Millisecond},
+		run:    (*Client).RunUpload,
+		want:   TerminationClean,
+	}, {
+		name: "download closed by server",
+		config: testserver.Config{
+			Duration:   5 * time.Second,
+			CloseAfter: 500 * time.Millisecond,
+		},
+		run:  (*Client).RunDownload,
+		want: TerminationServerClose,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testserver.NewServer(tt.config)
+			defer s.Close()
+			client := NewClient(clientName, clientVersion)
+			client.Scheme = s.Scheme()
+			client.Server = s.Host()
+			r, err := tt.run(client, context.Background())
+			testingx.Must(t, err, "failed to run test")
+			if r.Termination != tt.want {
+				t.Fatalf("unexpected termination: %s %v", r.Termination, r.Err)
+			}
+			if (r.Err == nil) != (tt.want == TerminationClean) {
+				t.Fatalf("unexpected error: %v", r.Err)
+			}
+			if r.Bytes <= 0 || r.Duration <= 0 || r.Measurements == nil {
+				t.Fatalf("unexpected result: %+v", r)
+			}
+		})
+	}
+}
+
+func TestRunMaxBytes(t *testing.T) {
+	const maxBytes = 1 << 20
+	tests := []struct {
+		name    string
+		streams int
+		run     func(*Client, context.Context) (*Result, error)
+	}{
+		{"download", 1, (*Client).RunDownload},
+		{"upload", 1, (*Client).RunUpload},
+		{"download streams", 2, (*Client).RunDownload},
+		{"upload streams", 2, (*Client).RunUpload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testserver.NewServer(testserver.Config{Duration: 5 * time.Second})
+			defer s.Close()
+			client := NewClient(clientName, clientVersion)
+			client.Scheme = s.Scheme()
+			client.Server = s.Host()
+			client.DownloadStreams = tt.streams
+			client.UploadStreams = tt.streams
+			client.TestOptions.MaxBytes = maxBytes
+			r, err := tt.run(client, context.Background())
+			testingx.Must(t, err, "failed to run test")
+			if r.Termination != TerminationBudget || !r.Measurements.BudgetLimited {
+				t.Fatalf("unexpected termination: %s %v", r.Termination, r.Err)
+			}
+			if r.Duration >= 5*time.Second {
+				t.Fatalf("the test did not stop early: %v", r.Duration)
+			}
+			if r.Bytes < maxBytes-int64(tt.streams) || r.Bytes > 2*maxBytes {
+				t.Fatalf("unexpected number of bytes: %d", r.Bytes)
+			}
+		})
+	}
+}
+
+func TestRunConvergence(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(*Client, context.Context) (*Result, error)
+	}{
+		{"download", (*Client).RunDownload},
+		{"upload", (*Client).RunUpload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testserver.NewServer(testserver.Config{Duration: 10 * time.Second})
+			defer s.Close()
+			client := NewClient(clientName, clientVersion)
+			client.Scheme = s.Scheme()
+			client.Server = s.Host()
+			client.TestOptions.DownloadDuration = 10 * time.Second
+			client.TestOptions.UploadDuration = 10 * time.Second
+			client.TestOptions.ConvergenceTolerance = 0.9
+			client.TestOptions.ConvergenceMinDuration = 0
+			r, err := tt.run(client, context.Background())
+			testingx.Must(t, err, "failed to run test")
+			if r.Termination != TerminationConverged || !r.Measurements.Converged {
+				t.Fatalf("unexpected termination: %s %v", r.Termination, r.Err)
+			}
+			if r.Duration >= 10*time.Second {
+				t.Fatalf("the test did not stop early: %v", r.Duration)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package ndt7
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/internal/probe"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// errNoServerURL indicates that a target has no URL for the scheme in use.
+var errNoServerURL = errors.New("no URL for the scheme in use")
+
+// ServerSelection is the strategy used to choose among the servers
+// returned by the Locate API.
+type ServerSelection string
+
+const (
+	// SelectLocateOrder tries the servers in the order returned by the
+	// Locate API, which ranks them by geographic distance.
+	SelectLocateOrder = ServerSelection("")
+
+	// SelectLowestRTT probes all the servers returned by the Locate API
+	// and tries them in order of increasing RTT, measured as the time
+	// to establish a TCP connection. Servers that we could not connect
+	// to are tried last, in the order returned by the Locate API.
+	SelectLowestRTT = ServerSelection("rtt")
+)
+
+// SelectServer queries the Locate API, unless already done, and returns
+// the results of probing the servers, in the order in which the client
+// is going to try them. There is no need to call SelectServer before
+// starting a test, which does that automatically; however, it allows
+// to know the probe results before any test starts. It returns no
+// results when c.ServerSelection is not SelectLowestRTT or when we
+// are not using the Locate API. When replaying previous tests, it returns
+// the recorded results, if any.
+func (c *Client) SelectServer(ctx context.Context) ([]spec.ServerProbe, error) {
+	if c.Replayer != nil {
+		probes := c.Replayer.ServerProbes()
+		c.mu.Lock()
+		c.serverProbes = probes
+		c.mu.Unlock()
+		return append([]spec.ServerProbe(nil), probes...), nil
+	}
+	if c.Server != "" || c.ServiceURL != nil {
+		return nil, nil
+	}
+	if err := c.locate(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]spec.ServerProbe(nil), c.serverProbes...), nil
+}
+
+// ServerProbes returns the results of probing the servers, if any, in
+// the order in which the client is going to try them.
+func (c *Client) ServerProbes() []spec.ServerProbe {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]spec.ServerProbe(nil), c.serverProbes...)
+}
+
+// locate queries the Locate API, unless we have cached targets, and caches
+// the returned targets, sorted according to c.ServerSelection, and the
+// expiry of their access tokens. We do not hold c.mu while querying and
+// probing the servers, so that the tests already running, which store each
+// measurement holding c.mu, do not stall; c.locateMu makes concurrent
+// callers wait for the query in progress, if any, and then use its results.
+func (c *Client) locate(ctx context.Context) error {
+	c.locateMu.Lock()
+	defer c.locateMu.Unlock()
+	c.mu.Lock()
+	cached, scheme := len(c.targets) != 0, c.Scheme
+	c.mu.Unlock()
+	if cached {
+		return nil
+	}
+	locator, done := c.locator()
+	defer done()
+	start := time.Now()
+	targets, err := locator.Nearest(ctx, "ndt/ndt7")
+	elapsed := time.Since(start)
+	var probes []spec.ServerProbe
+	if err == nil && c.ServerSelection == SelectLowestRTT {
+		targets, probes = c.sortByRTT(ctx, scheme, targets)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.locateTime = elapsed
+	if err != nil && ctx.Err() != nil {
+		return classify(err, ErrorKindLocate)
+	}
+	if err != nil {
+		// Whatever the cause, e.g., failing to resolve the name of the
+		// Locate API, it is a Locate failure.
+		return &Error{Kind: ErrorKindLocate, Err: err}
+	}
+	c.serverProbes = probes
+	// cache targets on success.
+	c.targets = targets
+	c.tExpiry = make([]time.Time, len(targets))
+	for i, t := range targets {
+		c.tExpiry[i] = targetExpiry(t)
+	}
+	return nil
+}
+
+// sortByRTT probes the given targets in parallel and returns them sorted
+// by increasing RTT, along with the probe results in the same order. We
+// probe the URLs for the given scheme.
+func (c *Client) sortByRTT(ctx context.Context, scheme string,
+	targets []v2.Target) ([]v2.Target, []spec.ServerProbe) {
+	k := scheme + "://" + params.DownloadURLPath
+	dial := c.netDial()
+	probes := make([]spec.ServerProbe, len(targets))
+	wg := &sync.WaitGroup{}
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, s string) {
+			defer wg.Done()
+			probes[i] = probeServer(ctx, dial, s)
+		}(i, t.URLs[k])
+	}
+	wg.Wait()
+	order := make([]int, len(targets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ra, rb := probes[order[a]].RTT, probes[order[b]].RTT
+		return ra != 0 && (rb == 0 || ra < rb)
+	})
+	sortedTargets := make([]v2.Target, len(targets))
+	sortedProbes := make([]spec.ServerProbe, len(targets))
+	for i, j := range order {
+		sortedTargets[i] = targets[j]
+		sortedProbes[i] = probes[j]
+	}
+	return sortedTargets, sortedProbes
+}
+
+// probeServer returns the lowest RTT of the server at the given URL.
+func probeServer(ctx context.Context, dial probe.DialFunc, s string) spec.ServerProbe {
+	var sp spec.ServerProbe
+	if u, err := url.Parse(s); err == nil {
+		sp.Server = u.Hostname()
+	}
+	if sp.Server == "" {
+		sp.Failure = errNoServerURL.Error()
+		return sp
+	}
+	address, err := urlAddress(s)
+	if err != nil {
+		sp.Failure = err.Error()
+		return sp
+	}
+	var best time.Duration
+	for i := 0; i < params.SelectionSamples; i++ {
+		rtt, err := probe.Once(ctx, dial, address, params.SelectionTimeout)
+		if err != nil {
+			sp.Failure = err.Error()
+			continue
+		}
+		if best == 0 || rtt < best {
+			best = rtt
+		}
+	}
+	if best > 0 {
+		sp.RTT = best.Microseconds()
+		sp.Failure = ""
+	}
+	return sp
+}
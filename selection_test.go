@@ -0,0 +1,249 @@
+package ndt7
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/testingx"
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// targetsLocator is a Locator returning the given targets.
+type targetsLocator struct {
+	targets []v2.Target
+	err     error
+}
+
+func (l *targetsLocator) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	return l.targets, l.err
+}
+
+// newTarget returns a Locate target for the given host.
+func newTarget(host string) v2.Target {
+	return v2.Target{
+		Machine: host,
+		URLs: map[string]string{
+			"ws:///ndt/v7/download": "ws://" + host + "/ndt/v7/download",
+			"ws:///ndt/v7/upload":   "ws://" + host + "/ndt/v7/upload",
+		},
+	}
+}
+
+func TestSelectServerLowestRTT(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	client.ServerSelection = SelectLowestRTT
+	client.Locate = &targetsLocator{targets: []v2.Target{
+		newTarget("far.example"), newTarget("down.example"), newTarget("near.example"),
+	}}
+	delays := map[string]time.Duration{
+		"far.example:80":  30 * time.Millisecond,
+		"near.example:80": 10 * time.Millisecond,
+	}
+	client.Dialer.NetDialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		delay, ok := delays[address]
+		if !ok {
+			return nil, errors.New("connection refused")
+		}
+		time.Sleep(delay)
+		server, conn := net.Pipe()
+		server.Close()
+		return conn, nil
+	}
+	probes, err := client.SelectServer(context.Background())
+	testingx.Must(t, err, "failed to select server")
+	want := []string{"near.example", "far.example", "down.example"}
+	if len(probes) != len(want) {
+		t.Fatalf("unexpected probes: %+v", probes)
+	}
+	for i, p := range probes {
+		if p.Server != want[i] {
+			t.Fatalf("unexpected order: %+v", probes)
+		}
+	}
+	if probes[0].RTT < 10000 || probes[2].RTT != 0 || probes[2].Failure == "" {
+		t.Fatalf("unexpected probes: %+v", probes)
+	}
+	u, err := client.nextURLFromLocate(context.Background(), params.UploadURLPath)
+	testingx.Must(t, err, "failed to get URL")
+	if u != "ws://near.example/ndt/v7/upload" {
+		t.Fatalf("unexpected URL: %s", u)
+	}
+	if len(client.ServerProbes()) != len(want) {
+		t.Fatal("the probes should be cached")
+	}
+}
+
+func TestSelectServerLocateOrder(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	client.Locate = &targetsLocator{targets: []v2.Target{
+		newTarget("far.example"), newTarget("near.example"),
+	}}
+	probes, err := client.SelectServer(context.Background())
+	testingx.Must(t, err, "failed to select server")
+	if probes != nil {
+		t.Fatal("expected no probes")
+	}
+	u, err := client.nextURLFromLocate(context.Background(), params.DownloadURLPath)
+	testingx.Must(t, err, "failed to get URL")
+	if u != "ws://far.example/ndt/v7/download" {
+		t.Fatalf("unexpected URL: %s", u)
+	}
+}
+
+func TestSelectServerCustomServer(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	client.ServerSelection = SelectLowestRTT
+	client.Server = "127.0.0.1"
+	client.Locate = &targetsLocator{err: errors.New("should not be called")}
+	probes, err := client.SelectServer(context.Background())
+	if err != nil || probes != nil {
+		t.Fatalf("unexpected result: %+v %v", probes, err)
+	}
+}
+
+func TestSelectServerLocateError(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	client.ServerSelection = SelectLowestRTT
+	mockedErr := errors.New("mocked error")
+	client.Locate = &targetsLocator{err: mockedErr}
+	_, err := client.SelectServer(context.Background())
+	var ndt7Err *Error
+	if !errors.As(err, &ndt7Err) || ndt7Err.Kind != ErrorKindLocate || ndt7Err.Err != mockedErr {
+		t.Fatalf("not the error we expected: %v", err)
+	}
+}
+
+func TestProbeServerNoURL(t *testing.T) {
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		t.Fatal("should not dial")
+		return nil, nil
+	}
+	p := probeServer(context.Background(), dial, "")
+	if p.Failure != errNoServerURL.Error() || p.RTT != 0 {
+		t.Fatalf("unexpected probe: %+v", p)
+	}
+}
+
+func TestSortByRTTStable(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	client.Dialer.NetDialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	targets, probes := client.sortByRTT(context.Background(), "ws", []v2.Target{
+		newTarget("a.example"), newTarget("b.example"), newTarget("c.example"),
+	})
+	for i, host := range []string{"a.example", "b.example", "c.example"} {
+		if targets[i].Machine != host || probes[i].Server != host {
+			t.Fatalf("failed servers should keep the Locate order: %+v", probes)
+		}
+	}
+}
+
+// blockingLocator is a Locator returning the given targets, whose queries
+// after the first one block until release is closed.
+type blockingLocator struct {
+	targets []v2.Target
+	calls   atomic.Int64
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (l *blockingLocator) Nearest(ctx context.Context, service string) ([]v2.Target, error) {
+	if l.calls.Add(1) > 1 {
+		close(l.blocked)
+		<-l.release
+	}
+	return l.targets, nil
+}
+
+func TestLocateDoesNotBlockRunningTests(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{Duration: 3 * time.Second})
+	defer s.Close()
+	l := &blockingLocator{
+		targets: []v2.Target{newTarget(s.Host())},
+		blocked: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	client.Locate = l
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start the download")
+	<-ch
+
+	client.ResetTargets()
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.SelectServer(context.Background())
+		done <- err
+	}()
+	<-l.blocked
+	// While the query is in progress, the download keeps running and we
+	// can read its results.
+	timeout := time.After(2 * time.Second)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ch:
+		case <-timeout:
+			t.Fatal("the download stalled while querying Locate")
+		}
+	}
+	if client.Results()[spec.TestDownload] == nil {
+		t.Fatal("expected the download results")
+	}
+	close(l.release)
+	testingx.Must(t, <-done, "failed to query Locate")
+	drain(ch)
+}
+
+func TestProbingDoesNotBlockRunningTests(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{Duration: 3 * time.Second})
+	defer s.Close()
+	var blocking atomic.Bool
+	blocked, release := make(chan struct{}), make(chan struct{})
+	once := &sync.Once{}
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	client.Locate = &targetsLocator{targets: []v2.Target{newTarget(s.Host())}}
+	client.ServerSelection = SelectLowestRTT
+	client.Dialer.NetDialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if blocking.Load() {
+			once.Do(func() { close(blocked) })
+			<-release
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start the download")
+	<-ch
+
+	blocking.Store(true)
+	client.ResetTargets()
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.SelectServer(context.Background())
+		done <- err
+	}()
+	<-blocked
+	timeout := time.After(2 * time.Second)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ch:
+		case <-timeout:
+			t.Fatal("the download stalled while probing the servers")
+		}
+	}
+	close(release)
+	testingx.Must(t, <-done, "failed to select the server")
+	drain(ch)
+}
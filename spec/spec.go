@@ -3,6 +3,8 @@
 package spec
 
 import (
+	"time"
+
 	"github.com/m-lab/ndt-server/ndt7/model"
 )
 
@@ -26,6 +28,141 @@ type (
 	TCPInfo model.TCPInfo
 )
 
+// LatencyInfo contains a round-trip time sample taken by the client's
+// latency probe, which measures the time to establish a TCP connection
+// with the server used for the test.
+type LatencyInfo struct {
+	// RTT is the round-trip time in microseconds.
+	RTT int64
+
+	// Loaded indicates that the sample was taken while the test was
+	// running, as opposed to before the test started.
+	Loaded bool `json:",omitempty"`
+}
+
+// CPUInfo contains the CPU time used by the client process during a test,
+// which tells whether the client, rather than the network, limited the
+// throughput.
+type CPUInfo struct {
+	// ElapsedTime is the time elapsed since the beginning of the test,
+	// in microseconds.
+	ElapsedTime int64
+
+	// CPUTime is the user and system CPU time used by the whole client
+	// process since the beginning of the test, in microseconds.
+	CPUTime int64
+}
+
+// ThroughputSample contains the throughput measured between a measurement
+// and the previous one of the same origin, i.e., during an update interval.
+// The client computes it from the AppInfo of its own measurements, and from
+// the TCPInfo of the server measurements, i.e., BytesAcked for the download
+// and BytesReceived for the upload.
+type ThroughputSample struct {
+	// ElapsedTime is the time elapsed since the beginning of the test
+	// at the end of the interval, in microseconds.
+	ElapsedTime int64
+
+	// Interval is the duration of the interval in microseconds.
+	Interval int64
+
+	// Throughput is the throughput during the interval in Mbit/s.
+	Throughput float64
+}
+
+// ServerProbe contains the result of probing a server returned by the
+// Locate API, which the client does to choose the server with the lowest
+// RTT, measured as the time to establish a TCP connection.
+type ServerProbe struct {
+	// Server is the FQDN of the server.
+	Server string
+
+	// RTT is the lowest RTT in microseconds. It is zero when we could
+	// not establish any TCP connection with the server.
+	RTT int64 `json:",omitempty"`
+
+	// Failure is the error that occurred when probing the server, which
+	// is only set when RTT is zero.
+	Failure string `json:",omitempty"`
+}
+
+// ConnectionTiming contains how long the client took to connect to the
+// server, broken down into phases, in microseconds. A phase is zero when it
+// did not happen, e.g., the DNS resolution when connecting to an IP address
+// or the TLS handshake when using the ws scheme. When using a proxy, DNS and
+// TCPConnect refer to the connection with the proxy.
+type ConnectionTiming struct {
+	// Locate is the time spent querying the Locate API, which we only
+	// do before the first connection of a Client.
+	Locate int64 `json:",omitempty"`
+
+	// DNS is the time spent resolving the server name.
+	DNS int64 `json:",omitempty"`
+
+	// TCPConnect is the time spent establishing the TCP connection.
+	TCPConnect int64 `json:",omitempty"`
+
+	// TLSHandshake is the time spent performing the TLS handshake.
+	TLSHandshake int64 `json:",omitempty"`
+
+	// WebSocketUpgrade is the time spent upgrading the connection to
+	// WebSocket, i.e., sending the HTTP request and reading the response.
+	WebSocketUpgrade int64 `json:",omitempty"`
+
+	// Total is the time spent connecting, including querying the Locate
+	// API and trying other servers before, which the other phases do not
+	// account for, since they only refer to the last attempt.
+	Total int64
+}
+
+// TLSInfo contains the parameters negotiated by a TLS connection with the
+// server.
+type TLSInfo struct {
+	// Version is the TLS version, e.g., "TLS 1.3".
+	Version string
+
+	// CipherSuite is the name of the cipher suite, e.g.,
+	// "TLS_AES_128_GCM_SHA256".
+	CipherSuite string
+
+	// PeerSubject is the subject of the certificate of the server.
+	PeerSubject string `json:",omitempty"`
+}
+
+// TraceRecord contains a measurement received by the client during a test,
+// recorded so that the test can be replayed later without the network. A
+// trace contains a TraceRecord per line, serialized as JSON. The first
+// record of each direction, and of each stream, of a test also contains
+// how connecting to the server went.
+type TraceRecord struct {
+	// Time is when the client received the measurement.
+	Time time.Time
+
+	// Test is the test that received the measurement, which is
+	// TestBidirectional for both directions of a bidirectional test.
+	Test TestKind
+
+	// Started is when the test started. It tells apart the records of
+	// different runs of the same test.
+	Started time.Time
+
+	// Server is the FQDN of the server used by the test.
+	Server string `json:",omitempty"`
+
+	// Measurement is the measurement received by the client.
+	Measurement Measurement
+
+	// ConnectionTiming is how long connecting to the server took.
+	ConnectionTiming *ConnectionTiming `json:",omitempty"`
+
+	// TLS contains the parameters negotiated with the server.
+	TLS *TLSInfo `json:",omitempty"`
+
+	// ServerProbes contains the results of probing the servers returned
+	// by the Locate API, if any, when choosing the server.
+	ServerProbes []ServerProbe `json:",omitempty"`
+}
+
 const (
 	// OriginClient indicates that the measurement origin is the client.
 	OriginClient = OriginKind("client")
@@ -38,6 +175,11 @@ const (
 
 	// TestUpload indicates that this is an upload.
 	TestUpload = TestKind("upload")
+
+	// TestBidirectional indicates that the download and the upload run
+	// at the same time. The measurements of such test have their Test
+	// field set to either TestDownload or TestUpload.
+	TestBidirectional = TestKind("bidirectional")
 )
 
 // The Measurement struct contains measurement results. This message is
@@ -60,4 +202,24 @@ type Measurement struct {
 
 	// TCPInfo contains metrics measured using TCP_INFO instrumentation.
 	TCPInfo *TCPInfo `json:",omitempty"`
+
+	// Latency contains a sample taken by the latency probe.
+	Latency *LatencyInfo `json:",omitempty"`
+
+	// Stream is the 1-based index of the stream that produced this
+	// measurement in multi-stream tests. It is zero in single-stream tests
+	// and for measurements aggregating all the streams.
+	Stream int `json:",omitempty"`
+
+	// Throughput contains the throughput since the previous measurement
+	// of the same origin, computed by the client.
+	Throughput *ThroughputSample `json:",omitempty"`
+
+	// CPUInfo contains the CPU time used by the client, in client
+	// measurements, on systems where we can read it.
+	CPUInfo *CPUInfo `json:",omitempty"`
+
+	// Converged is set in the last client measurement of a test that
+	// stopped because its throughput converged.
+	Converged bool `json:",omitempty"`
 }
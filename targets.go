@@ -0,0 +1,107 @@
+package ndt7
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// tokenExpiryMargin is how long before the expiry of its access tokens we
+// consider a target stale, so that the tokens are still valid when the
+// server checks them during the WebSocket handshake.
+const tokenExpiryMargin = 5 * time.Second
+
+// ResetTargets drops the targets returned by the Locate API, so that the
+// next test queries it again. There is usually no need to call it, since
+// each test queries the Locate API again when the targets it would try
+// are exhausted or their access tokens are about to expire.
+func (c *Client) ResetTargets() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resetTargetsLocked()
+}
+
+// resetTargetsLocked implements ResetTargets. The caller must hold c.mu.
+func (c *Client) resetTargetsLocked() {
+	c.targets = nil
+	c.tExpiry = nil
+	c.tIndex = map[string]int{}
+	c.serverProbes = nil
+}
+
+// refreshTargets drops the cached targets, if any, when none of those that
+// a test for the URL path p would try is usable, because they have all been
+// tried already or because their access tokens are about to expire. We call
+// it when a test starts, so that a Client reused for many tests does not
+// run out of targets. We do not call it when connecting the other streams
+// of a multi-stream test, which reuse the first server instead. We only
+// hold c.mu to drop the targets: the test then queries the Locator again
+// without holding it (see locate), so that a slow query does not stall the
+// other tests running with the same Client.
+func (c *Client) refreshTargets(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.targets) == 0 {
+		return
+	}
+	k := c.Scheme + "://" + p
+	now := time.Now()
+	for i := c.tIndex[k]; i < len(c.targets); i++ {
+		if !c.staleLocked(i, now) {
+			return
+		}
+	}
+	c.resetTargetsLocked()
+}
+
+// staleLocked returns whether the access tokens of the i-th target expire
+// within tokenExpiryMargin from now. The caller must hold c.mu.
+func (c *Client) staleLocked(i int, now time.Time) bool {
+	if i >= len(c.tExpiry) || c.tExpiry[i].IsZero() {
+		return false
+	}
+	return c.tExpiry[i].Before(now.Add(tokenExpiryMargin))
+}
+
+// targetExpiry returns the earliest expiry of the access tokens in the
+// URLs of t, or the zero time if they contain no tokens with an expiry.
+func targetExpiry(t v2.Target) time.Time {
+	var expiry time.Time
+	for _, s := range t.URLs {
+		exp := tokenExpiry(s)
+		if !exp.IsZero() && (expiry.IsZero() || exp.Before(expiry)) {
+			expiry = exp
+		}
+	}
+	return expiry
+}
+
+// tokenExpiry returns the expiry of the access token in the URL s, or the
+// zero time if there is no token or we cannot parse it. The Locate API
+// issues the tokens as signed JWTs, whose payload we decode without
+// verifying the signature, which is the job of the server.
+func tokenExpiry(s string) time.Time {
+	u, err := url.Parse(s)
+	if err != nil {
+		return time.Time{}
+	}
+	parts := strings.Split(u.Query().Get("access_token"), ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Expiry int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Expiry, 0)
+}
@@ -0,0 +1,216 @@
+package ndt7
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// newToken returns an unsigned JWT expiring at the given time.
+func newToken(exp time.Time) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." +
+		encode([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix()))) + ".signature"
+}
+
+// withToken returns a target whose URLs contain a token expiring at exp.
+func withToken(t v2.Target, exp time.Time) v2.Target {
+	urls := map[string]string{}
+	for k, u := range t.URLs {
+		urls[k] = u + "?access_token=" + newToken(exp)
+	}
+	return v2.Target{Machine: t.Machine, URLs: urls}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	exp := time.Now().Add(time.Minute).Truncate(time.Second)
+	got := tokenExpiry("wss://a.example/ndt/v7/download?access_token=" + newToken(exp))
+	if !got.Equal(exp) {
+		t.Fatalf("unexpected expiry: %v", got)
+	}
+	for _, s := range []string{
+		"wss://a.example/ndt/v7/download",
+		"wss://a.example/ndt/v7/download?access_token=opaque",
+		"wss://a.example/ndt/v7/download?access_token=a.!!.c",
+		"wss://a.example/ndt/v7/download?access_token=a.e30.c",
+		"%zz",
+	} {
+		if got := tokenExpiry(s); !got.IsZero() {
+			t.Errorf("%s: expected no expiry, got %v", s, got)
+		}
+	}
+	target := v2.Target{URLs: map[string]string{
+		"wss:///ndt/v7/download": "wss://a.example/ndt/v7/download?access_token=" + newToken(exp),
+		"wss:///ndt/v7/upload":   "wss://a.example/ndt/v7/upload?access_token=" + newToken(exp.Add(-time.Second)),
+		"ws:///ndt/v7/download":  "ws://a.example/ndt/v7/download",
+	}}
+	if got := targetExpiry(target); !got.Equal(exp.Add(-time.Second)) {
+		t.Fatalf("expected the earliest expiry, got %v", got)
+	}
+}
+
+func TestRefreshTargets(t *testing.T) {
+	now := time.Now()
+	fresh, stale := now.Add(time.Minute), now.Add(time.Second)
+	tests := []struct {
+		name   string
+		expiry []time.Time
+		index  int
+		reset  bool
+	}{
+		{"fresh", []time.Time{fresh, fresh}, 0, false},
+		{"no tokens", []time.Time{{}, {}}, 1, false},
+		{"exhausted", []time.Time{fresh, fresh}, 2, true},
+		{"stale", []time.Time{fresh, stale, stale}, 1, true},
+		{"partially stale", []time.Time{stale, fresh}, 0, false},
+	}
+	for _, tt := range tests {
+		client := NewClient(clientName, clientVersion)
+		client.Scheme = "ws"
+		client.targets = make([]v2.Target, len(tt.expiry))
+		client.tExpiry = tt.expiry
+		client.tIndex["ws:///ndt/v7/download"] = tt.index
+		client.tIndex["ws:///ndt/v7/upload"] = 1
+		client.refreshTargets("/ndt/v7/download")
+		if reset := client.targets == nil; reset != tt.reset {
+			t.Errorf("%s: expected reset to be %v", tt.name, tt.reset)
+		}
+		if tt.reset && len(client.tIndex) != 0 {
+			t.Errorf("%s: expected the indexes to be reset", tt.name)
+		}
+	}
+}
+
+func TestPeekSkipsStaleTargets(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	client.targets = []v2.Target{
+		{URLs: map[string]string{"ws:///ndt/v7/download": "ws://a.example/ndt/v7/download"}},
+		{URLs: map[string]string{"ws:///ndt/v7/download": "ws://b.example/ndt/v7/download"}},
+	}
+	client.tExpiry = []time.Time{time.Now().Add(time.Second), time.Now().Add(time.Minute)}
+	got, err := client.peekURLFromLocate(context.Background(), "/ndt/v7/download")
+	if err != nil || got != "ws://b.example/ndt/v7/download" {
+		t.Fatalf("expected the second target, got %q, %v", got, err)
+	}
+}
+
+func TestPeekSkipsTargetsWithoutScheme(t *testing.T) {
+	client := NewClient(clientName, clientVersion)
+	client.targets = []v2.Target{
+		{URLs: map[string]string{"ws:///ndt/v7/download": "ws://a.example/ndt/v7/download"}},
+		{URLs: map[string]string{"wss:///ndt/v7/download": "wss://b.example/ndt/v7/download"}},
+	}
+	got, err := client.peekURLFromLocate(context.Background(), "/ndt/v7/download")
+	if err != nil || got != "wss://b.example/ndt/v7/download" {
+		t.Fatalf("expected the second target, got %q, %v", got, err)
+	}
+	client.targets = client.targets[:1]
+	client.tIndex = map[string]int{}
+	_, err = client.peekURLFromLocate(context.Background(), "/ndt/v7/download")
+	if !errors.Is(err, ErrNoTargets) || !strings.Contains(err.Error(), "wss scheme") {
+		t.Fatalf("expected an error about the scheme, got %v", err)
+	}
+}
+
+func TestIntegrationTargetsRefresh(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{})
+	defer s.Close()
+	l := testserver.NewLocator(s)
+	target := l.Targets[0]
+	l.Targets[0] = withToken(target, time.Now().Add(time.Minute))
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Locate = l
+
+	// Reusing the client, e.g., across the iterations of a loop, queries
+	// Locate again once the targets are exhausted.
+	for i := 0; i < 2; i++ {
+		ch, err := client.StartDownload(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		drain(ch)
+	}
+	if calls := l.Calls.Load(); calls != 2 {
+		t.Fatalf("expected to query Locate twice, got %d", calls)
+	}
+
+	// The targets are still usable for the upload.
+	ch, err := client.StartUpload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	drain(ch)
+	if calls := l.Calls.Load(); calls != 2 {
+		t.Fatalf("expected to reuse the targets, got %d queries", calls)
+	}
+
+	// Targets whose tokens are about to expire are useless.
+	l.Targets[0] = withToken(target, time.Now())
+	client.ResetTargets()
+	_, err = client.StartDownload(context.Background())
+	if !errors.Is(err, ErrNoTargets) {
+		t.Fatalf("expected ErrNoTargets, got %v", err)
+	}
+	if calls := l.Calls.Load(); calls != 3 {
+		t.Fatalf("expected to query Locate again after the reset, got %d", calls)
+	}
+}
+
+func TestRefreshDoesNotBlockRunningTests(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{Duration: 3 * time.Second})
+	defer s.Close()
+	l := &blockingLocator{
+		targets: []v2.Target{newTarget(s.Host())},
+		blocked: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	client.Locate = l
+	ch, err := client.StartDownload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+
+	// The only target has been tried, so the next download queries the
+	// Locator again while the first one is running.
+	done := make(chan error, 1)
+	go func() {
+		ch, err := client.StartDownload(context.Background())
+		if err == nil {
+			drain(ch)
+		}
+		done <- err
+	}()
+	<-l.blocked
+	timeout := time.After(2 * time.Second)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ch:
+		case <-timeout:
+			t.Fatal("the download stalled while refreshing the targets")
+		}
+	}
+	if client.Results()[spec.TestDownload] == nil {
+		t.Fatal("expected the download results")
+	}
+	close(l.release)
+	drain(ch)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if calls := l.calls.Load(); calls != 2 {
+		t.Fatalf("expected to query the Locator twice, got %d", calls)
+	}
+}
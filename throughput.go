@@ -0,0 +1,71 @@
+package ndt7
+
+import "github.com/m-lab/ndt7-client-go/spec"
+
+// setLatest stores m as the latest measurement for its origin. It sets the
+// throughput of m since the previous measurement of the same origin, and
+// appends it to the corresponding throughput series.
+func (lm *LatestMeasurements) setLatest(m *spec.Measurement) {
+	switch m.Origin {
+	case spec.OriginClient:
+		m.Throughput = clientThroughput(lm.Client.AppInfo, m.AppInfo)
+		if m.Throughput != nil {
+			lm.ClientThroughput = append(lm.ClientThroughput, *m.Throughput)
+		}
+		lm.Client = *m
+	case spec.OriginServer:
+		m.Throughput = serverThroughput(m.Test, lm.serverTCPInfo, m.TCPInfo)
+		if m.Throughput != nil {
+			lm.ServerThroughput = append(lm.ServerThroughput, *m.Throughput)
+		}
+		if m.TCPInfo != nil {
+			lm.serverTCPInfo = m.TCPInfo
+		}
+		lm.Server = *m
+	}
+}
+
+// clientThroughput returns the throughput between the prev and cur client
+// measurements, or nil if cur is missing. A nil prev means the beginning
+// of the test.
+func clientThroughput(prev, cur *spec.AppInfo) *spec.ThroughputSample {
+	if cur == nil {
+		return nil
+	}
+	if prev == nil {
+		prev = &spec.AppInfo{}
+	}
+	return throughputSample(prev.ElapsedTime, prev.NumBytes, cur.ElapsedTime, cur.NumBytes)
+}
+
+// serverThroughput is like clientThroughput but for the server measurements
+// of the given test, using the bytes acknowledged by the client, for the
+// download, or received by the server, for the upload.
+func serverThroughput(test spec.TestKind, prev, cur *spec.TCPInfo) *spec.ThroughputSample {
+	if cur == nil {
+		return nil
+	}
+	if prev == nil {
+		prev = &spec.TCPInfo{}
+	}
+	if test == spec.TestUpload {
+		return throughputSample(prev.ElapsedTime, prev.BytesReceived, cur.ElapsedTime, cur.BytesReceived)
+	}
+	return throughputSample(prev.ElapsedTime, prev.BytesAcked, cur.ElapsedTime, cur.BytesAcked)
+}
+
+// throughputSample returns the throughput between two measurements taken
+// at the given elapsed times, in microseconds, with the given cumulative
+// number of bytes. It returns nil if no time elapsed between the two.
+func throughputSample(prevElapsed, prevBytes, curElapsed, curBytes int64) *spec.ThroughputSample {
+	interval := curElapsed - prevElapsed
+	if interval <= 0 {
+		return nil
+	}
+	return &spec.ThroughputSample{
+		ElapsedTime: curElapsed,
+		Interval:    interval,
+		// Bits per microsecond are Mbit/s.
+		Throughput: 8 * float64(curBytes-prevBytes) / float64(interval),
+	}
+}
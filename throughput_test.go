@@ -0,0 +1,113 @@
+package ndt7
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/testingx"
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+func TestLatestMeasurementsClientThroughput(t *testing.T) {
+	lm := &LatestMeasurements{}
+	first := &spec.Measurement{
+		AppInfo: &spec.AppInfo{ElapsedTime: 250000, NumBytes: 1000000},
+		Origin:  spec.OriginClient,
+	}
+	lm.update(first)
+	second := &spec.Measurement{
+		AppInfo: &spec.AppInfo{ElapsedTime: 500000, NumBytes: 4000000},
+		Origin:  spec.OriginClient,
+	}
+	lm.update(second)
+	want := []spec.ThroughputSample{
+		{ElapsedTime: 250000, Interval: 250000, Throughput: 32},
+		{ElapsedTime: 500000, Interval: 250000, Throughput: 96},
+	}
+	if len(lm.ClientThroughput) != 2 || lm.ClientThroughput[0] != want[0] ||
+		lm.ClientThroughput[1] != want[1] {
+		t.Fatalf("unexpected series: %+v", lm.ClientThroughput)
+	}
+	if second.Throughput == nil || *second.Throughput != want[1] {
+		t.Fatalf("unexpected measurement throughput: %+v", second.Throughput)
+	}
+	// A measurement taken at the same time has no throughput.
+	third := &spec.Measurement{
+		AppInfo: &spec.AppInfo{ElapsedTime: 500000, NumBytes: 4000000},
+		Origin:  spec.OriginClient,
+	}
+	lm.update(third)
+	if third.Throughput != nil || len(lm.ClientThroughput) != 2 {
+		t.Fatalf("unexpected throughput: %+v", third.Throughput)
+	}
+}
+
+func TestLatestMeasurementsServerThroughput(t *testing.T) {
+	tests := []struct {
+		test    spec.TestKind
+		tcpInfo func(elapsed, bytes int64) *spec.TCPInfo
+	}{{
+		test: spec.TestDownload,
+		tcpInfo: func(elapsed, bytes int64) *spec.TCPInfo {
+			ti := &spec.TCPInfo{ElapsedTime: elapsed}
+			ti.BytesAcked = bytes
+			return ti
+		},
+	}, {
+		test: spec.TestUpload,
+		tcpInfo: func(elapsed, bytes int64) *spec.TCPInfo {
+			ti := &spec.TCPInfo{ElapsedTime: elapsed}
+			ti.BytesReceived = bytes
+			return ti
+		},
+	}}
+	for _, tt := range tests {
+		lm := &LatestMeasurements{}
+		lm.update(&spec.Measurement{
+			Origin:  spec.OriginServer,
+			Test:    tt.test,
+			TCPInfo: tt.tcpInfo(1000000, 1000000),
+		})
+		// Measurements without TCPInfo do not break the series.
+		lm.update(&spec.Measurement{
+			AppInfo: &spec.AppInfo{ElapsedTime: 1500000},
+			Origin:  spec.OriginServer,
+			Test:    tt.test,
+		})
+		lm.update(&spec.Measurement{
+			Origin:  spec.OriginServer,
+			Test:    tt.test,
+			TCPInfo: tt.tcpInfo(2000000, 3000000),
+		})
+		want := spec.ThroughputSample{ElapsedTime: 2000000, Interval: 1000000, Throughput: 16}
+		if len(lm.ServerThroughput) != 2 || lm.ServerThroughput[1] != want {
+			t.Fatalf("%s: unexpected series: %+v", tt.test, lm.ServerThroughput)
+		}
+	}
+}
+
+func TestThroughputWithServer(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{Duration: time.Second})
+	defer s.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	client.DownloadStreams = 2
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	aggregates := 0
+	for m := range ch {
+		if m.Origin == spec.OriginClient && m.Stream == 0 && m.Throughput != nil {
+			aggregates++
+		}
+	}
+	if aggregates == 0 {
+		t.Fatal("expected the aggregate measurements to have a throughput")
+	}
+	lm := client.Results()[spec.TestDownload]
+	if len(lm.ClientThroughput) != aggregates || len(lm.Streams[0].ClientThroughput) == 0 {
+		t.Fatalf("unexpected series: %d %d", len(lm.ClientThroughput), aggregates)
+	}
+}
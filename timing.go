@@ -0,0 +1,88 @@
+package ndt7
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// connectionTracer measures the phases of establishing a connection using
+// the httptrace hooks, which both the net package and websocket.Dialer
+// call. The net package may call them from multiple goroutines, e.g.,
+// when trying the IPv4 and IPv6 addresses of the server in parallel.
+type connectionTracer struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+}
+
+// newConnectionTracer returns a connectionTracer measuring from now.
+func newConnectionTracer() *connectionTracer {
+	return &connectionTracer{start: time.Now()}
+}
+
+// clientTrace returns the hooks recording the time of each event. For
+// events that may happen more than once, e.g., connecting to multiple
+// addresses, we keep the first start and the last end.
+func (t *connectionTracer) clientTrace() *httptrace.ClientTrace {
+	first := func(v *time.Time) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if v.IsZero() {
+			*v = time.Now()
+		}
+	}
+	last := func(v *time.Time) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		*v = time.Now()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { first(&t.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { last(&t.dnsDone) },
+		ConnectStart:      func(string, string) { first(&t.connectStart) },
+		ConnectDone:       func(string, string, error) { last(&t.connectDone) },
+		TLSHandshakeStart: func() { first(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { last(&t.tlsDone) },
+		GotConn:           func(httptrace.GotConnInfo) { last(&t.gotConn) },
+	}
+}
+
+// timing returns the duration of each phase, assuming that establishing
+// the connection ended now. The WebSocket upgrade starts once we have the
+// connection, including the TLS handshake, if any.
+func (t *connectionTracer) timing() *spec.ConnectionTiming {
+	end := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timing := &spec.ConnectionTiming{
+		DNS:          phase(t.dnsStart, t.dnsDone),
+		TCPConnect:   phase(t.connectStart, t.connectDone),
+		TLSHandshake: phase(t.tlsStart, t.tlsDone),
+		Total:        end.Sub(t.start).Microseconds(),
+	}
+	upgradeStart := t.gotConn
+	if t.tlsDone.After(upgradeStart) {
+		upgradeStart = t.tlsDone
+	}
+	timing.WebSocketUpgrade = phase(upgradeStart, end)
+	return timing
+}
+
+// phase returns the duration between start and end in microseconds, or
+// zero if the phase did not start or did not end.
+func phase(start, end time.Time) int64 {
+	if start.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start).Microseconds()
+}
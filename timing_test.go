@@ -0,0 +1,76 @@
+package ndt7
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/locate/api/locate"
+	"github.com/m-lab/locate/locatetest"
+	"github.com/m-lab/ndt-server/ndt7/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+func TestPhase(t *testing.T) {
+	start := time.Now()
+	if d := phase(start, start.Add(1500*time.Microsecond)); d != 1500 {
+		t.Fatalf("unexpected duration: %d", d)
+	}
+	if d := phase(time.Time{}, start); d != 0 {
+		t.Fatalf("expected zero when the phase did not start, got %d", d)
+	}
+	if d := phase(start, time.Time{}); d != 0 {
+		t.Fatalf("expected zero when the phase did not end, got %d", d)
+	}
+}
+
+func TestConnectionTracer(t *testing.T) {
+	tracer := newConnectionTracer()
+	base := time.Now().Add(-10 * time.Millisecond)
+	tracer.start = base
+	tracer.dnsStart, tracer.dnsDone = base, base.Add(time.Millisecond)
+	tracer.connectStart, tracer.connectDone = base.Add(time.Millisecond), base.Add(3*time.Millisecond)
+	tracer.gotConn = base.Add(3 * time.Millisecond)
+	timing := tracer.timing()
+	if timing.DNS != 1000 || timing.TCPConnect != 2000 || timing.TLSHandshake != 0 {
+		t.Fatalf("unexpected timing: %+v", timing)
+	}
+	if timing.WebSocketUpgrade != timing.Total-3000 {
+		t.Fatalf("expected the upgrade to start with the connection: %+v", timing)
+	}
+}
+
+func TestIntegrationConnectionTiming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping test in short mode")
+	}
+	h, fs := ndt7test.NewNDT7Server(t)
+	defer os.RemoveAll(h.DataDir)
+	defer fs.Close()
+	l := locatetest.NewLocateServerV2(newLocator(t, fs.URL))
+	u, err := url.Parse(l.URL + "/v2/nearest")
+	testingx.Must(t, err, "failed to parse locatetest url")
+	loc := locate.NewClient(MakeUserAgent(clientName, clientVersion))
+	loc.BaseURL = u
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = "ws"
+	client.Locate = loc
+	client.TestOptions.DownloadDuration = time.Second
+
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "download failed to start")
+	drain(ch)
+	timing := client.Results()[spec.TestDownload].ConnectionTiming
+	if timing == nil {
+		t.Fatal("expected the connection timing")
+	}
+	if timing.Locate <= 0 || timing.WebSocketUpgrade <= 0 || timing.TLSHandshake != 0 {
+		t.Fatalf("unexpected timing: %+v", timing)
+	}
+	if timing.Total < timing.Locate+timing.WebSocketUpgrade {
+		t.Fatalf("expected the total to include all the phases: %+v", timing)
+	}
+}
@@ -0,0 +1,178 @@
+package ndt7
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+var (
+	// ErrInvalidTLSOptions is returned if Client.TLS is not valid.
+	ErrInvalidTLSOptions = errors.New("invalid TLS options")
+
+	// ErrCertificatePin is returned if none of the certificates of the
+	// chain of a server matches TLSOptions.PinnedSPKI.
+	ErrCertificatePin = errors.New("no certificate matches the pinned public keys")
+)
+
+// TLSOptions customizes how the Client verifies the certificates of the
+// Locate API and of the ndt7 servers, and how it authenticates to them.
+// The zero value uses the system CAs and no client certificate.
+type TLSOptions struct {
+	// CAFile is the optional path of a PEM bundle containing the CAs
+	// trusted to verify the servers, instead of the system ones, e.g.,
+	// the internal CA of a private deployment.
+	CAFile string
+
+	// CertFile and KeyFile are the optional paths of the PEM certificate
+	// and private key used to authenticate the client (mTLS). They must
+	// be both set or both empty.
+	CertFile string
+	KeyFile  string
+
+	// PinnedSPKI contains the optional base64 encoded SHA-256 hashes of
+	// the SubjectPublicKeyInfo of trusted certificates. When set, the
+	// connection fails with ErrCertificatePin unless a certificate of the
+	// verified chain of the server matches one of them. The pins are also
+	// checked when Dialer.TLSClientConfig skips the verification, in which
+	// case either the certificate of the server matches one of them, or it
+	// must be signed by a certificate matching one of them, possibly via
+	// the intermediates presented by the server.
+	PinnedSPKI []string
+}
+
+// enabled returns whether o customizes the TLS configuration.
+func (o TLSOptions) enabled() bool {
+	return o.CAFile != "" || o.CertFile != "" || o.KeyFile != "" ||
+		len(o.PinnedSPKI) > 0
+}
+
+// validateTLS returns an error if c.TLS is not valid.
+func (c *Client) validateTLS() error {
+	if !c.TLS.enabled() {
+		return nil
+	}
+	_, err := c.tlsConfig(nil)
+	return err
+}
+
+// tlsConfig returns a copy of base, which may be nil, customized according
+// to c.TLS. It reads the CA bundle and the client certificate every time,
+// so changes apply to the next connection.
+func (c *Client) tlsConfig(base *tls.Config) (*tls.Config, error) {
+	if base == nil {
+		base = &tls.Config{}
+	}
+	config := base.Clone()
+	if c.TLS.CAFile != "" {
+		data, err := os.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidTLSOptions, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%w: no certificates in %s", ErrInvalidTLSOptions, c.TLS.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidTLSOptions, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if len(c.TLS.PinnedSPKI) > 0 {
+		pins := make(map[string]bool, len(c.TLS.PinnedSPKI))
+		for _, pin := range c.TLS.PinnedSPKI {
+			hash, err := base64.StdEncoding.DecodeString(pin)
+			if err != nil || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("%w: invalid pin %q", ErrInvalidTLSOptions, pin)
+			}
+			pins[pin] = true
+		}
+		config.VerifyConnection = verifyPins(pins, base.VerifyConnection)
+	}
+	return config, nil
+}
+
+// verifyPins returns a function for tls.Config.VerifyConnection checking
+// that a certificate presented by the server matches one of the pins, and
+// then calling next, if not nil.
+func verifyPins(pins map[string]bool,
+	next func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if !pinned(pins, cs) {
+			return ErrCertificatePin
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
+
+// pinned returns whether the chain of the server matches one of pins. We
+// cannot just look for a pinned certificate among the ones presented by
+// the server, since anyone can append a pinned CA certificate to their
+// own chain. When we verified the chain, we look into the verified chains.
+// Otherwise, i.e., with InsecureSkipVerify, we accept a pinned leaf, or a
+// leaf chaining up to a pinned certificate presented by the server.
+func pinned(pins map[string]bool, cs tls.ConnectionState) bool {
+	for _, chain := range cs.VerifiedChains {
+		for _, cert := range chain {
+			if pins[SPKIHash(cert)] {
+				return true
+			}
+		}
+	}
+	if len(cs.VerifiedChains) > 0 || len(cs.PeerCertificates) == 0 {
+		return false
+	}
+	leaf := cs.PeerCertificates[0]
+	if pins[SPKIHash(leaf)] {
+		return true
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		if pins[SPKIHash(cert)] {
+			roots.AddCert(cert)
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	return err == nil
+}
+
+// SPKIHash returns the base64 encoded SHA-256 hash of the
+// SubjectPublicKeyInfo of cert, as used by TLSOptions.PinnedSPKI.
+func SPKIHash(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// tlsInfo returns the parameters negotiated by conn, or nil if conn does
+// not use TLS.
+func tlsInfo(conn *websocket.Conn) *spec.TLSInfo {
+	tlsConn, ok := conn.NetConn().(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	cs := tlsConn.ConnectionState()
+	info := &spec.TLSInfo{
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+	}
+	if len(cs.PeerCertificates) > 0 {
+		info.PeerSubject = cs.PeerCertificates[0].Subject.String()
+	}
+	return info
+}
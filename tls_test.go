@@ -0,0 +1,268 @@
+package ndt7
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	testserver "github.com/m-lab/ndt7-client-go/ndt7test"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// writePEM writes the given PEM blocks to a new file in dir.
+func writePEM(t *testing.T, dir, name string, blocks ...*pem.Block) string {
+	var data []byte
+	for _, b := range blocks {
+		data = append(data, pem.EncodeToMemory(b)...)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newClientCertificate writes a self-signed certificate and its key to dir.
+func newClientCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ndt7-client-go test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = writePEM(t, dir, "client.pem", &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyFile = writePEM(t, dir, "client-key.pem", &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certFile, keyFile
+}
+
+// issueCertificate returns a new certificate for name and its key, signed
+// by parent using parentKey or, when parent is nil, self-signed.
+func issueCertificate(t *testing.T, name string, isCA bool,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// serverCertificate returns the certificate presented by s.
+func serverCertificate(t *testing.T, s *testserver.Server) *x509.Certificate {
+	conn, err := tls.Dial("tcp", s.Host(), s.ClientTLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0]
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newClientCertificate(t, dir)
+	client := NewClient(clientName, clientVersion)
+	client.TLS = TLSOptions{
+		CAFile:     certFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		PinnedSPKI: []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+	}
+	base := &tls.Config{ServerName: "ndt.example"}
+	config, err := client.tlsConfig(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config == base || config.ServerName != "ndt.example" {
+		t.Fatal("expected a copy of the base configuration")
+	}
+	if config.RootCAs == nil || len(config.Certificates) != 1 || config.VerifyConnection == nil {
+		t.Fatalf("unexpected configuration: %+v", config)
+	}
+	if base.RootCAs != nil || base.VerifyConnection != nil {
+		t.Fatal("expected the base configuration to be unchanged")
+	}
+}
+
+func TestTLSConfigInvalid(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newClientCertificate(t, dir)
+	empty := writePEM(t, dir, "empty.pem")
+	for _, options := range []TLSOptions{
+		{CAFile: filepath.Join(dir, "missing.pem")},
+		{CAFile: empty},
+		{CertFile: certFile},
+		{CertFile: keyFile, KeyFile: certFile},
+		{PinnedSPKI: []string{"not base64"}},
+		{PinnedSPKI: []string{"c2hvcnQ="}},
+	} {
+		client := NewClient(clientName, clientVersion)
+		client.TLS = options
+		if err := client.validate(); !errors.Is(err, ErrInvalidTLSOptions) {
+			t.Errorf("%+v: expected ErrInvalidTLSOptions, got %v", options, err)
+		}
+	}
+}
+
+func TestVerifyPins(t *testing.T) {
+	s := testserver.NewTLSServer(testserver.Config{})
+	defer s.Close()
+	cert := serverCertificate(t, s)
+	cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	pins := map[string]bool{SPKIHash(cert): true}
+	if err := verifyPins(pins, nil)(cs); err != nil {
+		t.Fatal(err)
+	}
+	mockedErr := errors.New("mocked error")
+	next := func(tls.ConnectionState) error { return mockedErr }
+	if err := verifyPins(pins, next)(cs); err != mockedErr {
+		t.Fatalf("expected the error of next, got %v", err)
+	}
+	if err := verifyPins(map[string]bool{}, nil)(cs); err != ErrCertificatePin {
+		t.Fatalf("expected ErrCertificatePin, got %v", err)
+	}
+}
+
+func TestVerifyPinsChain(t *testing.T) {
+	ca, caKey := issueCertificate(t, "pinned CA", true, nil, nil)
+	leaf, _ := issueCertificate(t, "server", false, ca, caKey)
+	// The attacker appends the pinned CA to the chain of its own CA.
+	evilCA, evilKey := issueCertificate(t, "attacker CA", true, nil, nil)
+	evil, _ := issueCertificate(t, "server", false, evilCA, evilKey)
+	pins := map[string]bool{SPKIHash(ca): true}
+	tests := []struct {
+		name string
+		cs   tls.ConnectionState
+		want error
+	}{{
+		name: "verified",
+		cs: tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{leaf},
+			VerifiedChains:   [][]*x509.Certificate{{leaf, ca}},
+		},
+	}, {
+		name: "verified attacker",
+		cs: tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{evil, evilCA, ca},
+			VerifiedChains:   [][]*x509.Certificate{{evil, evilCA}},
+		},
+		want: ErrCertificatePin,
+	}, {
+		name: "unverified",
+		cs:   tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf, ca}},
+	}, {
+		name: "unverified attacker",
+		cs:   tls.ConnectionState{PeerCertificates: []*x509.Certificate{evil, ca}},
+		want: ErrCertificatePin,
+	}, {
+		name: "unverified attacker with its CA",
+		cs:   tls.ConnectionState{PeerCertificates: []*x509.Certificate{evil, evilCA, ca}},
+		want: ErrCertificatePin,
+	}, {
+		name: "unverified without the pinned CA",
+		cs:   tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}},
+		want: ErrCertificatePin,
+	}}
+	for _, tt := range tests {
+		if err := verifyPins(pins, nil)(tt.cs); err != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestIntegrationTLSOptions(t *testing.T) {
+	s := testserver.NewTLSServer(testserver.Config{Duration: time.Second})
+	defer s.Close()
+	cert := serverCertificate(t, s)
+	caFile := writePEM(t, t.TempDir(), "ca.pem", &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	client.TLS = TLSOptions{CAFile: caFile, PinnedSPKI: []string{SPKIHash(cert)}}
+	ch, err := client.StartDownload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	drain(ch)
+	info := client.Results()[spec.TestDownload].TLS
+	if info == nil || info.Version == "" || info.CipherSuite == "" ||
+		info.PeerSubject != cert.Subject.String() {
+		t.Fatalf("unexpected TLS info: %+v", info)
+	}
+}
+
+func TestIntegrationCertificatePin(t *testing.T) {
+	s := testserver.NewTLSServer(testserver.Config{})
+	defer s.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	client.Dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	client.TLS.PinnedSPKI = []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}
+	_, err := client.StartDownload(context.Background())
+	var got *Error
+	if !errors.Is(err, ErrCertificatePin) || !errors.As(err, &got) || got.Kind != ErrorKindTLS {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTLSInfoPlain(t *testing.T) {
+	s := testserver.NewServer(testserver.Config{Duration: time.Second})
+	defer s.Close()
+	client := NewClient(clientName, clientVersion)
+	client.Scheme = s.Scheme()
+	client.Server = s.Host()
+	ch, err := client.StartDownload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	drain(ch)
+	if info := client.Results()[spec.TestDownload].TLS; info != nil {
+		t.Fatalf("expected no TLS info, got %+v", info)
+	}
+}
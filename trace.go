@@ -0,0 +1,158 @@
+package ndt7
+
+import (
+	"time"
+
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// Recorder records the measurements received by a Client during the tests,
+// e.g., into a trace file, so that a Replayer can replay them later. The
+// Client calls Record from multiple goroutines, one at a time.
+type Recorder interface {
+	Record(r spec.TraceRecord)
+}
+
+// Replayer returns the measurements recorded during previous tests. When
+// replaying, the tests do not connect to any server and emit the recorded
+// measurements as fast as possible, along with the recorded connection
+// timing, TLS parameters and server probes. Since the errors are not
+// recorded, the replayed tests, and their streams, do not fail.
+type Replayer interface {
+	// Next returns the records of the next run of the given test, in the
+	// order in which they were recorded, or an error if there is none.
+	Next(test spec.TestKind) ([]spec.TraceRecord, error)
+
+	// ServerProbes returns the recorded results of probing the servers,
+	// if any, which SelectServer returns when replaying.
+	ServerProbes() []spec.ServerProbe
+}
+
+// traceRecord returns the record of m, received during the test whose
+// results are lm. The first record of lm, and of each of its streams, also
+// contains how connecting to the server went. The caller must hold c.mu.
+func (c *Client) traceRecord(lm *LatestMeasurements, m *spec.Measurement) spec.TraceRecord {
+	r := spec.TraceRecord{
+		Time:        time.Now(),
+		Test:        lm.test,
+		Started:     lm.started,
+		Server:      c.FQDN,
+		Measurement: *m,
+	}
+	conn := lm
+	if m.Stream > 0 && m.Stream <= len(lm.Streams) {
+		conn = lm.Streams[m.Stream-1]
+	}
+	if !conn.traced {
+		conn.traced = true
+		r.ConnectionTiming = conn.ConnectionTiming
+		r.TLS = conn.TLS
+		if conn == lm {
+			r.ServerProbes = c.serverProbes
+		}
+	}
+	return r
+}
+
+// record records r using c.Recorder, if any. The caller must not hold
+// c.mu, since the Recorder may block, e.g., writing to a slow disk, and we
+// do not want to stall the other tests and the readers of the results.
+func (c *Client) record(r spec.TraceRecord) {
+	if c.Recorder == nil {
+		return
+	}
+	c.recordMu.Lock()
+	defer c.recordMu.Unlock()
+	c.Recorder.Record(r)
+}
+
+// replay is like start but emits the measurements of the next run of the
+// given test returned by c.Replayer. The results map contains the results
+// of each direction of the test, where we store the measurements.
+func (c *Client) replay(test spec.TestKind,
+	results map[spec.TestKind]*LatestMeasurements) (<-chan spec.Measurement, error) {
+	records, err := c.Replayer.Next(test)
+	if err != nil {
+		return nil, err
+	}
+	// Store the connection details before returning, like a test does,
+	// since the caller may read them as soon as the test starts.
+	c.mu.Lock()
+	if len(records) > 0 {
+		c.FQDN = records[0].Server
+	}
+	for _, r := range records {
+		if r.ServerProbes != nil {
+			c.serverProbes = r.ServerProbes
+		}
+		if lm, found := results[r.Measurement.Test]; found {
+			lm.replayConnection(&r)
+		}
+	}
+	c.mu.Unlock()
+	ch := make(chan spec.Measurement)
+	go func() {
+		defer close(ch)
+		for _, r := range records {
+			m := r.Measurement
+			lm, found := results[m.Test]
+			if !found {
+				continue
+			}
+			c.mu.Lock()
+			lm.replay(&m)
+			c.mu.Unlock()
+			ch <- m
+		}
+		c.mu.Lock()
+		for _, lm := range results {
+			lm.finishReplay(c.TestOptions, len(results))
+		}
+		c.mu.Unlock()
+	}()
+	return ch, nil
+}
+
+// replay stores m, which was recorded during a previous test, into lm like
+// such test did. The streams of lm are created as their measurements show up.
+func (lm *LatestMeasurements) replay(m *spec.Measurement) {
+	if m.Stream <= 0 {
+		lm.update(m)
+		return
+	}
+	for len(lm.Streams) < m.Stream {
+		lm.Streams = append(lm.Streams, &LatestMeasurements{})
+	}
+	lm.updateStream(m)
+}
+
+// replayConnection stores into lm, or into the stream of lm r belongs to,
+// how connecting to the server went, if r contains it.
+func (lm *LatestMeasurements) replayConnection(r *spec.TraceRecord) {
+	if r.ConnectionTiming == nil && r.TLS == nil {
+		return
+	}
+	conn := lm
+	if stream := r.Measurement.Stream; stream > 0 {
+		for len(lm.Streams) < stream {
+			lm.Streams = append(lm.Streams, &LatestMeasurements{})
+		}
+		conn = lm.Streams[stream-1]
+	}
+	conn.ConnectionTiming = r.ConnectionTiming
+	conn.TLS = r.TLS
+}
+
+// finishReplay sets the fields of lm that a test sets when it ends, using
+// the given options, once we replayed all its measurements. Without
+// multiple streams, the test ran at the same time as the given number of
+// directions.
+func (lm *LatestMeasurements) finishReplay(opts params.TestOptions, directions int) {
+	if lm.Streams == nil {
+		lm.BudgetLimited = budgetLimited(lm, opts)
+		lm.ClientLimited = clientLimited(lm, directions)
+		return
+	}
+	lm.finishStreams(streamOptions(opts, len(lm.Streams)))
+}
@@ -0,0 +1,238 @@
+package ndt7
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/ndt7-client-go/internal/params"
+	"github.com/m-lab/ndt7-client-go/internal/trace"
+	"github.com/m-lab/ndt7-client-go/internal/websocketx"
+	"github.com/m-lab/ndt7-client-go/spec"
+)
+
+// newRecordingClient returns a mocked client whose tests emit a server
+// measurement and two client measurements, recording them into buf.
+func newRecordingClient(buf *bytes.Buffer) *Client {
+	client := newMockedClient()
+	measure := func(test spec.TestKind) testFn {
+		return func(
+			ctx context.Context, conn websocketx.Conn, ch chan<- spec.Measurement,
+			opts params.TestOptions,
+		) error {
+			defer close(ch)
+			ch <- spec.Measurement{
+				ConnectionInfo: &spec.ConnectionInfo{UUID: string(test)},
+				Origin:         spec.OriginServer,
+				Test:           test,
+			}
+			for i := int64(1); i <= 2; i++ {
+				ch <- spec.Measurement{
+					AppInfo: &spec.AppInfo{ElapsedTime: i * 250000, NumBytes: i * 1000},
+					Origin:  spec.OriginClient,
+					Test:    test,
+				}
+			}
+			return nil
+		}
+	}
+	client.download = measure(spec.TestDownload)
+	client.upload = measure(spec.TestUpload)
+	client.Scheme = "ws"
+	client.Server = "127.0.0.1:8080"
+	client.Recorder = trace.NewWriter(buf)
+	return client
+}
+
+// newReplayingClient returns a client replaying the trace in buf.
+func newReplayingClient(t *testing.T, buf *bytes.Buffer) *Client {
+	records, err := trace.Read(buf)
+	testingx.Must(t, err, "failed to read the trace")
+	client := NewClient(clientName, clientVersion)
+	client.Replayer = trace.NewReplayer(records)
+	return client
+}
+
+// drain reads all the measurements from ch.
+func drain(ch <-chan spec.Measurement) []spec.Measurement {
+	var measurements []spec.Measurement
+	for m := range ch {
+		measurements = append(measurements, m)
+	}
+	return measurements
+}
+
+// sameResults returns whether the measurements stored into got and want,
+// and how their streams connected to the server, are the same.
+func sameResults(got, want *LatestMeasurements) bool {
+	if !reflect.DeepEqual(got.Client, want.Client) ||
+		!reflect.DeepEqual(got.Server, want.Server) ||
+		!reflect.DeepEqual(got.ConnectionInfo, want.ConnectionInfo) ||
+		!reflect.DeepEqual(got.ClientThroughput, want.ClientThroughput) ||
+		!sameConnection(got, want) || len(got.Streams) != len(want.Streams) {
+		return false
+	}
+	for i := range got.Streams {
+		if !sameConnection(got.Streams[i], want.Streams[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameConnection returns whether got and want connected to the server in
+// the same way.
+func sameConnection(got, want *LatestMeasurements) bool {
+	return want.ConnectionTiming != nil &&
+		reflect.DeepEqual(got.ConnectionTiming, want.ConnectionTiming) &&
+		reflect.DeepEqual(got.TLS, want.TLS)
+}
+
+func TestRecordReplay(t *testing.T) {
+	buf := &bytes.Buffer{}
+	client := newRecordingClient(buf)
+	client.UploadStreams = 2
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	recorded := drain(ch)
+	ch, err = client.StartUpload(context.Background())
+	testingx.Must(t, err, "failed to start upload")
+	drain(ch)
+	want := client.Results()
+
+	replaying := newReplayingClient(t, buf)
+	ch, err = replaying.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to replay download")
+	if replayed := drain(ch); !reflect.DeepEqual(replayed, recorded) {
+		t.Fatalf("unexpected measurements: %+v", replayed)
+	}
+	if replaying.FQDN != client.FQDN {
+		t.Fatalf("unexpected FQDN: %s", replaying.FQDN)
+	}
+	ch, err = replaying.StartUpload(context.Background())
+	testingx.Must(t, err, "failed to replay upload")
+	drain(ch)
+	got := replaying.Results()
+	for _, test := range []spec.TestKind{spec.TestDownload, spec.TestUpload} {
+		if !sameResults(got[test], want[test]) {
+			t.Fatalf("unexpected %s results: %+v", test, got[test])
+		}
+	}
+	if len(got[spec.TestUpload].Streams) != 2 {
+		t.Fatal("expected the upload streams to be replayed")
+	}
+	if _, err := replaying.StartDownload(context.Background()); !errors.Is(err, trace.ErrNoRecords) {
+		t.Fatalf("expected ErrNoRecords, got %v", err)
+	}
+}
+
+func TestRecordReplayBidirectional(t *testing.T) {
+	buf := &bytes.Buffer{}
+	client := newRecordingClient(buf)
+	ch, err := client.StartBidirectional(context.Background())
+	testingx.Must(t, err, "failed to start bidirectional test")
+	drain(ch)
+	want := client.BidirectionalResults()
+
+	replaying := newReplayingClient(t, buf)
+	if _, err := replaying.StartDownload(context.Background()); err == nil {
+		t.Fatal("expected no download to replay")
+	}
+	ch, err = replaying.StartBidirectional(context.Background())
+	testingx.Must(t, err, "failed to replay bidirectional test")
+	drain(ch)
+	got := replaying.BidirectionalResults()
+	for _, test := range []spec.TestKind{spec.TestDownload, spec.TestUpload} {
+		if !sameResults(got[test], want[test]) {
+			t.Fatalf("unexpected %s results: %+v", test, got[test])
+		}
+	}
+}
+
+func TestRecordReplayConnection(t *testing.T) {
+	buf := &bytes.Buffer{}
+	client := newRecordingClient(buf)
+	probes := []spec.ServerProbe{{Server: "a.example", RTT: 1000}}
+	client.mu.Lock()
+	client.serverProbes = probes
+	client.mu.Unlock()
+	tlsInfo := &spec.TLSInfo{Version: "TLS 1.3", CipherSuite: "TLS_AES_128_GCM_SHA256"}
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start download")
+	drain(ch)
+	// Mocked connections have no TLS, so pretend the records contain it.
+	records, err := trace.Read(buf)
+	testingx.Must(t, err, "failed to read the trace")
+	if records[0].ConnectionTiming == nil || !reflect.DeepEqual(records[0].ServerProbes, probes) {
+		t.Fatalf("expected the first record to contain the connection: %+v", records[0])
+	}
+	for _, r := range records[1:] {
+		if r.ConnectionTiming != nil || r.ServerProbes != nil {
+			t.Fatalf("expected only the first record to contain the connection: %+v", r)
+		}
+	}
+	records[0].TLS = tlsInfo
+
+	replaying := NewClient(clientName, clientVersion)
+	replaying.Replayer = trace.NewReplayer(records)
+	got, err := replaying.SelectServer(context.Background())
+	if err != nil || !reflect.DeepEqual(got, probes) {
+		t.Fatalf("unexpected server probes: %+v, %v", got, err)
+	}
+	ch, err = replaying.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to replay download")
+	// The connection is available as soon as the test starts.
+	lm := replaying.Results()[spec.TestDownload]
+	want := client.Results()[spec.TestDownload]
+	if !reflect.DeepEqual(lm.ConnectionTiming, want.ConnectionTiming) ||
+		!reflect.DeepEqual(lm.TLS, tlsInfo) {
+		t.Fatalf("unexpected connection: %+v, %+v", lm.ConnectionTiming, lm.TLS)
+	}
+	drain(ch)
+	if got := replaying.ServerProbes(); !reflect.DeepEqual(got, probes) {
+		t.Fatalf("unexpected server probes: %+v", got)
+	}
+}
+
+// blockingRecorder is a Recorder whose Record blocks until release is
+// closed, e.g., like writing to a stalled disk.
+type blockingRecorder struct {
+	blocked chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (r *blockingRecorder) Record(spec.TraceRecord) {
+	r.once.Do(func() { close(r.blocked) })
+	<-r.release
+}
+
+func TestRecordDoesNotBlockResults(t *testing.T) {
+	client := newRecordingClient(&bytes.Buffer{})
+	recorder := &blockingRecorder{
+		blocked: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	client.Recorder = recorder
+	ch, err := client.StartDownload(context.Background())
+	testingx.Must(t, err, "failed to start the download")
+	<-recorder.blocked
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Results()
+		client.ProxyUsed()
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reading the results stalled while recording")
+	}
+	close(recorder.release)
+	drain(ch)
+}